@@ -7,6 +7,7 @@ import (
 
 	"github.com/pmatteo/friendlymongo"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type userProfile struct {
@@ -77,12 +78,13 @@ func main() {
 
 	// Replace the user
 	foundUser.Name = "replace"
-	err = repo.ReplaceOne(context.Background(), filter, foundUser)
+	replacedUser, err := repo.ReplaceOne(context.Background(), filter, foundUser,
+		friendlymongo.WithReturnDocument(options.After))
 	if err != nil {
 		panic(err)
 	}
 
-	fmt.Printf("replaced user with model %v\n", foundUser)
+	fmt.Printf("replaced user with model %v\n", replacedUser)
 
 	// Delete the user
 	deleted, err := repo.Delete(context.Background(), filter)
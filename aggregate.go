@@ -0,0 +1,206 @@
+package friendlymongo
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AggregateSource is a repository Aggregate and AggregateOne can run on. It is implemented by *BaseRepository and
+// *MemoryRepository, and cannot be implemented outside this package.
+type AggregateSource interface {
+	aggregateRaw(ctx context.Context, op string, pipeline mongo.Pipeline, o *queryOpts) ([]bson.Raw, error)
+	codecRegistry() *bsoncodec.Registry
+	collectionName() string
+}
+
+// Aggregate runs an aggregation pipeline on the collection of r and decodes the resulting documents as R.
+//
+// The pipeline can be a *StageBuilder or a mongo.Pipeline. Aggregate accepts the WithAllowDiskUse, WithBatchSize,
+// WithMaxTime, WithCollation, WithHint, WithComment and WithLet options.
+//
+//	type artistCount struct {
+//		Artist string `bson:"_id"`
+//		Count  int    `bson:"count"`
+//	}
+//	counts, err := friendlymongo.Aggregate[artistCount](ctx, repo, friendlymongo.NewStageBuilder().
+//		Group("byArtist", bson.M{"_id": "$artist", "count": bson.M{"$sum": 1}}))
+func Aggregate[R any](
+	ctx context.Context,
+	r AggregateSource,
+	pipeline interface{},
+	opts ...QueryOptsFunc,
+) ([]R, error) {
+
+	raws, err := runAggregate(ctx, "Aggregate", r, pipeline, false, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]R, 0, len(raws))
+	for _, raw := range raws {
+		var result R
+		if err := bson.UnmarshalWithRegistry(r.codecRegistry(), raw, &result); err != nil {
+			return nil, wrapError("Aggregate", r.collectionName(), nil, nil, err)
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// AggregateOne runs an aggregation pipeline on the collection of r and decodes its first resulting document as R. It
+// returns an error matching ErrNotFound when the pipeline yields no document. See Aggregate.
+func AggregateOne[R any](
+	ctx context.Context,
+	r AggregateSource,
+	pipeline interface{},
+	opts ...QueryOptsFunc,
+) (R, error) {
+
+	var result R
+
+	raws, err := runAggregate(ctx, "AggregateOne", r, pipeline, true, opts)
+	if err != nil {
+		return result, err
+	}
+	if len(raws) == 0 {
+		return result, wrapError("AggregateOne", r.collectionName(), nil, nil, mongo.ErrNoDocuments)
+	}
+
+	if err := bson.UnmarshalWithRegistry(r.codecRegistry(), raws[0], &result); err != nil {
+		return result, wrapError("AggregateOne", r.collectionName(), nil, nil, err)
+	}
+
+	return result, nil
+}
+
+// runAggregate runs pipeline on r, limited to its first document when one is true.
+func runAggregate(
+	ctx context.Context,
+	op string,
+	r AggregateSource,
+	pipeline interface{},
+	one bool,
+	opts []QueryOptsFunc,
+) ([]bson.Raw, error) {
+
+	o, err := newQueryOpts(op, opts, aggregateSupported...)
+	if err != nil {
+		return nil, wrapError(op, r.collectionName(), nil, nil, err)
+	}
+
+	p, err := pipelineOf(pipeline)
+	if err != nil {
+		return nil, wrapError(op, r.collectionName(), nil, nil, err)
+	}
+	if one {
+		p = append(p[:len(p):len(p)], bson.D{{Key: "$limit", Value: 1}})
+	}
+
+	return r.aggregateRaw(ctx, op, p, o)
+}
+
+// pipelineOf returns the stages of pipeline, a *StageBuilder, a mongo.Pipeline or nil.
+func pipelineOf(pipeline interface{}) (mongo.Pipeline, error) {
+
+	switch p := pipeline.(type) {
+	case nil:
+		return mongo.Pipeline{}, nil
+	case *StageBuilder:
+		return p.Build(), nil
+	case mongo.Pipeline:
+		return p, nil
+	case []bson.D:
+		return p, nil
+	default:
+		return nil, fmt.Errorf("pipeline must be a *StageBuilder or a mongo.Pipeline, got %T", pipeline)
+	}
+}
+
+func (r *BaseRepository[T]) aggregateRaw(
+	ctx context.Context,
+	kind string,
+	pipeline mongo.Pipeline,
+	o *queryOpts,
+) ([]bson.Raw, error) {
+
+	op := &Operation{Kind: kind, Collection: r.collection.Name(), Pipeline: pipeline}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) ([]bson.Raw, error) {
+		p, err := pipelineOf(op.Pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.aggregateAll(ctx, op.Kind, p, o)
+	})
+}
+
+func (r *BaseRepository[T]) aggregateAll(
+	ctx context.Context,
+	op string,
+	pipeline mongo.Pipeline,
+	o *queryOpts,
+) (_ []bson.Raw, err error) {
+
+	defer r.wrapErr(&err, op, nil)
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline, o.aggregateOptions())
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	var raws []bson.Raw
+	for cursor.Next(ctx) {
+		raws = append(raws, append(bson.Raw(nil), cursor.Current...))
+	}
+
+	return raws, cursor.Err()
+}
+
+func (r *MemoryRepository[T]) aggregateRaw(
+	ctx context.Context,
+	kind string,
+	pipeline mongo.Pipeline,
+	o *queryOpts,
+) ([]bson.Raw, error) {
+
+	op := &Operation{Kind: kind, Pipeline: pipeline}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) ([]bson.Raw, error) {
+		p, err := pipelineOf(op.Pipeline)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.aggregateAll(op.Kind, p, o)
+	})
+}
+
+func (r *MemoryRepository[T]) aggregateAll(op string, pipeline mongo.Pipeline, o *queryOpts) (_ []bson.Raw, err error) {
+
+	defer r.wrapErr(&err, op, nil)
+
+	if o.let != nil {
+		return nil, fmt.Errorf("option let is not supported by MemoryRepository")
+	}
+
+	docs, err := r.aggregate(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	raws := make([]bson.Raw, 0, len(docs))
+	for _, doc := range docs {
+		raw, err := bson.MarshalWithRegistry(r.registry, doc)
+		if err != nil {
+			return nil, err
+		}
+		raws = append(raws, raw)
+	}
+
+	return raws, nil
+}
@@ -0,0 +1,77 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type artistCount struct {
+	Artist string `bson:"_id"`
+	Count  int    `bson:"count"`
+}
+
+func TestAggregateTyped(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newCountArtworkRepo(t, "aggregate_typed")
+
+	counts, err := fm.Aggregate[artistCount](context.Background(), r, fm.NewStageBuilder().
+		Group("stg1", bson.M{"_id": "$artist", "count": bson.M{"$sum": 1}}).
+		Sort("stg2", bson.M{"_id": 1}),
+		fm.WithAllowDiskUse(true), fm.WithBatchSize(1))
+	require.NoError(t, err)
+	assert.Equal(t, []artistCount{{Artist: "Monet", Count: 2}, {Artist: "Van Gogh", Count: 1}}, counts)
+
+	recent, err := fm.Aggregate[*artwork](context.Background(), r, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$expr": bson.M{"$gt": bson.A{"$year", "$$minYear"}}}}},
+		{{Key: "$sort", Value: bson.M{"year": 1}}},
+	}, fm.WithLet(bson.M{"minYear": 1880}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"The Starry Night", "Water Lilies"}, titlesOf(recent))
+
+	_, err = fm.Aggregate[bson.M](context.Background(), r, bson.M{"$match": bson.M{}})
+	assert.Error(t, err)
+
+	_, err = fm.Aggregate[int](context.Background(), r, nil)
+	var fmErr *fm.Error
+	require.ErrorAs(t, err, &fmErr)
+	assert.Equal(t, "Aggregate", fmErr.Op)
+	assert.Equal(t, "aggregate_typed", fmErr.Collection, "decode errors report the collection")
+
+	_, err = fm.Aggregate[bson.M](context.Background(), r, nil, fm.WithLimit(1))
+	assert.Error(t, err)
+}
+
+func TestAggregateOne(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newCountArtworkRepo(t, "aggregate_one")
+
+	top, err := fm.AggregateOne[artistCount](context.Background(), r, fm.NewStageBuilder().
+		Group("stg1", bson.M{"_id": "$artist", "count": bson.M{"$sum": 1}}).
+		Sort("stg2", bson.M{"count": -1}))
+	require.NoError(t, err)
+	assert.Equal(t, artistCount{Artist: "Monet", Count: 2}, top)
+
+	_, err = fm.AggregateOne[artistCount](context.Background(), r, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"artist": "Klimt"}}},
+	})
+	assert.ErrorIs(t, err, fm.ErrNotFound)
+
+	var fmErr *fm.Error
+	require.ErrorAs(t, err, &fmErr)
+	assert.Equal(t, "aggregate_one", fmErr.Collection)
+}
+
+// cheapest shows that AggregateSource can be named by helpers running pipelines on any repository.
+func cheapest(ctx context.Context, r fm.AggregateSource) (*artwork, error) {
+	return fm.AggregateOne[*artwork](ctx, r, mongo.Pipeline{{{Key: "$sort", Value: bson.M{"price": 1}}}})
+}
@@ -0,0 +1,483 @@
+package friendlymongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BulkResult is the merged outcome of the operations of a Bulk.
+type BulkResult struct {
+	InsertedCount int64
+	MatchedCount  int64
+	ModifiedCount int64
+	DeletedCount  int64
+	UpsertedCount int64
+	// InsertedIDs maps the index of each successful Insert, in the order the operations were added, to its _id.
+	InsertedIDs map[int]interface{}
+	// UpsertedIDs maps the index of each operation that inserted a document through an upsert to its _id.
+	UpsertedIDs map[int]interface{}
+	// Errors lists the operations that failed, by increasing index. With ordered bulks, the operations following the
+	// first failure are not run.
+	Errors []BulkError
+}
+
+// BulkError is the failure of one of the operations of a Bulk.
+type BulkError struct {
+	// Index is the index of the operation, in the order the operations were added.
+	Index int
+	// Err is the error of the operation, e.g. matching ErrDuplicateKey.
+	Err error
+}
+
+type bulkKind int
+
+const (
+	bulkInsert bulkKind = iota
+	bulkUpdateOne
+	bulkUpdateMany
+	bulkReplaceOne
+	bulkDeleteOne
+	bulkDeleteMany
+)
+
+// bulkOp is an operation of a Bulk, with its documents already encoded.
+type bulkOp struct {
+	kind     bulkKind
+	filter   interface{}
+	document interface{}
+	upsert   bool
+}
+
+// bulkTarget is implemented by the repositories a Bulk runs on.
+type bulkTarget interface {
+	bulkWrite(ctx context.Context, ops []bulkOp, ordered bool, o *queryOpts) (*BulkResult, error)
+}
+
+// Bulk builds a batch of inserts, updates, replaces and deletes, sent to the server in a single BulkWrite. The
+// lifecycle hooks of the models run as the operations are added.
+//
+//	res, err := repo.Bulk().
+//		Insert(newUser).
+//		UpdateOne(bson.M{"email": email}, bson.M{"$set": bson.M{"active": true}}).
+//		DeleteMany(bson.M{"active": false}).
+//		Execute(ctx, friendlymongo.WithOrdered(false))
+type Bulk[T Model] struct {
+	target        bulkTarget
+	collection    string
+	registry      *bsoncodec.Registry
+	discriminator *Discriminator
+	encryption    *fieldEncryption
+	interceptors  []Interceptor
+
+	ops    []bulkOp
+	models []interface{}
+	err    error
+}
+
+// Bulk creates an empty Bulk on the collection.
+func (r *BaseRepository[T]) Bulk() *Bulk[T] {
+
+	return &Bulk[T]{
+		target:        r,
+		collection:    r.collection.Name(),
+		registry:      r.registry,
+		discriminator: r.discriminator,
+		encryption:    r.encryption,
+		interceptors:  r.interceptors,
+	}
+}
+
+// Insert adds the insertion of document, after invoking OnCreate on it. Documents without an _id are given an
+// ObjectID.
+func (b *Bulk[T]) Insert(document T) *Bulk[T] {
+
+	runHooks(document, onCreate)
+	b.models = append(b.models, document)
+
+	raw, err := b.marshal(document)
+	return b.add(bulkOp{kind: bulkInsert, document: withRawID(raw)}, err)
+}
+
+// UpdateOne adds the update of the first document matching filter. As with BaseRepository.UpdateOne, the update
+// parameter is either a bson.M of update operators or an UpdateBuilder, which also set updatedAt, or a Model whose
+// fields are set after invoking OnUpdate on it.
+func (b *Bulk[T]) UpdateOne(filter interface{}, update interface{}) *Bulk[T] {
+
+	u, err := b.update(update, true)
+	return b.add(bulkOp{kind: bulkUpdateOne, filter: filter, document: u}, err)
+}
+
+// UpdateMany adds the update of all the documents matching filter. The update parameter must be a bson.M of update
+// operators or an UpdateBuilder.
+func (b *Bulk[T]) UpdateMany(filter interface{}, update interface{}) *Bulk[T] {
+
+	u, err := b.update(update, false)
+	return b.add(bulkOp{kind: bulkUpdateMany, filter: filter, document: u}, err)
+}
+
+// ReplaceOne adds the replacement of the first document matching filter, after invoking OnReplace on replacement.
+func (b *Bulk[T]) ReplaceOne(filter interface{}, replacement T) *Bulk[T] {
+
+	runHooks(replacement, onReplace)
+	b.models = append(b.models, replacement)
+
+	raw, err := b.marshal(replacement)
+	return b.add(bulkOp{kind: bulkReplaceOne, filter: filter, document: raw}, err)
+}
+
+// Upsert adds the update of the first document matching filter with the fields of document, or its insertion when
+// none matches. See BaseRepository.UpsertOne.
+func (b *Bulk[T]) Upsert(filter interface{}, document T) *Bulk[T] {
+
+	b.models = append(b.models, document)
+
+	update, err := upsertUpdate(b.registry, b.discriminator, document, true)
+	return b.add(bulkOp{kind: bulkUpdateOne, filter: filter, document: update, upsert: true}, err)
+}
+
+// DeleteOne adds the deletion of the first document matching filter.
+func (b *Bulk[T]) DeleteOne(filter interface{}) *Bulk[T] {
+
+	return b.add(bulkOp{kind: bulkDeleteOne, filter: filter}, nil)
+}
+
+// DeleteMany adds the deletion of all the documents matching filter.
+func (b *Bulk[T]) DeleteMany(filter interface{}) *Bulk[T] {
+
+	return b.add(bulkOp{kind: bulkDeleteMany, filter: filter}, nil)
+}
+
+// Len returns the number of operations added.
+func (b *Bulk[T]) Len() int {
+
+	return len(b.ops)
+}
+
+// Execute runs the operations in a single BulkWrite. Ordered bulks, the default, stop at the first failure; with
+// WithOrdered(false) every operation is attempted. Execute also accepts WithComment.
+//
+// The result is returned also when some operations failed, and the returned error is the one of the first failed
+// operation. No operation is run if one of them could not be built, e.g. because of an invalid update.
+func (b *Bulk[T]) Execute(ctx context.Context, opts ...QueryOptsFunc) (*BulkResult, error) {
+
+	o, err := newQueryOpts("BulkWrite", opts, bulkSupported...)
+	if err != nil {
+		return nil, wrapError("BulkWrite", "", nil, nil, err)
+	}
+	ordered, err := o.isOrdered()
+	if err != nil {
+		return nil, wrapError("BulkWrite", "", nil, nil, err)
+	}
+	if b.err != nil {
+		return nil, wrapError("BulkWrite", "", nil, nil, b.err)
+	}
+
+	if len(b.ops) == 0 {
+		return newBulkResult(), nil
+	}
+
+	op := &Operation{Kind: "BulkWrite", Collection: b.collection, Documents: b.models}
+	return intercept(ctx, b.interceptors, op, func(ctx context.Context, op *Operation) (*BulkResult, error) {
+		return b.target.bulkWrite(ctx, b.ops, ordered, o)
+	})
+}
+
+func (b *Bulk[T]) add(op bulkOp, err error) *Bulk[T] {
+
+	if err != nil && b.err == nil {
+		b.err = fmt.Errorf("operation %d: %w", len(b.ops), err)
+	}
+	b.ops = append(b.ops, op)
+
+	return b
+}
+
+func (b *Bulk[T]) marshal(document T) (bson.Raw, error) {
+
+	return marshalDocument(b.registry, b.discriminator, document)
+}
+
+// update returns the update document of an UpdateOne, when model is true, or of an UpdateMany.
+func (b *Bulk[T]) update(update interface{}, model bool) (interface{}, error) {
+
+	switch u := update.(type) {
+	case T:
+		if !model {
+			break
+		}
+
+		runHooks(u, onUpdate)
+		b.models = append(b.models, u)
+
+		raw, err := b.marshal(u)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "$set", Value: raw}}, nil
+	case bson.M:
+		return b.encryption.sealUpdate(b.registry, withUpdatedAt(u))
+	case UpdateBuilder:
+		built, err := buildUpdate(u)
+		if err != nil {
+			return nil, err
+		}
+		return b.encryption.sealUpdate(b.registry, built)
+	}
+
+	if model {
+		return nil, fmt.Errorf("%w: must be a bson.M, an UpdateBuilder or a Model, got %T", ErrInvalidUpdate, update)
+	}
+	return nil, fmt.Errorf("%w: must be a bson.M or an UpdateBuilder, got %T", ErrInvalidUpdate, update)
+}
+
+func newBulkResult() *BulkResult {
+
+	return &BulkResult{InsertedIDs: map[int]interface{}{}, UpsertedIDs: map[int]interface{}{}}
+}
+
+func (r *BaseRepository[T]) bulkWrite(
+	ctx context.Context,
+	ops []bulkOp,
+	ordered bool,
+	o *queryOpts,
+) (_ *BulkResult, err error) {
+
+	defer r.wrapErr(&err, "BulkWrite", nil)
+	defer r.invalidate(ctx)
+
+	models := make([]mongo.WriteModel, len(ops))
+	for i, op := range ops {
+		switch op.kind {
+		case bulkInsert:
+			models[i] = mongo.NewInsertOneModel().SetDocument(op.document)
+		case bulkUpdateOne:
+			models[i] = mongo.NewUpdateOneModel().SetFilter(op.filter).SetUpdate(op.document).SetUpsert(op.upsert)
+		case bulkUpdateMany:
+			models[i] = mongo.NewUpdateManyModel().SetFilter(op.filter).SetUpdate(op.document)
+		case bulkReplaceOne:
+			models[i] = mongo.NewReplaceOneModel().SetFilter(op.filter).SetReplacement(op.document)
+		case bulkDeleteOne:
+			models[i] = mongo.NewDeleteOneModel().SetFilter(op.filter)
+		case bulkDeleteMany:
+			models[i] = mongo.NewDeleteManyModel().SetFilter(op.filter)
+		}
+	}
+
+	opts := options.BulkWrite().SetOrdered(ordered)
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+
+	res, err := r.collection.BulkWrite(ctx, models, opts)
+
+	var bwe mongo.BulkWriteException
+	if err != nil && (!errors.As(err, &bwe) || len(bwe.WriteErrors) == 0) {
+		return nil, err
+	}
+
+	result := newBulkResult()
+	if res != nil {
+		result.InsertedCount = res.InsertedCount
+		result.MatchedCount = res.MatchedCount
+		result.ModifiedCount = res.ModifiedCount
+		result.DeletedCount = res.DeletedCount
+		result.UpsertedCount = res.UpsertedCount
+		for i, id := range res.UpsertedIDs {
+			result.UpsertedIDs[int(i)] = id
+		}
+	}
+
+	firstFailure := len(ops)
+	for _, we := range bwe.WriteErrors {
+		e := mongo.WriteException{WriteErrors: mongo.WriteErrors{we.WriteError}, Labels: bwe.Labels}
+		err := wrapError("BulkWrite", r.collection.Name(), r.registry, ops[we.Index].filter, e)
+		result.Errors = append(result.Errors, BulkError{Index: we.Index, Err: err})
+		firstFailure = min(firstFailure, we.Index)
+	}
+
+	for i, op := range ops {
+		if op.kind != bulkInsert || (ordered && i >= firstFailure) || failedAt(result.Errors, i) {
+			continue
+		}
+
+		var id interface{}
+		_ = op.document.(bson.Raw).Lookup("_id").Unmarshal(&id)
+		result.InsertedIDs[i] = id
+	}
+
+	if len(result.Errors) > 0 {
+		return result, result.Errors[0].Err
+	}
+
+	return result, nil
+}
+
+func failedAt(errs []BulkError, index int) bool {
+
+	for _, e := range errs {
+		if e.Index == index {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Bulk creates an empty Bulk on the repository. See BaseRepository.Bulk.
+func (r *MemoryRepository[T]) Bulk() *Bulk[T] {
+
+	return &Bulk[T]{
+		target:        r,
+		registry:      r.registry,
+		discriminator: r.discriminator,
+		encryption:    r.encryption,
+		interceptors:  r.interceptors,
+	}
+}
+
+func (r *MemoryRepository[T]) bulkWrite(
+	_ context.Context,
+	ops []bulkOp,
+	ordered bool,
+	_ *queryOpts,
+) (_ *BulkResult, err error) {
+
+	defer r.wrapErr(&err, "BulkWrite", nil)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := newBulkResult()
+	now := time.Now()
+	for i, op := range ops {
+		if err := r.applyBulkOp(res, i, op, now); err != nil {
+			err = wrapError("BulkWrite", "", r.registry, op.filter, err)
+			res.Errors = append(res.Errors, BulkError{Index: i, Err: err})
+			if ordered {
+				break
+			}
+		}
+	}
+
+	if len(res.Errors) > 0 {
+		return res, res.Errors[0].Err
+	}
+
+	return res, nil
+}
+
+// applyBulkOp runs the operation at index i of a bulk and records its outcome in res.
+func (r *MemoryRepository[T]) applyBulkOp(res *BulkResult, i int, op bulkOp, now time.Time) error {
+
+	limit := 0
+	if op.kind == bulkUpdateOne || op.kind == bulkReplaceOne || op.kind == bulkDeleteOne {
+		limit = 1
+	}
+
+	switch op.kind {
+	case bulkInsert:
+		var doc bson.D
+		if err := bson.Unmarshal(op.document.(bson.Raw), &doc); err != nil {
+			return err
+		}
+		if err := r.insert(doc); err != nil {
+			return err
+		}
+
+		res.InsertedCount++
+		res.InsertedIDs[i], _ = lookupKey(doc, "_id")
+		return nil
+
+	case bulkUpdateOne, bulkUpdateMany:
+		update, err := r.normalize(op.document)
+		if err != nil {
+			return err
+		}
+		idx, err := r.match(op.filter, limit)
+		if err != nil {
+			return err
+		}
+
+		if len(idx) == 0 && op.upsert {
+			f, err := r.normalize(op.filter)
+			if err != nil {
+				return err
+			}
+			doc, err := upsertDocument(f)
+			if err != nil {
+				return err
+			}
+			if doc, err = applyUpdate(doc, update, now, true); err != nil {
+				return err
+			}
+			if _, ok := lookupKey(doc, "_id"); !ok {
+				doc = withID(doc, primitive.NewObjectID())
+			}
+			if err := r.insert(doc); err != nil {
+				return err
+			}
+
+			res.UpsertedCount++
+			res.UpsertedIDs[i], _ = lookupKey(doc, "_id")
+			return nil
+		}
+
+		updated := make([]bson.D, len(idx))
+		for n, j := range idx {
+			if updated[n], err = applyUpdate(cloneDocument(r.documents[j]), update, now, false); err != nil {
+				return err
+			}
+		}
+		for n, j := range idx {
+			res.MatchedCount++
+			if !reflect.DeepEqual(r.documents[j], updated[n]) {
+				res.ModifiedCount++
+			}
+			r.documents[j] = updated[n]
+		}
+		return nil
+
+	case bulkReplaceOne:
+		var doc bson.D
+		if err := bson.Unmarshal(op.document.(bson.Raw), &doc); err != nil {
+			return err
+		}
+		idx, err := r.match(op.filter, limit)
+		if err != nil || len(idx) == 0 {
+			return err
+		}
+
+		old := r.documents[idx[0]]
+		id, _ := lookupKey(old, "_id")
+		if newID, ok := lookupKey(doc, "_id"); ok && !valuesEqual(id, newID) {
+			return fmt.Errorf("the _id field cannot be changed from %v to %v", id, newID)
+		}
+
+		replaced := withID(doc, id)
+		res.MatchedCount++
+		if !reflect.DeepEqual(old, replaced) {
+			res.ModifiedCount++
+		}
+		r.documents[idx[0]] = replaced
+		return nil
+
+	default:
+		idx, err := r.match(op.filter, limit)
+		if err != nil {
+			return err
+		}
+
+		r.remove(idx)
+		res.DeletedCount += int64(len(idx))
+		return nil
+	}
+}
@@ -0,0 +1,90 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBulk(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "bulk")
+	ctx := context.Background()
+
+	_, err := r.InsertMany(ctx, []*artwork{
+		{Title: "Water Lilies", Artist: "Monet", Year: 1906},
+		{Title: "Impression, Sunrise", Artist: "Monet", Year: 1872},
+		{Title: "The Starry Night", Artist: "Van Gogh", Year: 1889},
+	})
+	require.NoError(t, err)
+
+	inserted := &artwork{Title: "The Scream", Artist: "Munch", Year: 1893}
+	upserted := &artwork{Title: "The Kiss", Artist: "Klimt", Year: 1908}
+	res, err := r.Bulk().
+		Insert(inserted).
+		UpdateOne(bson.M{"title": "Water Lilies"}, bson.M{"$set": bson.M{"year": 1919}}).
+		UpdateMany(bson.M{"artist": "Monet"}, bson.M{"$inc": bson.M{"price": 10}}).
+		ReplaceOne(bson.M{"title": "The Starry Night"}, &artwork{Title: "Starry Night", Artist: "Van Gogh"}).
+		Upsert(bson.M{"title": "The Kiss"}, upserted).
+		DeleteOne(bson.M{"title": "Impression, Sunrise"}).
+		Execute(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, int64(1), res.InsertedCount)
+	assert.Equal(t, int64(4), res.MatchedCount)
+	assert.Equal(t, int64(4), res.ModifiedCount)
+	assert.Equal(t, int64(1), res.UpsertedCount)
+	assert.Equal(t, int64(1), res.DeletedCount)
+	assert.Equal(t, map[int]interface{}{0: inserted.ID}, res.InsertedIDs)
+	assert.Contains(t, res.UpsertedIDs, 4)
+	assert.NotZero(t, inserted.CreatedAt)
+
+	found, err := r.Find(ctx, bson.M{}, fm.WithSort(bson.M{"title": 1}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Starry Night", "The Kiss", "The Scream", "Water Lilies"}, titlesOf(found))
+	assert.Equal(t, 1919, found[3].Year)
+	assert.Equal(t, float32(10), found[3].Price)
+}
+
+func TestBulk_Failures(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "bulk_failures")
+	ctx := context.Background()
+
+	dup := &artwork{Title: "first"}
+	require.NoError(t, r.InsertOne(ctx, dup))
+
+	res, err := r.Bulk().
+		Insert(&artwork{Title: "a"}).
+		Insert(&artwork{BaseModel: fm.BaseModel{ID: dup.ID}}).
+		Insert(&artwork{Title: "b"}).
+		Execute(ctx)
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+	assert.Len(t, res.InsertedIDs, 1)
+	require.Len(t, res.Errors, 1)
+	assert.Equal(t, 1, res.Errors[0].Index)
+
+	res, err = r.Bulk().
+		Insert(&artwork{Title: "c"}).
+		Insert(&artwork{BaseModel: fm.BaseModel{ID: dup.ID}}).
+		DeleteMany(bson.M{"title": "a"}).
+		Execute(ctx, fm.WithOrdered(false))
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+	assert.Len(t, res.InsertedIDs, 1)
+	assert.Equal(t, int64(1), res.DeletedCount)
+
+	_, err = r.Bulk().UpdateOne(bson.M{}, "invalid").Execute(ctx)
+	assert.ErrorIs(t, err, fm.ErrInvalidUpdate)
+
+	count, err := r.Count(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
@@ -0,0 +1,192 @@
+package friendlymongo
+
+import (
+	"context"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// findByIDsChunkSize is the maximum number of ids of each $in query run by FindByIDs.
+const findByIDsChunkSize = 1000
+
+// FindByID finds the document with the given ID.
+//
+// Like all the ID-based operations, it accepts IDs of any type. An ObjectID can also be given in its hex string form:
+// such a string matches both the ObjectID and the string itself, so collections with string IDs keep working.
+func (r *BaseRepository[T]) FindByID(ctx context.Context, id interface{}, opts ...QueryOptsFunc) (T, error) {
+
+	return r.FindOne(ctx, idFilter(id), opts...)
+}
+
+// FindByIDs finds the documents with the given IDs. The documents are returned in the order of ids, followed by the
+// IDs no document was found for. Large lists of IDs are queried in chunks.
+func (r *BaseRepository[T]) FindByIDs(ctx context.Context, ids []interface{}) ([]T, []interface{}, error) {
+
+	var missing []interface{}
+
+	op := &Operation{Kind: "FindByIDs", Collection: r.collection.Name(), Filter: idsFilter(ids)}
+	documents, err := intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) ([]T, error) {
+		var documents []T
+		var err error
+		documents, missing, err = r.findByIDs(ctx, ids, op.Filter)
+		return documents, err
+	})
+
+	return documents, missing, err
+}
+
+// findByIDs finds the documents with the given IDs, restricted to the ones matching filter when an interceptor
+// changed it.
+func (r *BaseRepository[T]) findByIDs(
+	ctx context.Context,
+	ids []interface{},
+	filter interface{},
+) (_ []T, _ []interface{}, err error) {
+
+	defer r.wrapErr(&err, "FindByIDs", filter)
+
+	restricted := !reflect.DeepEqual(filter, idsFilter(ids))
+
+	found := make(map[string]bson.Raw, len(ids))
+	for start := 0; start < len(ids); start += findByIDsChunkSize {
+		end := min(start+findByIDsChunkSize, len(ids))
+
+		raws, err := r.findRaw(ctx, chunkFilter(ids[start:end], filter, restricted), options.Find())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, raw := range raws {
+			found[rawIDKey(raw.Lookup("_id"))] = raw
+		}
+	}
+
+	raws, missing := orderByIDs(r.registry, ids, found)
+	documents, err := r.decodeAll(raws)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return documents, missing, nil
+}
+
+// UpdateByID updates the document with the given ID. See UpdateOne and FindByID.
+func (r *BaseRepository[T]) UpdateByID(
+	ctx context.Context,
+	id interface{},
+	update interface{},
+	opts ...QueryOptsFunc,
+) (T, error) {
+
+	return r.UpdateOne(ctx, idFilter(id), update, opts...)
+}
+
+// ReplaceByID replaces the document with the given ID. See ReplaceOne and FindByID.
+func (r *BaseRepository[T]) ReplaceByID(
+	ctx context.Context,
+	id interface{},
+	replacement T,
+	opts ...QueryOptsFunc,
+) (T, error) {
+
+	return r.ReplaceOne(ctx, idFilter(id), replacement, opts...)
+}
+
+// DeleteByID deletes the document with the given ID. See FindByID.
+func (r *BaseRepository[T]) DeleteByID(ctx context.Context, id interface{}) (*DeleteResult, error) {
+
+	return r.DeleteOne(ctx, idFilter(id))
+}
+
+// chunkFilter returns the filter of the ids of chunk, restricted by filter when restricted is true.
+func chunkFilter(chunk []interface{}, filter interface{}, restricted bool) interface{} {
+
+	f := idsFilter(chunk)
+	if !restricted {
+		return f
+	}
+
+	return bson.D{{Key: "$and", Value: bson.A{f, filter}}}
+}
+
+// idValues returns the _id values matching id: an ObjectID hex string matches both the ObjectID and the string.
+func idValues(id interface{}) []interface{} {
+
+	if s, ok := id.(string); ok {
+		if oid, err := primitive.ObjectIDFromHex(s); err == nil {
+			return []interface{}{oid, s}
+		}
+	}
+
+	return []interface{}{id}
+}
+
+func idFilter(id interface{}) bson.D {
+
+	values := idValues(id)
+	if len(values) == 1 {
+		return bson.D{{Key: "_id", Value: values[0]}}
+	}
+
+	return bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A(values)}}}}
+}
+
+func idsFilter(ids []interface{}) bson.D {
+
+	in := make(bson.A, 0, len(ids))
+	for _, id := range ids {
+		in = append(in, idValues(id)...)
+	}
+
+	return bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: in}}}}
+}
+
+// rawIDKey identifies an _id value by its BSON type and encoding.
+func rawIDKey(v bson.RawValue) string {
+
+	return string(rune(v.Type)) + string(v.Value)
+}
+
+// idKeys returns the keys of the _id values matching id, encoded with registry, or nil if id cannot be marshalled.
+func idKeys(registry *bsoncodec.Registry, id interface{}) []string {
+
+	var keys []string
+	for _, v := range idValues(id) {
+		t, data, err := bson.MarshalValueWithRegistry(registry, v)
+		if err != nil {
+			return nil
+		}
+		keys = append(keys, rawIDKey(bson.RawValue{Type: t, Value: data}))
+	}
+
+	return keys
+}
+
+// orderByIDs returns the documents of found, keyed by rawIDKey, in the order of ids, followed by the IDs with no
+// document.
+func orderByIDs[D any](registry *bsoncodec.Registry, ids []interface{}, found map[string]D) ([]D, []interface{}) {
+
+	documents := make([]D, 0, len(ids))
+	var missing []interface{}
+
+	for _, id := range ids {
+		ok := false
+		for _, key := range idKeys(registry, id) {
+			var doc D
+			if doc, ok = found[key]; ok {
+				documents = append(documents, doc)
+				break
+			}
+		}
+
+		if !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	return documents, missing
+}
@@ -0,0 +1,112 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestFindByID(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "byID")
+	ctx := context.Background()
+
+	a := &artwork{Title: "Dancer", Artist: "Miro"}
+	require.NoError(t, r.InsertOne(ctx, a))
+
+	found, err := r.FindByID(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Dancer", found.Title)
+
+	found, err = r.FindByID(ctx, a.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, a.ID, found.ID)
+
+	updated, err := r.UpdateByID(ctx, a.ID.Hex(), bson.M{"$set": bson.M{"year": 1925}})
+	require.NoError(t, err)
+	assert.Equal(t, a.ID, updated.ID)
+
+	_, err = r.ReplaceByID(ctx, a.ID, &artwork{Title: "Dancer II", Artist: "Miro"})
+	require.NoError(t, err)
+
+	found, err = r.FindByID(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Dancer II", found.Title)
+	assert.Zero(t, found.Year)
+
+	res, err := r.DeleteByID(ctx, a.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), res.DeletedCount)
+
+	_, err = r.FindByID(ctx, a.ID)
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+}
+
+func TestFindByIDs(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "byIDs")
+	ctx := context.Background()
+
+	// More documents than fit in a single $in chunk.
+	artworks := make([]*artwork, 1500)
+	for i := range artworks {
+		artworks[i] = &artwork{Title: fmt.Sprintf("artwork %d", i)}
+	}
+	_, err := r.InsertMany(ctx, artworks)
+	require.NoError(t, err)
+
+	var ids []interface{}
+	for i := len(artworks) - 1; i >= 0; i-- {
+		ids = append(ids, artworks[i].ID)
+	}
+	missing := primitive.NewObjectID()
+	ids = append(ids[:10], append([]interface{}{missing, artworks[3].ID.Hex()}, ids[10:]...)...)
+
+	found, notFound, err := r.FindByIDs(ctx, ids)
+	require.NoError(t, err)
+	require.Len(t, found, 1501)
+	assert.Equal(t, "artwork 1499", found[0].Title)
+	assert.Equal(t, "artwork 3", found[10].Title)
+	assert.Equal(t, "artwork 0", found[1500].Title)
+	assert.Equal(t, []interface{}{missing}, notFound)
+}
+
+// ticket is a model whose _id is encoded by a custom codec.
+type ticket struct {
+	ID    uuid   `bson:"_id"`
+	Title string `bson:"title"`
+}
+
+func (*ticket) OnCreate()  {}
+func (*ticket) OnUpdate()  {}
+func (*ticket) OnReplace() {}
+
+func TestFindByIDs_CustomCodec(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(ticket), fm.WithCodecs(fm.UUIDCodec(reflect.TypeOf(uuid{}))))
+	ctx := context.Background()
+
+	a, b, missing := uuid{1}, uuid{2}, uuid{3}
+	_, err := r.InsertMany(ctx, []*ticket{{ID: a, Title: "a"}, {ID: b, Title: "b"}})
+	require.NoError(t, err)
+
+	found, notFound, err := r.FindByIDs(ctx, []interface{}{b, missing, a})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, "b", found[0].Title)
+	assert.Equal(t, "a", found[1].Title)
+	assert.Equal(t, []interface{}{missing}, notFound)
+}
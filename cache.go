@@ -0,0 +1,215 @@
+package friendlymongo
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"golang.org/x/sync/singleflight"
+)
+
+// Cache stores the documents read by a repository configured with WithCache, as BSON. Implementations must be safe
+// for concurrent use; they can be shared by several repositories.
+type Cache interface {
+	// Get returns the value stored under key, unless it is missing or expired.
+	Get(ctx context.Context, key string) ([]byte, bool)
+	// Set stores value under key for ttl, or until it is evicted when ttl is zero.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+}
+
+// LRUCache is an in-process Cache holding a bounded number of entries, evicting the least recently used one when
+// full.
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRUCache creates an LRUCache holding up to capacity entries.
+func NewLRUCache(capacity int) *LRUCache {
+
+	return &LRUCache{capacity: capacity, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// Get returns the value stored under key, unless it is missing or expired.
+func (c *LRUCache) Get(_ context.Context, key string) ([]byte, bool) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*lruEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Set stores value under key for ttl, or until it is evicted when ttl is zero.
+func (c *LRUCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	if el, ok := c.entries[key]; ok {
+		el.Value = &lruEntry{key: key, value: value, expires: expires}
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// Len returns the number of entries, expired ones included until they are looked up or evicted.
+func (c *LRUCache) Len() int {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+// cacheFlightTimeout bounds the query run for concurrent cache misses, which outlives the contexts of its callers.
+const cacheFlightTimeout = time.Minute
+
+// repositoryCaches numbers the caches of the repositories, so that their keys do not collide in a shared Cache.
+var repositoryCaches atomic.Uint64
+
+// repositoryCache is the cache of the FindOne results of a repository.
+//
+// The keys embed a generation, incremented after every write of the repository: the entries read before a write are
+// never returned after it, without having to know which documents it changed.
+type repositoryCache struct {
+	cache      Cache
+	ttl        time.Duration
+	id         uint64
+	generation atomic.Uint64
+	group      singleflight.Group
+}
+
+func newRepositoryCache(c Cache, ttl time.Duration) *repositoryCache {
+
+	if c == nil {
+		return nil
+	}
+
+	return &repositoryCache{cache: c, ttl: ttl, id: repositoryCaches.Add(1)}
+}
+
+// key returns the key of the document found by filter in the current generation.
+func (c *repositoryCache) key(collection string, registry *bsoncodec.Registry, filter interface{}) (string, error) {
+
+	raw, err := bson.MarshalWithRegistry(registry, filter)
+	if err != nil {
+		return "", err
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return "", err
+	}
+
+	// The order of the top-level fields does not matter, and is random for a bson.M.
+	sort.SliceStable(doc, func(i, j int) bool { return doc[i].Key < doc[j].Key })
+
+	b, err := bson.MarshalExtJSON(doc, true, false)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s:%d.%d:%s", collection, c.id, c.generation.Load(), b), nil
+}
+
+// cacheable reports whether the result of a FindOne with these options can be cached.
+func (o *queryOpts) cacheable() bool {
+
+	return o.sort == nil && o.projection == nil && o.skip == nil && o.collation == nil
+}
+
+// findOneCached finds a single document through the cache. Concurrent misses for the same key run a single query,
+// which each caller stops waiting for when its context is done.
+func (r *BaseRepository[T]) findOneCached(ctx context.Context, filter interface{}, o *queryOpts) (T, error) {
+
+	var zero T
+
+	key, err := r.cache.key(r.collection.Name(), r.registry, filter)
+	if err != nil {
+		return zero, err
+	}
+
+	if raw, ok := r.cache.cache.Get(ctx, key); ok {
+		return r.decode(rawDecoder{raw: raw, registry: r.registry})
+	}
+
+	flight := r.cache.group.DoChan(key, func() (interface{}, error) {
+		// The query serves every caller waiting for the key: it must not be canceled with the context of the first.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cacheFlightTimeout)
+		defer cancel()
+
+		// A flight for the same key may have completed since the lookup above.
+		if raw, ok := r.cache.cache.Get(ctx, key); ok {
+			return bson.Raw(raw), nil
+		}
+
+		raw, err := r.collection.FindOne(ctx, filter, o.findOneOptions()).Raw()
+		if err != nil {
+			return nil, err
+		}
+
+		r.cache.cache.Set(ctx, key, raw, r.cache.ttl)
+		return raw, nil
+	})
+
+	var res singleflight.Result
+	select {
+	case res = <-flight:
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+	if res.Err != nil {
+		return zero, res.Err
+	}
+
+	return r.decode(rawDecoder{raw: res.Val.(bson.Raw), registry: r.registry})
+}
+
+// invalidate discards the cached documents, once the transaction of ctx, if any, is committed.
+func (r *BaseRepository[T]) invalidate(ctx context.Context) {
+
+	if r.cache == nil {
+		return
+	}
+
+	afterCommit(ctx, func() { r.cache.generation.Add(1) })
+}
@@ -0,0 +1,164 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLRUCache(t *testing.T) {
+	t.Parallel()
+
+	c := fm.NewLRUCache(2)
+	ctx := context.Background()
+
+	c.Set(ctx, "a", []byte("1"), 0)
+	c.Set(ctx, "b", []byte("2"), 0)
+	_, ok := c.Get(ctx, "a")
+	require.True(t, ok)
+
+	c.Set(ctx, "c", []byte("3"), 0)
+	_, ok = c.Get(ctx, "b")
+	assert.False(t, ok, "the least recently used entry is evicted")
+	v, ok := c.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), v)
+	assert.Equal(t, 2, c.Len())
+
+	c.Set(ctx, "a", []byte("4"), 10*time.Millisecond)
+	v, ok = c.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("4"), v)
+
+	time.Sleep(20 * time.Millisecond)
+	_, ok = c.Get(ctx, "a")
+	assert.False(t, ok, "expired entries are not returned")
+	assert.Equal(t, 1, c.Len())
+}
+
+// countingCache counts the hits and the stores of a cache.
+type countingCache struct {
+	fm.Cache
+	hits, sets atomic.Int64
+}
+
+func (c *countingCache) Get(ctx context.Context, key string) ([]byte, bool) {
+	v, ok := c.Cache.Get(ctx, key)
+	if ok {
+		c.hits.Add(1)
+	}
+	return v, ok
+}
+
+func (c *countingCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	c.sets.Add(1)
+	c.Cache.Set(ctx, key, value, ttl)
+}
+
+func newCachedArtworkRepo(t *testing.T, collection string) (*fm.BaseRepository[*artwork], *countingCache) {
+	c := &countingCache{Cache: fm.NewLRUCache(100)}
+	r := fm.NewBaseRepository(fm.GetInstance().Database(testDB), collection, new(artwork), fm.WithCache(c, time.Minute))
+
+	_, err := r.Delete(context.Background(), bson.M{})
+	require.NoError(t, err)
+
+	return r, c
+}
+
+func TestCache_ReadThrough(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r, c := newCachedArtworkRepo(t, "cache_read_through")
+	ctx := context.Background()
+
+	scream := &artwork{Title: "The Scream", Artist: "Munch"}
+	require.NoError(t, r.InsertOne(ctx, scream))
+
+	for range 3 {
+		found, err := r.FindByID(ctx, scream.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "The Scream", found.Title)
+	}
+	assert.Equal(t, int64(1), c.sets.Load())
+	assert.Equal(t, int64(2), c.hits.Load())
+
+	found, err := r.FindOne(ctx, bson.M{"artist": "Munch", "title": "The Scream"})
+	require.NoError(t, err)
+	found.Title = "modified"
+	found, err = r.FindOne(ctx, bson.M{"title": "The Scream", "artist": "Munch"})
+	require.NoError(t, err)
+	assert.Equal(t, "The Scream", found.Title, "cached documents are decoded anew")
+	assert.Equal(t, int64(2), c.sets.Load())
+
+	_, err = r.FindOne(ctx, bson.M{"artist": "Munch"}, fm.WithProjection(bson.M{"title": 1}))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), c.sets.Load(), "projected lookups are not cached")
+
+	_, err = r.FindOne(ctx, bson.M{"artist": "Klimt"})
+	assert.ErrorIs(t, err, fm.ErrNotFound)
+	assert.Equal(t, int64(2), c.sets.Load(), "misses are not cached")
+}
+
+func TestCache_Invalidation(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r, _ := newCachedArtworkRepo(t, "cache_invalidation")
+	ctx := context.Background()
+
+	scream := &artwork{Title: "The Scream", Artist: "Munch"}
+	require.NoError(t, r.InsertOne(ctx, scream))
+
+	_, err := r.FindByID(ctx, scream.ID)
+	require.NoError(t, err)
+
+	_, err = r.UpdateOne(ctx, bson.M{"_id": scream.ID}, bson.M{"$set": bson.M{"year": 1893}})
+	require.NoError(t, err)
+	found, err := r.FindByID(ctx, scream.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1893, found.Year)
+
+	_, err = r.ReplaceOne(ctx, bson.M{"_id": scream.ID}, &artwork{Title: "Skrik", Artist: "Munch"})
+	require.NoError(t, err)
+	found, err = r.FindByID(ctx, scream.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Skrik", found.Title)
+
+	_, err = r.Delete(ctx, bson.M{})
+	require.NoError(t, err)
+	_, err = r.FindByID(ctx, scream.ID)
+	assert.ErrorIs(t, err, fm.ErrNotFound)
+}
+
+func TestCache_Singleflight(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r, c := newCachedArtworkRepo(t, "cache_singleflight")
+	ctx := context.Background()
+
+	scream := &artwork{Title: "The Scream", Artist: "Munch"}
+	require.NoError(t, r.InsertOne(ctx, scream))
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			found, err := r.FindByID(ctx, scream.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, "The Scream", found.Title)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), c.sets.Load())
+}
@@ -0,0 +1,331 @@
+package friendlymongo
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ChangeEvent is a change of a document of the collection, received through Watch.
+type ChangeEvent[T Model] struct {
+	// OperationType is the type of the change, e.g. "insert", "update", "replace" or "delete".
+	OperationType string
+	// DocumentKey holds the _id, and the shard key if any, of the changed document.
+	DocumentKey bson.D
+	// FullDocument is the document after the change. It is set for inserts and replaces, and for updates when
+	// WithFullDocument is used. It is the zero value of T otherwise.
+	FullDocument T
+	// FullDocumentBeforeChange is the document before the change, when WithPreImage is used and the pre-images are
+	// enabled on the collection.
+	FullDocumentBeforeChange T
+	// UpdateDescription describes the fields changed by an update, nil for other operations.
+	UpdateDescription *UpdateDescription
+	// ClusterTime is the time of the change.
+	ClusterTime primitive.Timestamp
+	// ResumeToken identifies the event, so that a change stream can resume after it.
+	ResumeToken bson.Raw
+}
+
+// EventDecodeError is the failure to decode a change event, e.g. because its document does not match T.
+//
+// The change stream goes on with the next event when the loop continues after the error: the event is skipped, and
+// counted as handled by WithCheckpoint. After breaking out of the loop instead, ResumeToken can be used to resume after
+// the event with WithResumeAfter.
+type EventDecodeError struct {
+	// ResumeToken identifies the event.
+	ResumeToken bson.Raw
+	// Err is the decoding error.
+	Err error
+}
+
+func (e *EventDecodeError) Error() string {
+
+	return "cannot decode change event: " + e.Err.Error()
+}
+
+func (e *EventDecodeError) Unwrap() error {
+
+	return e.Err
+}
+
+// UpdateDescription describes the fields changed by an update.
+type UpdateDescription struct {
+	UpdatedFields bson.D   `bson:"updatedFields"`
+	RemovedFields []string `bson:"removedFields"`
+}
+
+// changeEvent is the document of a change event, whose full documents are decoded separately.
+type changeEvent struct {
+	OperationType            string              `bson:"operationType"`
+	DocumentKey              bson.D              `bson:"documentKey"`
+	FullDocument             bson.Raw            `bson:"fullDocument"`
+	FullDocumentBeforeChange bson.Raw            `bson:"fullDocumentBeforeChange"`
+	UpdateDescription        *UpdateDescription  `bson:"updateDescription"`
+	ClusterTime              primitive.Timestamp `bson:"clusterTime"`
+}
+
+// WithMaxAwaitTime sets the maximum amount of time the server waits for new events before answering each request of a
+// change stream with an empty batch. WithMaxTime is not supported by Watch.
+func WithMaxAwaitTime(d time.Duration) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.maxAwaitTime = &d
+		opts.mark(optMaxAwaitTime)
+	}
+}
+
+// WithFullDocument sets whether the change events of updates carry the full document, e.g. options.UpdateLookup.
+func WithFullDocument(fd options.FullDocument) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.fullDocument = &fd
+		opts.mark(optFullDocument)
+	}
+}
+
+// WithPreImage sets whether the change events carry the document before the change, e.g. options.WhenAvailable.
+// Pre-images must be enabled on the collection.
+func WithPreImage(fd options.FullDocument) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.preImage = &fd
+		opts.mark(optPreImage)
+	}
+}
+
+// WithResumeAfter resumes a change stream after the event with the given resume token.
+func WithResumeAfter(token bson.Raw) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.resumeAfter = token
+		opts.mark(optResumeAfter)
+	}
+}
+
+// WithStartAtOperationTime starts a change stream at the given cluster time, unless a resume token is available.
+func WithStartAtOperationTime(t primitive.Timestamp) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.startAt = &t
+		opts.mark(optStartAt)
+	}
+}
+
+// WithCheckpoint saves the resume token of each event handled by the consumer of a change stream in store, under
+// name, and resumes after the saved token when the change stream is opened again, e.g. after a restart.
+func WithCheckpoint(store CheckpointStore, name string) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.checkpoints = store
+		opts.checkpointName = name
+		opts.mark(optCheckpoint)
+	}
+}
+
+// Watch opens a change stream on the collection and returns an iterator over its events, with their documents
+// decoded as T. The iteration only ends when ctx is done, an error other than an *EventDecodeError occurs or the loop
+// is broken out of.
+//
+// The pipeline filters the events; it can be a *StageBuilder, a mongo.Pipeline or nil, e.g.
+// NewStageBuilder().Match("ops", bson.M{"operationType": "insert"}).
+//
+// With WithCheckpoint, the resume token of an event is saved once the loop body handled it and moved to the next
+// one, so that events are delivered at least once across restarts. WithResumeAfter takes precedence over the saved
+// token.
+func (r *BaseRepository[T]) Watch(
+	ctx context.Context,
+	pipeline interface{},
+	opts ...QueryOptsFunc,
+) iter.Seq2[*ChangeEvent[T], error] {
+
+	op := &Operation{Kind: "Watch", Collection: r.collection.Name(), Pipeline: pipeline}
+	return interceptSeq(ctx, r.interceptors, op,
+		func(ctx context.Context, op *Operation) (iter.Seq2[*ChangeEvent[T], error], error) {
+			return r.watch(ctx, op.Pipeline, opts...), nil
+		})
+}
+
+func (r *BaseRepository[T]) watch(
+	ctx context.Context,
+	pipeline interface{},
+	opts ...QueryOptsFunc,
+) iter.Seq2[*ChangeEvent[T], error] {
+
+	return func(yield func(*ChangeEvent[T], error) bool) {
+		yield = r.wrapEventYield(yield)
+
+		o, err := newQueryOpts("Watch", opts, watchSupported...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		p, err := pipelineOf(pipeline)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		token := o.resumeAfter
+		if token == nil && o.checkpoints != nil {
+			if token, err = o.checkpoints.Load(ctx, o.checkpointName); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+
+		stream, err := r.collection.Watch(ctx, p, o.changeStreamOptions(token))
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer stream.Close(context.WithoutCancel(ctx))
+
+		for stream.Next(ctx) {
+			token := append(bson.Raw(nil), stream.ResumeToken()...)
+
+			event, err := r.decodeEvent(stream.Current, token)
+			if !yield(event, err) {
+				return
+			}
+
+			if o.checkpoints != nil {
+				if err := o.checkpoints.Save(ctx, o.checkpointName, token); err != nil {
+					yield(nil, err)
+					return
+				}
+			}
+		}
+
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			yield(nil, err)
+		}
+	}
+}
+
+func (r *BaseRepository[T]) wrapEventYield(yield func(*ChangeEvent[T], error) bool) func(*ChangeEvent[T], error) bool {
+
+	return func(event *ChangeEvent[T], err error) bool {
+		return yield(event, wrapError("Watch", r.collection.Name(), r.registry, nil, err))
+	}
+}
+
+// decodeEvent decodes the change event current, whose resume token is token. Errors are *EventDecodeError.
+func (r *BaseRepository[T]) decodeEvent(current bson.Raw, token bson.Raw) (*ChangeEvent[T], error) {
+
+	var raw changeEvent
+	if err := bson.UnmarshalWithRegistry(r.registry, current, &raw); err != nil {
+		return nil, &EventDecodeError{ResumeToken: token, Err: err}
+	}
+
+	event := &ChangeEvent[T]{
+		OperationType:     raw.OperationType,
+		DocumentKey:       raw.DocumentKey,
+		UpdateDescription: raw.UpdateDescription,
+		ClusterTime:       raw.ClusterTime,
+		ResumeToken:       token,
+	}
+
+	var err error
+	if len(raw.FullDocument) > 0 {
+		if event.FullDocument, err = r.decode(rawDecoder{raw: raw.FullDocument, registry: r.registry}); err != nil {
+			return nil, &EventDecodeError{ResumeToken: token, Err: err}
+		}
+	}
+	if len(raw.FullDocumentBeforeChange) > 0 {
+		d := rawDecoder{raw: raw.FullDocumentBeforeChange, registry: r.registry}
+		if event.FullDocumentBeforeChange, err = r.decode(d); err != nil {
+			return nil, &EventDecodeError{ResumeToken: token, Err: err}
+		}
+	}
+
+	return event, nil
+}
+
+// CheckpointStore persists the resume tokens of change streams, see WithCheckpoint.
+type CheckpointStore interface {
+	// Load returns the token saved under name, or nil if there is none.
+	Load(ctx context.Context, name string) (bson.Raw, error)
+	// Save saves token under name, replacing the previous one.
+	Save(ctx context.Context, name string, token bson.Raw) error
+}
+
+// MemoryCheckpointStore is a CheckpointStore keeping the tokens in memory, for tests and consumers that do not need
+// to resume after a restart.
+type MemoryCheckpointStore struct {
+	mu     sync.Mutex
+	tokens map[string]bson.Raw
+}
+
+// NewMemoryCheckpointStore creates an empty MemoryCheckpointStore.
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+
+	return &MemoryCheckpointStore{tokens: map[string]bson.Raw{}}
+}
+
+// Load returns the token saved under name, or nil if there is none.
+func (s *MemoryCheckpointStore) Load(_ context.Context, name string) (bson.Raw, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tokens[name], nil
+}
+
+// Save saves token under name.
+func (s *MemoryCheckpointStore) Save(_ context.Context, name string, token bson.Raw) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[name] = append(bson.Raw(nil), token...)
+	return nil
+}
+
+// MongoCheckpointStore is a CheckpointStore keeping the tokens in a MongoDB collection, one document per name.
+type MongoCheckpointStore struct {
+	collection *mongo.Collection
+}
+
+// NewMongoCheckpointStore creates a MongoCheckpointStore saving the tokens in the given collection of db.
+func NewMongoCheckpointStore(db *mongo.Database, collectionName string) *MongoCheckpointStore {
+
+	return &MongoCheckpointStore{collection: db.Collection(collectionName)}
+}
+
+type checkpoint struct {
+	Name      string    `bson:"_id"`
+	Token     bson.Raw  `bson:"token"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+// Load returns the token saved under name, or nil if there is none.
+func (s *MongoCheckpointStore) Load(ctx context.Context, name string) (bson.Raw, error) {
+
+	var c checkpoint
+	err := s.collection.FindOne(ctx, bson.D{{Key: "_id", Value: name}}).Decode(&c)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+
+	return c.Token, err
+}
+
+// Save saves token under name.
+func (s *MongoCheckpointStore) Save(ctx context.Context, name string, token bson.Raw) error {
+
+	_, err := s.collection.ReplaceOne(ctx,
+		bson.D{{Key: "_id", Value: name}},
+		checkpoint{Name: name, Token: token, UpdatedAt: time.Now()},
+		options.Replace().SetUpsert(true),
+	)
+
+	return err
+}
@@ -0,0 +1,183 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// operationTime returns the current cluster time, to start change streams from.
+func operationTime(t *testing.T) primitive.Timestamp {
+	session, err := fm.GetInstance().Client().StartSession()
+	require.NoError(t, err)
+	defer session.EndSession(context.Background())
+
+	err = mongo.WithSession(context.Background(), session, func(sc mongo.SessionContext) error {
+		return fm.GetInstance().Database(testDB).RunCommand(sc, bson.D{{Key: "ping", Value: 1}}).Err()
+	})
+	require.NoError(t, err)
+
+	return *session.OperationTime()
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+	requireReplicaSet(t)
+
+	r := newEmptyArtworkRepo(t, "watch")
+	start := operationTime(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a := &artwork{Title: "Dancer", Artist: "Miro"}
+	require.NoError(t, r.InsertOne(ctx, a))
+	_, err := r.UpdateByID(ctx, a.ID, bson.M{"$set": bson.M{"year": 1925}})
+	require.NoError(t, err)
+	_, err = r.DeleteByID(ctx, a.ID)
+	require.NoError(t, err)
+
+	store := fm.NewMemoryCheckpointStore()
+	opts := []fm.QueryOptsFunc{
+		fm.WithStartAtOperationTime(start),
+		fm.WithFullDocument(options.UpdateLookup),
+		fm.WithCheckpoint(store, "watch"),
+	}
+	pipeline := fm.NewStageBuilder().
+		Match("ops", bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "delete"}}})
+
+	var events []*fm.ChangeEvent[*artwork]
+	for event, err := range r.Watch(ctx, pipeline, opts...) {
+		require.NoError(t, err)
+		events = append(events, event)
+		if len(events) == 2 {
+			break
+		}
+	}
+
+	require.Len(t, events, 2)
+	assert.Equal(t, "insert", events[0].OperationType)
+	assert.Equal(t, "Dancer", events[0].FullDocument.Title)
+	assert.Equal(t, bson.D{{Key: "_id", Value: a.ID}}, events[0].DocumentKey)
+	assert.Equal(t, "update", events[1].OperationType)
+	require.NotNil(t, events[1].UpdateDescription)
+	assert.Contains(t, events[1].UpdateDescription.UpdatedFields, bson.E{Key: "year", Value: int32(1925)})
+
+	// Only the first event was handled before breaking out of the loop, so the second one is delivered again.
+	var resumed []string
+	for event, err := range r.Watch(ctx, pipeline, opts...) {
+		require.NoError(t, err)
+		resumed = append(resumed, event.OperationType)
+		if len(resumed) == 2 {
+			break
+		}
+	}
+	assert.Equal(t, []string{"update", "delete"}, resumed)
+}
+
+func TestWatch_UnsupportedOption(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "watchOptions")
+
+	n := 0
+	for _, err := range r.Watch(context.Background(), nil, fm.WithLimit(1)) {
+		assert.ErrorContains(t, err, "option limit is not supported by Watch")
+		n++
+	}
+	assert.Equal(t, 1, n)
+
+	for _, err := range r.Watch(context.Background(), nil, fm.WithMaxTime(time.Second)) {
+		assert.ErrorContains(t, err, "option maxTime is not supported by Watch")
+	}
+}
+
+func TestWatch_DecodeError(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+	requireReplicaSet(t)
+
+	r := newEmptyArtworkRepo(t, "watchDecode")
+	start := operationTime(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	raw := fm.GetInstance().Database(testDB).Collection("watchDecode")
+	_, err := raw.InsertOne(ctx, bson.M{"title": bson.A{"not", "a", "string"}})
+	require.NoError(t, err)
+	require.NoError(t, r.InsertOne(ctx, &artwork{Title: "Dancer"}))
+
+	store := fm.NewMemoryCheckpointStore()
+	opts := []fm.QueryOptsFunc{
+		fm.WithStartAtOperationTime(start),
+		fm.WithCheckpoint(store, "watchDecode"),
+		fm.WithMaxAwaitTime(time.Second),
+	}
+
+	var decodeErr *fm.EventDecodeError
+	var titles []string
+	for event, err := range r.Watch(ctx, nil, opts...) {
+		if err != nil {
+			// The event is skipped by going on with the loop.
+			require.ErrorAs(t, err, &decodeErr)
+			continue
+		}
+		titles = append(titles, event.FullDocument.Title)
+		break
+	}
+
+	require.NotNil(t, decodeErr)
+	assert.NotEmpty(t, decodeErr.ResumeToken)
+	assert.Equal(t, []string{"Dancer"}, titles)
+
+	saved, err := store.Load(ctx, "watchDecode")
+	require.NoError(t, err)
+	assert.Equal(t, decodeErr.ResumeToken, saved, "the skipped event is handled")
+}
+
+func TestMemoryCheckpointStore(t *testing.T) {
+	t.Parallel()
+
+	testCheckpointStore(t, fm.NewMemoryCheckpointStore())
+}
+
+func TestMongoCheckpointStore(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	_, err := fm.GetInstance().Database(testDB).Collection("checkpoints").DeleteMany(context.Background(), bson.M{})
+	require.NoError(t, err)
+
+	testCheckpointStore(t, fm.NewMongoCheckpointStore(fm.GetInstance().Database(testDB), "checkpoints"))
+}
+
+func testCheckpointStore(t *testing.T, store fm.CheckpointStore) {
+	ctx := context.Background()
+
+	token, err := store.Load(ctx, "consumer")
+	require.NoError(t, err)
+	assert.Nil(t, token)
+
+	first, err := bson.Marshal(bson.D{{Key: "_data", Value: "first"}})
+	require.NoError(t, err)
+	require.NoError(t, store.Save(ctx, "consumer", first))
+
+	second, err := bson.Marshal(bson.D{{Key: "_data", Value: "second"}})
+	require.NoError(t, err)
+	require.NoError(t, store.Save(ctx, "consumer", second))
+
+	token, err = store.Load(ctx, "consumer")
+	require.NoError(t, err)
+	assert.Equal(t, bson.Raw(second), token)
+}
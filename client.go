@@ -6,6 +6,8 @@ import (
 	"sync"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -13,6 +15,9 @@ import (
 // MongoClient is a struct to manage the database connection
 type MongoClient struct {
 	client *mongo.Client
+
+	// registry is the codec registry of the client, nil when the driver default is used.
+	registry *bsoncodec.Registry
 }
 
 var (
@@ -26,19 +31,35 @@ func GetInstance() *MongoClient {
 }
 
 // SetInstance initializes a new database connection
-func SetInstance(uri string) *MongoClient {
+func SetInstance(uri string, opts ...ClientOptsFunc) *MongoClient {
 	once.Do(func() {
 
+		cOpts := clientOpts{}
+		for _, opt := range opts {
+			opt(&cOpts)
+		}
+
 		clientOptions := options.
 			Client().
 			ApplyURI(uri)
 
+		reg := cOpts.registry
+		if len(cOpts.codecs) > 0 {
+			reg = deriveRegistry(reg)
+		}
+		for _, codec := range cOpts.codecs {
+			codec.Register(reg)
+		}
+		if reg != nil {
+			clientOptions.SetRegistry(reg)
+		}
+
 		c, err := mongo.Connect(context.Background(), clientOptions)
 		if err != nil {
 			panic(err)
 		}
 
-		instance = &MongoClient{client: c}
+		instance = &MongoClient{client: c, registry: reg}
 	})
 
 	return instance
@@ -69,6 +90,15 @@ func (c *MongoClient) Client() *mongo.Client {
 	return c.client
 }
 
+// Registry returns the codec registry of the client.
+func (c *MongoClient) Registry() *bsoncodec.Registry {
+	if c.registry == nil {
+		return bson.DefaultRegistry
+	}
+
+	return c.registry
+}
+
 func (c *MongoClient) Disconnect() error {
 	if instance == nil || instance.Client() == nil {
 		return nil
@@ -0,0 +1,29 @@
+package friendlymongo
+
+import "go.mongodb.org/mongo-driver/bson/bsoncodec"
+
+type clientOpts struct {
+	registry *bsoncodec.Registry
+	codecs   []Codec
+}
+
+// ClientOptsFunc configures the MongoClient created with SetInstance.
+type ClientOptsFunc func(*clientOpts)
+
+// WithClientRegistry sets the codec registry used by every database and collection of the client.
+func WithClientRegistry(reg *bsoncodec.Registry) ClientOptsFunc {
+
+	return func(opts *clientOpts) {
+		opts.registry = reg
+	}
+}
+
+// WithClientCodecs registers the given codecs on the registry of the client, so that every repository encodes and
+// decodes their types consistently. With WithClientRegistry, they are registered on a registry derived from it, which
+// is not modified.
+func WithClientCodecs(codecs ...Codec) ClientOptsFunc {
+
+	return func(opts *clientOpts) {
+		opts.codecs = append(opts.codecs, codecs...)
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pmatteo/friendlymongo"
+)
+
+const (
+	friendlymongoPath = "github.com/pmatteo/friendlymongo"
+	mongoPath         = "go.mongodb.org/mongo-driver/mongo"
+	bsonPath          = "go.mongodb.org/mongo-driver/bson"
+)
+
+// knownStructs maps the qualified name of structs declared outside the generated package to their reflected type,
+// so that their fields can be expanded as well.
+var knownStructs = map[string]reflect.Type{
+	friendlymongoPath + ".BaseModel": reflect.TypeOf(friendlymongo.BaseModel{}),
+}
+
+type localStruct struct {
+	spec *ast.StructType
+	file *ast.File
+}
+
+type generator struct {
+	pkgName string
+	structs map[string]*localStruct
+}
+
+// fieldPath is a bson path reachable from the root of a model.
+type fieldPath struct {
+	name string
+	path string
+}
+
+// finderField is a top-level field eligible for a typed finder.
+type finderField struct {
+	name string
+	path string
+	typ  string
+}
+
+type model struct {
+	name    string
+	paths   []fieldPath
+	finders []finderField
+	imports map[string]string
+}
+
+func newGenerator(dir string, skipFile string) (*generator, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading package: %w", err)
+	}
+
+	fset := token.NewFileSet()
+	g := &generator{structs: make(map[string]*localStruct)}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || name == skipFile {
+			continue
+		}
+
+		file, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("parsing package: %w", err)
+		}
+
+		if g.pkgName != "" && g.pkgName != file.Name.Name {
+			return nil, fmt.Errorf("multiple packages in %s: %s and %s", dir, g.pkgName, file.Name.Name)
+		}
+		g.pkgName = file.Name.Name
+
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				ts := spec.(*ast.TypeSpec)
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					g.structs[ts.Name.Name] = &localStruct{spec: st, file: file}
+				}
+			}
+		}
+	}
+
+	if g.pkgName == "" {
+		return nil, fmt.Errorf("no Go files in %s", dir)
+	}
+
+	return g, nil
+}
+
+// generate returns the formatted source declaring the field paths, and optionally the repository wrapper, of the
+// given types.
+func (g *generator) generate(typeNames []string, withFinders bool) ([]byte, error) {
+	models := make([]*model, 0, len(typeNames))
+	imports := map[string]string{}
+
+	for _, name := range typeNames {
+		if _, ok := g.structs[name]; !ok {
+			return nil, fmt.Errorf("struct type %s not found in package %s", name, g.pkgName)
+		}
+
+		m := &model{name: name, imports: map[string]string{}}
+		g.walkLocal(m, name, "", "", true, map[string]bool{})
+		models = append(models, m)
+
+		if withFinders {
+			for alias, path := range m.imports {
+				imports[alias] = path
+			}
+		}
+	}
+
+	if withFinders {
+		imports["context"] = "context"
+		imports["friendlymongo"] = friendlymongoPath
+		imports["mongo"] = mongoPath
+		imports["bson"] = bsonPath
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by \"fmgen %s\"; DO NOT EDIT.\n\n", strings.Join(os.Args[1:], " "))
+	fmt.Fprintf(&buf, "package %s\n\n", g.pkgName)
+
+	if len(imports) > 0 {
+		aliases := make([]string, 0, len(imports))
+		for alias := range imports {
+			aliases = append(aliases, alias)
+		}
+		sort.Slice(aliases, func(i, j int) bool {
+			pi, pj := imports[aliases[i]], imports[aliases[j]]
+			if isStd(pi) != isStd(pj) {
+				return isStd(pi)
+			}
+			return pi < pj
+		})
+
+		buf.WriteString("import (\n")
+		for i, alias := range aliases {
+			path := imports[alias]
+			if i > 0 && isStd(imports[aliases[i-1]]) && !isStd(path) {
+				buf.WriteString("\n")
+			}
+			if alias == path[strings.LastIndex(path, "/")+1:] {
+				fmt.Fprintf(&buf, "\t%q\n", path)
+			} else {
+				fmt.Fprintf(&buf, "\t%s %q\n", alias, path)
+			}
+		}
+		buf.WriteString(")\n\n")
+	}
+
+	for _, m := range models {
+		m.writeConstants(&buf)
+		if withFinders {
+			m.writeRepository(&buf)
+		}
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated code: %w", err)
+	}
+
+	return src, nil
+}
+
+// walkLocal collects the paths of the struct declared in the generated package. Fields are expanded only once per
+// branch to cope with recursive types.
+func (g *generator) walkLocal(m *model, structName, prefixName, prefixPath string, top bool, seen map[string]bool) {
+	if seen[structName] {
+		return
+	}
+	seen[structName] = true
+	defer delete(seen, structName)
+
+	ls := g.structs[structName]
+
+	for _, f := range ls.spec.Fields.List {
+		goName := embeddedName(f.Type)
+		if len(f.Names) > 0 {
+			goName = f.Names[0].Name
+		}
+
+		var tag string
+		if f.Tag != nil {
+			tag, _ = strconv.Unquote(f.Tag.Value)
+		}
+
+		names := f.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{ast.NewIdent(goName)}
+		}
+
+		for _, ident := range names {
+			if len(f.Names) > 0 && !ident.IsExported() {
+				continue
+			}
+
+			key, inline, skip := parseTag(ident.Name, tag)
+			if skip {
+				continue
+			}
+
+			name, path := prefixName, prefixPath
+			if !inline {
+				name = prefixName + ident.Name
+				path = joinPath(prefixPath, key)
+			}
+
+			g.walkField(m, ls.file, f.Type, name, path, top, inline, seen)
+		}
+	}
+}
+
+func (g *generator) walkField(m *model, file *ast.File, expr ast.Expr, name, path string, top, inline bool,
+	seen map[string]bool) {
+
+	elem, isSlice := unwrap(expr)
+
+	if !inline {
+		m.paths = append(m.paths, fieldPath{name: name, path: path})
+	}
+
+	switch t := elem.(type) {
+	case *ast.Ident:
+		if _, ok := g.structs[t.Name]; ok {
+			g.walkLocal(m, t.Name, name, path, top && inline, seen)
+			return
+		}
+
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok {
+			if rt, ok := knownStructs[importPath(file, pkg.Name)+"."+t.Sel.Name]; ok {
+				walkReflect(m, rt, name, path, top && inline)
+				return
+			}
+		}
+
+	default:
+		return
+	}
+
+	if top && !inline && !isSlice && path != "_id" {
+		m.finders = append(m.finders, finderField{name: name, path: path, typ: types.ExprString(elem)})
+		collectImports(m, file, elem)
+	}
+}
+
+// walkReflect collects the paths of a struct declared outside the generated package.
+func walkReflect(m *model, rt reflect.Type, prefixName, prefixPath string, top bool) {
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if !sf.IsExported() && !sf.Anonymous {
+			continue
+		}
+
+		key, inline, skip := parseTag(sf.Name, string(sf.Tag))
+		if skip {
+			continue
+		}
+
+		name, path := prefixName, prefixPath
+		if !inline {
+			name = prefixName + sf.Name
+			path = joinPath(prefixPath, key)
+			m.paths = append(m.paths, fieldPath{name: name, path: path})
+		}
+
+		ft := sf.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+
+		if ft.Kind() == reflect.Struct && hasExportedFields(ft) && ft != reflect.TypeOf(time.Time{}) {
+			walkReflect(m, ft, name, path, top && inline)
+			continue
+		}
+
+		if top && !inline && path != "_id" && ft.Kind() != reflect.Slice && ft.Kind() != reflect.Map {
+			m.finders = append(m.finders, finderField{name: name, path: path, typ: ft.String()})
+			if ft.PkgPath() != "" {
+				m.imports[ft.String()[:strings.Index(ft.String(), ".")]] = ft.PkgPath()
+			}
+		}
+	}
+}
+
+func (m *model) writeConstants(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "// Bson field paths of %s.\n", m.name)
+	buf.WriteString("const (\n")
+	for _, p := range m.paths {
+		fmt.Fprintf(buf, "\t%sField%s = %q\n", m.name, p.name, p.path)
+	}
+	buf.WriteString(")\n\n")
+
+	fmt.Fprintf(buf, "// Aggregation expressions referencing the fields of %s.\n", m.name)
+	buf.WriteString("const (\n")
+	for _, p := range m.paths {
+		fmt.Fprintf(buf, "\t%sExpr%s = %q\n", m.name, p.name, "$"+p.path)
+	}
+	buf.WriteString(")\n\n")
+}
+
+func (m *model) writeRepository(buf *bytes.Buffer) {
+	repo := m.name + "Repository"
+
+	fmt.Fprintf(buf, "// %s is a friendlymongo.BaseRepository for %s extended with typed finders.\n", repo, m.name)
+	fmt.Fprintf(buf, "type %s struct {\n\t*friendlymongo.BaseRepository[*%s]\n}\n\n", repo, m.name)
+
+	fmt.Fprintf(buf, "// New%s creates a new instance of %s.\n", repo, repo)
+	fmt.Fprintf(buf, "func New%s(db *mongo.Database, collectionName string, opts ...friendlymongo.RepositoryOptsFunc) *%s {\n",
+		repo, repo)
+	fmt.Fprintf(buf, "\treturn &%s{\n", repo)
+	fmt.Fprintf(buf, "\t\tBaseRepository: friendlymongo.NewBaseRepository(db, collectionName, new(%s), opts...),\n", m.name)
+	buf.WriteString("\t}\n}\n\n")
+
+	for _, f := range m.finders {
+		fmt.Fprintf(buf, "// FindBy%s finds the documents whose %s field equals value.\n", f.name, f.path)
+		fmt.Fprintf(buf, "func (r *%s) FindBy%s(ctx context.Context, value %s) ([]*%s, error) {\n",
+			repo, f.name, f.typ, m.name)
+		fmt.Fprintf(buf, "\treturn r.Find(ctx, bson.M{%sField%s: value})\n}\n\n", m.name, f.name)
+
+		fmt.Fprintf(buf, "// FindBy%sIn finds the documents whose %s field equals any of values.\n", f.name, f.path)
+		fmt.Fprintf(buf, "func (r *%s) FindBy%sIn(ctx context.Context, values ...%s) ([]*%s, error) {\n",
+			repo, f.name, f.typ, m.name)
+		fmt.Fprintf(buf, "\treturn r.Find(ctx, bson.M{%sField%s: bson.M{\"$in\": values}})\n}\n\n", m.name, f.name)
+	}
+}
+
+// parseTag returns the bson key of a field following the rules of the driver's default struct tag parser.
+func parseTag(fieldName, tag string) (key string, inline bool, skip bool) {
+	bsonTag, ok := reflect.StructTag(tag).Lookup("bson")
+	if ok && bsonTag == "-" {
+		return "", false, true
+	}
+
+	parts := strings.Split(bsonTag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			inline = true
+		}
+	}
+
+	key = parts[0]
+	if key == "" {
+		key = strings.ToLower(fieldName)
+	}
+
+	return key, inline, false
+}
+
+// unwrap strips pointers and reports whether the expression is a slice or array.
+func unwrap(expr ast.Expr) (ast.Expr, bool) {
+	isSlice := false
+	for {
+		switch t := expr.(type) {
+		case *ast.StarExpr:
+			expr = t.X
+		case *ast.ArrayType:
+			expr = t.Elt
+			isSlice = true
+		case *ast.ParenExpr:
+			expr = t.X
+		default:
+			return expr, isSlice
+		}
+	}
+}
+
+func embeddedName(expr ast.Expr) string {
+	elem, _ := unwrap(expr)
+	switch t := elem.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	}
+
+	return ""
+}
+
+func importPath(file *ast.File, pkgName string) string {
+	for _, imp := range file.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		if imp.Name != nil {
+			if imp.Name.Name == pkgName {
+				return path
+			}
+			continue
+		}
+		if path[strings.LastIndex(path, "/")+1:] == pkgName {
+			return path
+		}
+	}
+
+	return pkgName
+}
+
+func collectImports(m *model, file *ast.File, expr ast.Expr) {
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if pkg, ok := sel.X.(*ast.Ident); ok {
+				m.imports[pkg.Name] = importPath(file, pkg.Name)
+			}
+			return false
+		}
+		return true
+	})
+}
+
+func hasExportedFields(rt reflect.Type) bool {
+	for i := 0; i < rt.NumField(); i++ {
+		if rt.Field(i).IsExported() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isStd(path string) bool {
+	return !strings.Contains(strings.Split(path, "/")[0], ".")
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "." + key
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const modelsSource = `package models
+
+import (
+	"time"
+
+	fm "github.com/pmatteo/friendlymongo"
+)
+
+type Status string
+
+type LineItem struct {
+	SKU      string ` + "`bson:\"sku\"`" + `
+	Quantity int    ` + "`bson:\"qty\"`" + `
+}
+
+type Address struct {
+	City string ` + "`bson:\"city\"`" + `
+}
+
+type Order struct {
+	fm.BaseModel ` + "`bson:\",inline\"`" + `
+
+	Email    string     ` + "`bson:\"email\"`" + `
+	Status   Status     ` + "`bson:\"status\"`" + `
+	PaidAt   *time.Time ` + "`bson:\"paidAt,omitempty\"`" + `
+	Address  *Address   ` + "`bson:\"address\"`" + `
+	Items    []LineItem ` + "`bson:\"items\"`" + `
+	Internal string     ` + "`bson:\"-\"`" + `
+	Notes    string
+
+	secret string
+}
+`
+
+func newTestGenerator(t *testing.T) *generator {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "models.go"), []byte(modelsSource), 0o600))
+
+	g, err := newGenerator(dir, "order_fields.go")
+	require.NoError(t, err)
+
+	return g
+}
+
+func TestGenerate_FieldPaths(t *testing.T) {
+	t.Parallel()
+
+	src, err := newTestGenerator(t).generate([]string{"Order"}, false)
+	require.NoError(t, err)
+
+	out := strings.Join(strings.Fields(string(src)), " ")
+	assert.Contains(t, out, "package models")
+	assert.Contains(t, out, `OrderFieldID = "_id"`)
+	assert.Contains(t, out, `OrderFieldCreatedAt = "createdAt"`)
+	assert.Contains(t, out, `OrderFieldEmail = "email"`)
+	assert.Contains(t, out, `OrderFieldAddressCity = "address.city"`)
+	assert.Contains(t, out, `OrderFieldItemsSKU = "items.sku"`)
+	assert.Contains(t, out, `OrderFieldItemsQuantity = "items.qty"`)
+	assert.Contains(t, out, `OrderFieldNotes = "notes"`)
+	assert.Contains(t, out, `OrderExprItemsQuantity = "$items.qty"`)
+	assert.NotContains(t, out, "Internal")
+	assert.NotContains(t, out, "secret")
+	assert.NotContains(t, out, "OrderRepository")
+}
+
+func TestGenerate_Finders(t *testing.T) {
+	t.Parallel()
+
+	src, err := newTestGenerator(t).generate([]string{"Order"}, true)
+	require.NoError(t, err)
+
+	out := strings.Join(strings.Fields(string(src)), " ")
+	assert.Contains(t, out, "*friendlymongo.BaseRepository[*Order]")
+	assert.Contains(t, out, "func NewOrderRepository(db *mongo.Database, collectionName string, opts ...friendlymongo.RepositoryOptsFunc) *OrderRepository")
+	assert.Contains(t, out, "func (r *OrderRepository) FindByEmail(ctx context.Context, value string) ([]*Order, error)")
+	assert.Contains(t, out, "func (r *OrderRepository) FindByStatusIn(ctx context.Context, values ...Status) ([]*Order, error)")
+	assert.Contains(t, out, "func (r *OrderRepository) FindByPaidAt(ctx context.Context, value time.Time) ([]*Order, error)")
+	assert.Contains(t, out, "func (r *OrderRepository) FindByCreatedAtIn(ctx context.Context, values ...time.Time) ([]*Order, error)")
+	assert.Contains(t, out, `"time"`)
+	assert.NotContains(t, out, "FindByID")
+	assert.NotContains(t, out, "FindByItems")
+	assert.NotContains(t, out, "FindByAddress")
+}
+
+func TestGenerate_UnknownType(t *testing.T) {
+	t.Parallel()
+
+	_, err := newTestGenerator(t).generate([]string{"Missing"}, true)
+	require.Error(t, err)
+}
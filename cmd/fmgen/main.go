@@ -0,0 +1,70 @@
+// Command fmgen generates typed bson field-path constants and finder methods for friendlymongo models.
+//
+// It is meant to be invoked through go generate from the package declaring the models:
+//
+//	//go:generate go run github.com/pmatteo/friendlymongo/cmd/fmgen -type=User,Order
+//
+// For every type it emits a constant per bson field path (nested documents use the dotted notation), the same
+// paths in their `$`-prefixed aggregation expression form and a repository wrapper embedding
+// friendlymongo.BaseRepository with FindBy<Field> and FindBy<Field>In methods for every top-level scalar field.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names; must be set")
+	output    = flag.String("output", "", "output file name; default srcdir/<type>_fields.go")
+	finders   = flag.Bool("finders", true, "generate the repository wrapper with typed finder methods")
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage of fmgen:\n")
+	fmt.Fprintf(os.Stderr, "\tfmgen [flags] -type T [directory]\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("fmgen: ")
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if len(*typeNames) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	types := strings.Split(*typeNames, ",")
+
+	dir := "."
+	if args := flag.Args(); len(args) > 0 {
+		dir = args[0]
+	}
+
+	outputName := *output
+	if outputName == "" {
+		outputName = filepath.Join(dir, strings.ToLower(types[0])+"_fields.go")
+	}
+
+	g, err := newGenerator(dir, filepath.Base(outputName))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := g.generate(types, *finders)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(outputName, src, 0o644); err != nil {
+		log.Fatalf("writing output: %s", err)
+	}
+}
@@ -0,0 +1,469 @@
+package friendlymongo
+
+import (
+	"encoding"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strconv"
+	"time"
+	"unsafe"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Codec couples a Go type with the BSON encoder and decoder handling it.
+type Codec struct {
+	Type    reflect.Type
+	Encoder bsoncodec.ValueEncoder
+	Decoder bsoncodec.ValueDecoder
+}
+
+// Register registers the codec on reg.
+func (c Codec) Register(reg *bsoncodec.Registry) {
+
+	reg.RegisterTypeEncoder(c.Type, c.Encoder)
+	reg.RegisterTypeDecoder(c.Type, c.Decoder)
+}
+
+var (
+	tTextMarshaler   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	tTextUnmarshaler = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	tStructTagParser = reflect.TypeOf((*bsoncodec.StructTagParser)(nil)).Elem()
+)
+
+// DecimalCodec returns a Codec storing values of the decimal type t, such as shopspring's decimal.Decimal, as BSON
+// Decimal128. The type must implement encoding.TextMarshaler and its pointer encoding.TextUnmarshaler.
+//
+// Decoding also accepts strings, doubles and integers, so that existing documents can be read.
+func DecimalCodec(t reflect.Type) Codec {
+
+	if !t.Implements(tTextMarshaler) || !reflect.PointerTo(t).Implements(tTextUnmarshaler) {
+		panic(fmt.Errorf("decimal type %s must implement encoding.TextMarshaler and encoding.TextUnmarshaler", t))
+	}
+
+	enc := bsoncodec.ValueEncoderFunc(func(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+		if !val.IsValid() || val.Type() != t {
+			return bsoncodec.ValueEncoderError{Name: "DecimalCodec", Types: []reflect.Type{t}, Received: val}
+		}
+
+		text, err := val.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+
+		d, err := primitive.ParseDecimal128(string(text))
+		if err != nil {
+			return err
+		}
+
+		return vw.WriteDecimal128(d)
+	})
+
+	dec := bsoncodec.ValueDecoderFunc(func(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+		if !val.CanSet() || val.Type() != t {
+			return bsoncodec.ValueDecoderError{Name: "DecimalCodec", Types: []reflect.Type{t}, Received: val}
+		}
+
+		var text string
+		switch vr.Type() {
+		case bsontype.Decimal128:
+			d, err := vr.ReadDecimal128()
+			if err != nil {
+				return err
+			}
+			text = d.String()
+		case bsontype.String:
+			s, err := vr.ReadString()
+			if err != nil {
+				return err
+			}
+			text = s
+		case bsontype.Double:
+			f, err := vr.ReadDouble()
+			if err != nil {
+				return err
+			}
+			text = strconv.FormatFloat(f, 'f', -1, 64)
+		case bsontype.Int32:
+			i, err := vr.ReadInt32()
+			if err != nil {
+				return err
+			}
+			text = strconv.FormatInt(int64(i), 10)
+		case bsontype.Int64:
+			i, err := vr.ReadInt64()
+			if err != nil {
+				return err
+			}
+			text = strconv.FormatInt(i, 10)
+		case bsontype.Null:
+			val.Set(reflect.Zero(t))
+			return vr.ReadNull()
+		default:
+			return fmt.Errorf("cannot decode %v into %s", vr.Type(), t)
+		}
+
+		v := reflect.New(t)
+		if err := v.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(text)); err != nil {
+			return err
+		}
+		val.Set(v.Elem())
+		return nil
+	})
+
+	return Codec{Type: t, Encoder: enc, Decoder: dec}
+}
+
+// UUIDCodec returns a Codec storing values of the 16 bytes array type t, such as google's uuid.UUID, as BSON binary
+// of subtype 4.
+//
+// Decoding also accepts binaries of the legacy subtype 3 and canonical textual representations.
+func UUIDCodec(t reflect.Type) Codec {
+
+	if t.Kind() != reflect.Array || t.Len() != 16 || t.Elem().Kind() != reflect.Uint8 {
+		panic(fmt.Errorf("uuid type %s must be a [16]byte array", t))
+	}
+
+	enc := bsoncodec.ValueEncoderFunc(func(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+		if !val.IsValid() || val.Type() != t {
+			return bsoncodec.ValueEncoderError{Name: "UUIDCodec", Types: []reflect.Type{t}, Received: val}
+		}
+
+		b := make([]byte, 16)
+		reflect.Copy(reflect.ValueOf(b), val)
+		return vw.WriteBinaryWithSubtype(b, bsontype.BinaryUUID)
+	})
+
+	dec := bsoncodec.ValueDecoderFunc(func(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+		if !val.CanSet() || val.Type() != t {
+			return bsoncodec.ValueDecoderError{Name: "UUIDCodec", Types: []reflect.Type{t}, Received: val}
+		}
+
+		var b []byte
+		switch vr.Type() {
+		case bsontype.Binary:
+			data, subtype, err := vr.ReadBinary()
+			if err != nil {
+				return err
+			}
+			if subtype != bsontype.BinaryUUID && subtype != bsontype.BinaryUUIDOld {
+				return fmt.Errorf("cannot decode binary subtype %d into %s", subtype, t)
+			}
+			b = data
+		case bsontype.String:
+			s, err := vr.ReadString()
+			if err != nil {
+				return err
+			}
+			if b, err = parseUUID(s); err != nil {
+				return err
+			}
+		case bsontype.Null:
+			val.Set(reflect.Zero(t))
+			return vr.ReadNull()
+		default:
+			return fmt.Errorf("cannot decode %v into %s", vr.Type(), t)
+		}
+
+		if len(b) != 16 {
+			return fmt.Errorf("cannot decode %d bytes into %s", len(b), t)
+		}
+		reflect.Copy(val, reflect.ValueOf(b))
+		return nil
+	})
+
+	return Codec{Type: t, Encoder: enc, Decoder: dec}
+}
+
+func parseUUID(s string) ([]byte, error) {
+
+	hex := make([]byte, 0, 32)
+	for i := 0; i < len(s); i++ {
+		if s[i] != '-' {
+			hex = append(hex, s[i])
+		}
+	}
+	if len(hex) != 32 {
+		return nil, fmt.Errorf("invalid uuid %q", s)
+	}
+
+	b := make([]byte, 16)
+	for i := range b {
+		v, err := strconv.ParseUint(string(hex[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uuid %q", s)
+		}
+		b[i] = byte(v)
+	}
+
+	return b, nil
+}
+
+var tAddr = reflect.TypeOf(netip.Addr{})
+
+// NetipAddrCodec returns a Codec storing netip.Addr values as strings. The zero Addr is stored as null.
+func NetipAddrCodec() Codec {
+
+	enc := bsoncodec.ValueEncoderFunc(func(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+		if !val.IsValid() || val.Type() != tAddr {
+			return bsoncodec.ValueEncoderError{Name: "NetipAddrCodec", Types: []reflect.Type{tAddr}, Received: val}
+		}
+
+		addr := val.Interface().(netip.Addr)
+		if !addr.IsValid() {
+			return vw.WriteNull()
+		}
+
+		return vw.WriteString(addr.String())
+	})
+
+	dec := bsoncodec.ValueDecoderFunc(func(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+		if !val.CanSet() || val.Type() != tAddr {
+			return bsoncodec.ValueDecoderError{Name: "NetipAddrCodec", Types: []reflect.Type{tAddr}, Received: val}
+		}
+
+		switch vr.Type() {
+		case bsontype.String:
+			s, err := vr.ReadString()
+			if err != nil {
+				return err
+			}
+
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return err
+			}
+			val.Set(reflect.ValueOf(addr))
+			return nil
+		case bsontype.Null:
+			val.Set(reflect.Zero(tAddr))
+			return vr.ReadNull()
+		default:
+			return fmt.Errorf("cannot decode %v into %s", vr.Type(), tAddr)
+		}
+	})
+
+	return Codec{Type: tAddr, Encoder: enc, Decoder: dec}
+}
+
+var tDuration = reflect.TypeOf(time.Duration(0))
+
+// DurationCodec returns a Codec storing time.Duration values in their textual form, e.g. "1h30m0s".
+//
+// Decoding also accepts integers, interpreted as nanoseconds as stored by the default codec.
+func DurationCodec() Codec {
+
+	enc := bsoncodec.ValueEncoderFunc(func(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+		if !val.IsValid() || val.Type() != tDuration {
+			return bsoncodec.ValueEncoderError{Name: "DurationCodec", Types: []reflect.Type{tDuration}, Received: val}
+		}
+
+		return vw.WriteString(time.Duration(val.Int()).String())
+	})
+
+	dec := bsoncodec.ValueDecoderFunc(func(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+		if !val.CanSet() || val.Type() != tDuration {
+			return bsoncodec.ValueDecoderError{Name: "DurationCodec", Types: []reflect.Type{tDuration}, Received: val}
+		}
+
+		switch vr.Type() {
+		case bsontype.String:
+			s, err := vr.ReadString()
+			if err != nil {
+				return err
+			}
+
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			val.SetInt(int64(d))
+		case bsontype.Int64:
+			i, err := vr.ReadInt64()
+			if err != nil {
+				return err
+			}
+			val.SetInt(i)
+		case bsontype.Int32:
+			i, err := vr.ReadInt32()
+			if err != nil {
+				return err
+			}
+			val.SetInt(int64(i))
+		case bsontype.Null:
+			val.SetInt(0)
+			return vr.ReadNull()
+		default:
+			return fmt.Errorf("cannot decode %v into %s", vr.Type(), tDuration)
+		}
+
+		return nil
+	})
+
+	return Codec{Type: tDuration, Encoder: enc, Decoder: dec}
+}
+
+// EnumCodec returns a Codec for the string-backed enum type E that only accepts the given values, both when encoding
+// and decoding.
+//
+//	friendlymongo.EnumCodec(StatusPending, StatusPaid, StatusShipped)
+func EnumCodec[E ~string](values ...E) Codec {
+
+	t := reflect.TypeOf((*E)(nil)).Elem()
+
+	valid := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		valid[string(v)] = struct{}{}
+	}
+
+	enc := bsoncodec.ValueEncoderFunc(func(_ bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+		if !val.IsValid() || val.Type() != t {
+			return bsoncodec.ValueEncoderError{Name: "EnumCodec", Types: []reflect.Type{t}, Received: val}
+		}
+
+		s := val.String()
+		if _, ok := valid[s]; !ok {
+			return fmt.Errorf("invalid %s value %q", t, s)
+		}
+
+		return vw.WriteString(s)
+	})
+
+	dec := bsoncodec.ValueDecoderFunc(func(_ bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+		if !val.CanSet() || val.Type() != t {
+			return bsoncodec.ValueDecoderError{Name: "EnumCodec", Types: []reflect.Type{t}, Received: val}
+		}
+
+		if vr.Type() == bsontype.Null {
+			val.SetString("")
+			return vr.ReadNull()
+		}
+
+		s, err := vr.ReadString()
+		if err != nil {
+			return err
+		}
+		if _, ok := valid[s]; !ok {
+			return fmt.Errorf("invalid %s value %q", t, s)
+		}
+
+		val.SetString(s)
+		return nil
+	})
+
+	return Codec{Type: t, Encoder: enc, Decoder: dec}
+}
+
+// deriveRegistry returns a new registry encoding and decoding every type as base does, on which codecs can be
+// registered without modifying base.
+func deriveRegistry(base *bsoncodec.Registry) *bsoncodec.Registry {
+
+	if base == nil || base == bson.DefaultRegistry {
+		return bson.NewRegistry()
+	}
+
+	// The types without a codec of their own in the derived registry fall back to their kind, whose codec looks them
+	// up in base. The struct and pointer codecs cache the codecs of the fields and elements they resolve in the first
+	// registry using them, so the derived registry has its own, configured as the ones of base.
+	inherited := &inheritedCodec{base: base, structs: structCodecOf(base), ptrs: bsoncodec.NewPointerCodec()}
+
+	reg := bsoncodec.NewRegistry()
+	for k := reflect.Bool; k <= reflect.UnsafePointer; k++ {
+		reg.RegisterKindEncoder(k, inherited)
+		reg.RegisterKindDecoder(k, inherited)
+	}
+
+	// The type map entry of bsontype 0 applies to top-level documents.
+	types := []bsontype.Type{0, bsontype.MinKey, bsontype.MaxKey}
+	for bt := bsontype.Double; bt <= bsontype.Decimal128; bt++ {
+		types = append(types, bt)
+	}
+	for _, bt := range types {
+		if rt, err := base.LookupTypeMapEntry(bt); err == nil {
+			reg.RegisterTypeMapEntry(bt, rt)
+		}
+	}
+
+	return reg
+}
+
+// structCodecOf returns a new struct codec with the settings and the tag parser of the struct codec of base, or a
+// default one when base encodes structs with another codec.
+func structCodecOf(base *bsoncodec.Registry) *bsoncodec.StructCodec {
+
+	var parser bsoncodec.StructTagParser = bsoncodec.DefaultStructTagParser
+
+	enc, _ := base.LookupEncoder(reflect.TypeOf(struct{}{}))
+	sc, ok := enc.(*bsoncodec.StructCodec)
+	if !ok {
+		structs, _ := bsoncodec.NewStructCodec(parser) // only fails with a nil parser
+		return structs
+	}
+
+	// The driver does not export the parser of a struct codec: it is read from its field, as long as it exists.
+	src := reflect.ValueOf(sc).Elem()
+	if f := src.FieldByName("parser"); f.IsValid() && f.Type() == tStructTagParser {
+		p := reflect.NewAt(tStructTagParser, unsafe.Pointer(f.UnsafeAddr())).Elem().Interface()
+		if p, ok := p.(bsoncodec.StructTagParser); ok {
+			parser = p
+		}
+	}
+
+	structs, _ := bsoncodec.NewStructCodec(parser)
+
+	// The settings are the exported fields, such as EncodeOmitDefaultStruct and DecodeZeroStruct.
+	dst := reflect.ValueOf(structs).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if src.Type().Field(i).IsExported() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+
+	return structs
+}
+
+// inheritedCodec encodes and decodes values with the codec of their type in base.
+type inheritedCodec struct {
+	base    *bsoncodec.Registry
+	structs *bsoncodec.StructCodec
+	ptrs    *bsoncodec.PointerCodec
+}
+
+func (c *inheritedCodec) EncodeValue(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+
+	enc, err := c.base.LookupEncoder(val.Type())
+	if err != nil {
+		return err
+	}
+
+	switch enc.(type) {
+	case *bsoncodec.StructCodec:
+		enc = c.structs
+	case *bsoncodec.PointerCodec:
+		enc = c.ptrs
+	}
+
+	return enc.EncodeValue(ec, vw, val)
+}
+
+func (c *inheritedCodec) DecodeValue(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+
+	dec, err := c.base.LookupDecoder(val.Type())
+	if err != nil {
+		return err
+	}
+
+	switch dec.(type) {
+	case *bsoncodec.StructCodec:
+		dec = c.structs
+	case *bsoncodec.PointerCodec:
+		dec = c.ptrs
+	}
+
+	return dec.DecodeValue(dc, vr, val)
+}
@@ -0,0 +1,215 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"math/big"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// money is a minimal decimal type implementing the text marshaling interfaces, as shopspring's decimal does.
+type money struct {
+	r *big.Rat
+}
+
+func (m money) MarshalText() ([]byte, error) {
+	return []byte(m.r.FloatString(2)), nil
+}
+
+func (m *money) UnmarshalText(b []byte) error {
+	r, ok := new(big.Rat).SetString(string(b))
+	if !ok {
+		return assert.AnError
+	}
+	m.r = r
+	return nil
+}
+
+type uuid [16]byte
+
+type status string
+
+const (
+	statusPending status = "pending"
+	statusPaid    status = "paid"
+)
+
+type invoice struct {
+	fm.BaseModel `bson:",inline"`
+
+	Number  string        `bson:"number"`
+	Total   money         `bson:"total"`
+	Ref     uuid          `bson:"ref"`
+	Client  netip.Addr    `bson:"client"`
+	Timeout time.Duration `bson:"timeout"`
+	Status  status        `bson:"status"`
+}
+
+func invoiceCodecs() []fm.Codec {
+	return []fm.Codec{
+		fm.DecimalCodec(reflect.TypeOf(money{})),
+		fm.UUIDCodec(reflect.TypeOf(uuid{})),
+		fm.NetipAddrCodec(),
+		fm.DurationCodec(),
+		fm.EnumCodec(statusPending, statusPaid),
+	}
+}
+
+func newInvoice(number string) *invoice {
+	return &invoice{
+		Number:  number,
+		Total:   money{r: big.NewRat(1999, 100)},
+		Ref:     uuid{0x12, 0x34, 15: 0xff},
+		Client:  netip.MustParseAddr("192.168.1.10"),
+		Timeout: 90 * time.Second,
+		Status:  statusPaid,
+	}
+}
+
+func TestCodecs_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	reg := bson.NewRegistry()
+	for _, c := range invoiceCodecs() {
+		c.Register(reg)
+	}
+
+	raw, err := bson.MarshalWithRegistry(reg, newInvoice("codec roundtrip"))
+	require.NoError(t, err)
+
+	doc := bson.Raw(raw)
+	assert.Equal(t, bsontype.Decimal128, doc.Lookup("total").Type)
+	assert.Equal(t, bsontype.Binary, doc.Lookup("ref").Type)
+	assert.Equal(t, "192.168.1.10", doc.Lookup("client").StringValue())
+	assert.Equal(t, "1m30s", doc.Lookup("timeout").StringValue())
+	assert.Equal(t, "paid", doc.Lookup("status").StringValue())
+
+	var decoded invoice
+	require.NoError(t, bson.UnmarshalWithRegistry(reg, raw, &decoded))
+	assert.Equal(t, "19.99", decoded.Total.r.FloatString(2))
+	assert.Equal(t, newInvoice("").Ref, decoded.Ref)
+	assert.Equal(t, netip.MustParseAddr("192.168.1.10"), decoded.Client)
+	assert.Equal(t, 90*time.Second, decoded.Timeout)
+	assert.Equal(t, statusPaid, decoded.Status)
+}
+
+func TestCodecs_EnumRejectsUnknownValues(t *testing.T) {
+	t.Parallel()
+
+	reg := bson.NewRegistry()
+	fm.EnumCodec(statusPending, statusPaid).Register(reg)
+
+	_, err := bson.MarshalWithRegistry(reg, bson.M{"status": status("lost")})
+	require.Error(t, err)
+
+	raw, err := bson.Marshal(bson.M{"status": "lost"})
+	require.NoError(t, err)
+
+	var decoded struct {
+		Status status `bson:"status"`
+	}
+	require.Error(t, bson.UnmarshalWithRegistry(reg, raw, &decoded))
+}
+
+func TestCodecs_Repository(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	invoices := fm.NewBaseRepository(
+		fm.GetInstance().Database(testDB), "invoices", new(invoice), fm.WithCodecs(invoiceCodecs()...),
+	)
+
+	require.NoError(t, invoices.InsertOne(context.Background(), newInvoice("codec repository")))
+
+	found, err := invoices.FindOne(context.Background(), bson.M{"number": "codec repository"})
+	require.NoError(t, err)
+	assert.Equal(t, "19.99", found.Total.r.FloatString(2))
+	assert.Equal(t, 90*time.Second, found.Timeout)
+
+	var aggregated []*invoice
+	err = invoices.Aggregate(
+		context.Background(),
+		fm.NewStageBuilder().Match("number", bson.M{"number": "codec repository"}).Build(),
+		&aggregated,
+	)
+	require.NoError(t, err)
+	require.Len(t, aggregated, 1)
+	assert.Equal(t, netip.MustParseAddr("192.168.1.10"), aggregated[0].Client)
+	assert.Equal(t, statusPaid, aggregated[0].Status)
+}
+
+func TestCodecs_DerivedRegistry(t *testing.T) {
+	t.Parallel()
+
+	reg := bson.NewRegistry()
+	fm.DurationCodec().Register(reg)
+
+	codecs := []fm.Codec{
+		fm.DecimalCodec(reflect.TypeOf(money{})),
+		fm.UUIDCodec(reflect.TypeOf(uuid{})),
+		fm.NetipAddrCodec(),
+		fm.EnumCodec(statusPending, statusPaid),
+	}
+	invoices := fm.NewMemoryRepository(new(invoice), fm.WithRegistry(reg), fm.WithCodecs(codecs...))
+	defaults := fm.NewMemoryRepository(new(invoice), fm.WithRegistry(bson.DefaultRegistry), fm.WithCodecs(codecs...))
+
+	for _, r := range []*fm.MemoryRepository[*invoice]{invoices, defaults} {
+		_, err := r.InsertMany(context.Background(), []*invoice{newInvoice("derived")})
+		require.NoError(t, err)
+	}
+
+	total, err := primitive.ParseDecimal128("19.99")
+	require.NoError(t, err)
+
+	n, err := invoices.Count(context.Background(), bson.M{"total": total, "timeout": "1m30s"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n, "the codecs of the registry and of the options both apply")
+
+	for _, r := range []*bsoncodec.Registry{reg, bson.DefaultRegistry} {
+		enc, err := r.LookupEncoder(reflect.TypeOf(money{}))
+		require.NoError(t, err)
+		assert.IsType(t, &bsoncodec.StructCodec{}, enc, "the registry set with WithRegistry is not modified")
+	}
+}
+
+// receipt only names its fields with json tags, which a registry with the JSON fallback tag parser reads.
+type receipt struct {
+	fm.BaseModel `bson:",inline"`
+
+	Number  string        `json:"no"`
+	Timeout time.Duration `json:"wait"`
+}
+
+func TestCodecs_DerivedRegistryTagParser(t *testing.T) {
+	t.Parallel()
+
+	structs, err := bsoncodec.NewStructCodec(bsoncodec.JSONFallbackStructTagParser)
+	require.NoError(t, err)
+
+	reg := bson.NewRegistry()
+	reg.RegisterKindEncoder(reflect.Struct, structs)
+	reg.RegisterKindDecoder(reflect.Struct, structs)
+
+	r := fm.NewMemoryRepository(new(receipt), fm.WithRegistry(reg), fm.WithCodecs(fm.DurationCodec()))
+	ctx := context.Background()
+
+	require.NoError(t, r.InsertOne(ctx, &receipt{Number: "R-1", Timeout: 90 * time.Second}))
+
+	n, err := r.Count(ctx, bson.M{"no": "R-1", "wait": "1m30s"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n, "the derived registry parses tags as the registry set with WithRegistry")
+
+	found, err := r.FindOne(ctx, bson.M{"no": "R-1"})
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, found.Timeout)
+}
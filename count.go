@@ -0,0 +1,138 @@
+package friendlymongo
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Count counts the documents matching filter. It accepts the WithLimit, WithSkip, WithCollation, WithHint,
+// WithMaxTime and WithComment options.
+func (r *BaseRepository[T]) Count(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (int64, error) {
+
+	op := &Operation{Kind: "Count", Collection: r.collection.Name(), Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (int64, error) {
+		return r.count(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *BaseRepository[T]) count(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (_ int64, err error) {
+
+	defer r.wrapErr(&err, "Count", filter)
+
+	o, err := newQueryOpts("Count", opts, countSupported...)
+	if err != nil {
+		return 0, err
+	}
+
+	return r.collection.CountDocuments(ctx, filter, o.countOptions())
+}
+
+// Exists reports whether at least one document matches filter. Only the _id of the first matching document is
+// fetched.
+func (r *BaseRepository[T]) Exists(ctx context.Context, filter interface{}) (bool, error) {
+
+	op := &Operation{Kind: "Exists", Collection: r.collection.Name(), Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (bool, error) {
+		return r.exists(ctx, op.Filter)
+	})
+}
+
+func (r *BaseRepository[T]) exists(ctx context.Context, filter interface{}) (_ bool, err error) {
+
+	defer r.wrapErr(&err, "Exists", filter)
+
+	opts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}})
+
+	err = r.collection.FindOne(ctx, filter, opts).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// EstimatedCount returns an estimate of the number of documents in the collection, based on its metadata. It is
+// faster than Count but may be inaccurate, for instance after an unclean shutdown or within a transaction.
+func (r *BaseRepository[T]) EstimatedCount(ctx context.Context) (int64, error) {
+
+	op := &Operation{Kind: "EstimatedCount", Collection: r.collection.Name()}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (int64, error) {
+		return r.estimatedCount(ctx)
+	})
+}
+
+func (r *BaseRepository[T]) estimatedCount(ctx context.Context) (_ int64, err error) {
+
+	defer r.wrapErr(&err, "EstimatedCount", nil)
+
+	return r.collection.EstimatedDocumentCount(ctx)
+}
+
+func (r *BaseRepository[T]) distinct(ctx context.Context, field string, filter interface{}) ([]interface{}, error) {
+
+	op := &Operation{Kind: "Distinct", Collection: r.collection.Name(), Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) ([]interface{}, error) {
+		return r.distinctValues(ctx, field, op.Filter)
+	})
+}
+
+func (r *BaseRepository[T]) distinctValues(
+	ctx context.Context,
+	field string,
+	filter interface{},
+) (_ []interface{}, err error) {
+
+	defer r.wrapErr(&err, "Distinct", filter)
+
+	return r.collection.Distinct(ctx, field, filter)
+}
+
+func (r *BaseRepository[T]) codecRegistry() *bsoncodec.Registry {
+
+	return r.registry
+}
+
+func (r *BaseRepository[T]) collectionName() string {
+
+	return r.collection.Name()
+}
+
+// DistinctSource is a repository Distinct can run on. It is implemented by *BaseRepository and *MemoryRepository, and
+// cannot be implemented outside this package.
+type DistinctSource interface {
+	distinct(ctx context.Context, field string, filter interface{}) ([]interface{}, error)
+	codecRegistry() *bsoncodec.Registry
+	collectionName() string
+}
+
+// Distinct returns the distinct values of field among the documents of r matching filter, decoded as V with the
+// repository registry. r is a *BaseRepository or a *MemoryRepository.
+//
+//	artists, err := friendlymongo.Distinct[string](ctx, repo, "artist", bson.M{})
+func Distinct[V any](ctx context.Context, r DistinctSource, field string, filter interface{}) ([]V, error) {
+
+	values, err := r.distinct(ctx, field, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Round-trip the values through BSON so that they are decoded like document fields.
+	raw, err := bson.Marshal(bson.D{{Key: "values", Value: bson.A(values)}})
+	if err != nil {
+		return nil, wrapError("Distinct", r.collectionName(), r.codecRegistry(), filter, err)
+	}
+
+	var res struct {
+		Values []V `bson:"values"`
+	}
+	if err := bson.UnmarshalWithRegistry(r.codecRegistry(), raw, &res); err != nil {
+		return nil, wrapError("Distinct", r.collectionName(), r.codecRegistry(), filter, err)
+	}
+
+	return res.Values, nil
+}
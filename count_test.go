@@ -0,0 +1,89 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newCountArtworkRepo(t *testing.T, collection string) *fm.BaseRepository[*artwork] {
+	r := fm.NewBaseRepository(fm.GetInstance().Database(testDB), collection, new(artwork))
+
+	_, err := r.Delete(context.Background(), bson.M{})
+	require.NoError(t, err)
+
+	_, err = r.InsertMany(context.Background(), []*artwork{
+		{Title: "Water Lilies", Artist: "Monet", Year: 1906, Tags: []string{"impressionism", "oil"}},
+		{Title: "Impression, Sunrise", Artist: "Monet", Year: 1872, Tags: []string{"impressionism"}},
+		{Title: "The Starry Night", Artist: "Van Gogh", Year: 1889, Tags: []string{"post-impressionism", "oil"}},
+	})
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestCount(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newCountArtworkRepo(t, "counts")
+
+	count, err := r.Count(context.Background(), bson.M{"artist": "Monet"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	count, err = r.Count(context.Background(), bson.M{}, fm.WithSkip(1), fm.WithLimit(1))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	_, err = r.Count(context.Background(), bson.M{}, fm.WithSort(bson.M{"year": 1}))
+	assert.EqualError(t, err, "Count on counts with filter {}: option sort is not supported by Count")
+
+	estimated, err := r.EstimatedCount(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), estimated)
+}
+
+func TestExists(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newCountArtworkRepo(t, "exists")
+
+	exists, err := r.Exists(context.Background(), bson.M{"artist": "Van Gogh"})
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = r.Exists(context.Background(), bson.M{"artist": "Vermeer"})
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestDistinct(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newCountArtworkRepo(t, "distinct")
+
+	artists, err := fm.Distinct[string](context.Background(), r, "artist", bson.M{})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Monet", "Van Gogh"}, artists)
+
+	tags, err := fm.Distinct[string](context.Background(), r, "tags", bson.M{"artist": "Monet"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"impressionism", "oil"}, tags)
+
+	years, err := fm.Distinct[int](context.Background(), r, "year", bson.M{"year": bson.M{"$gt": 1880}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{1889, 1906}, years)
+
+	_, err = fm.Distinct[int](context.Background(), r, "artist", bson.M{})
+	var fmErr *fm.Error
+	require.ErrorAs(t, err, &fmErr)
+	assert.Equal(t, "Distinct", fmErr.Op)
+	assert.Equal(t, "distinct", fmErr.Collection, "decode errors report the collection")
+}
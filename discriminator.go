@@ -0,0 +1,177 @@
+package friendlymongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Discriminator is a registry of the concrete types stored in a polymorphic collection.
+//
+// Each registered type is identified by a name stored in the discriminator field of its documents. A repository
+// configured with a Discriminator (see WithDiscriminator) stamps the field on insert and decodes every document into
+// the type registered for its name, so that T can be an interface implemented by several structs.
+type Discriminator struct {
+	field string
+	types map[string]reflect.Type
+	names map[reflect.Type]string
+}
+
+// NewDiscriminator creates a new Discriminator storing the type name in the given document field.
+func NewDiscriminator(field string) *Discriminator {
+
+	return &Discriminator{
+		field: field,
+		types: make(map[string]reflect.Type),
+		names: make(map[reflect.Type]string),
+	}
+}
+
+// Register associates name with the type of sample, which must be a pointer to a struct implementing the Model
+// interface.
+//
+// It panics if either the name or the type has already been registered.
+func (d *Discriminator) Register(name string, sample Model) *Discriminator {
+
+	t := reflect.TypeOf(sample)
+	if t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct {
+		panic(fmt.Errorf("discriminated type %s must be a pointer to a struct", t))
+	}
+
+	if _, ok := d.types[name]; ok {
+		panic(fmt.Errorf("discriminator name %s already registered", name))
+	}
+	if _, ok := d.names[t]; ok {
+		panic(fmt.Errorf("discriminated type %s already registered", t))
+	}
+
+	d.types[name] = t
+	d.names[t] = name
+	return d
+}
+
+// Field returns the name of the document field holding the discriminator value.
+func (d *Discriminator) Field() string {
+
+	return d.field
+}
+
+// NameOf returns the name under which the type of v has been registered.
+func (d *Discriminator) NameOf(v interface{}) (string, bool) {
+
+	name, ok := d.names[reflect.TypeOf(v)]
+	return name, ok
+}
+
+// stamp returns the document with the discriminator field set to the name registered for v. Any existing value of
+// the field is overwritten.
+func (d *Discriminator) stamp(raw bson.Raw, v interface{}) (bson.Raw, error) {
+
+	name, ok := d.NameOf(v)
+	if !ok {
+		return nil, fmt.Errorf("type %T is not registered in the discriminator", v)
+	}
+
+	elems, err := bsoncore.Document(raw).Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	idx, doc := bsoncore.AppendDocumentStart(nil)
+	for _, e := range elems {
+		if e.Key() != d.field {
+			doc = append(doc, e...)
+		}
+	}
+	doc = bsoncore.AppendStringElement(doc, d.field, name)
+
+	doc, err = bsoncore.AppendDocumentEnd(doc, idx)
+	return bson.Raw(doc), err
+}
+
+// newInstance returns a pointer to a new value of the type registered for the discriminator value of raw.
+func (d *Discriminator) newInstance(raw bson.Raw) (interface{}, error) {
+
+	val, err := raw.LookupErr(d.field)
+	if err != nil {
+		return nil, fmt.Errorf("document has no discriminator field %s: %w", d.field, err)
+	}
+
+	name, ok := val.StringValueOK()
+	if !ok {
+		return nil, fmt.Errorf("discriminator field %s must be a string, got %s", d.field, val.Type)
+	}
+
+	t, ok := d.types[name]
+	if !ok {
+		return nil, fmt.Errorf("no type registered for discriminator value %s", name)
+	}
+
+	return reflect.New(t.Elem()).Interface(), nil
+}
+
+// DiscriminatedSource is a repository FindOfType can run on. It is implemented by *BaseRepository and
+// *MemoryRepository, and cannot be implemented outside this package.
+type DiscriminatedSource[T Model] interface {
+	Find(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) ([]T, error)
+	discriminatorOf() *Discriminator
+	codecRegistry() *bsoncodec.Registry
+	collectionName() string
+}
+
+// FindOfType finds the documents of the collection whose discriminator matches the type C, which must have been
+// registered in the Discriminator of the repository. r is a *BaseRepository or a *MemoryRepository.
+//
+//	clicks, err := friendlymongo.FindOfType[*ClickEvent](ctx, eventsRepo, bson.M{"page": "/home"})
+func FindOfType[C Model, T Model](ctx context.Context, r DiscriminatedSource[T], filter interface{}) ([]C, error) {
+
+	d := r.discriminatorOf()
+	if d == nil {
+		return nil, wrapError("FindOfType", r.collectionName(), r.codecRegistry(), filter,
+			errors.New("repository has no discriminator"))
+	}
+
+	var sample C
+	name, ok := d.NameOf(sample)
+	if !ok {
+		return nil, wrapError("FindOfType", r.collectionName(), r.codecRegistry(), filter,
+			fmt.Errorf("type %T is not registered in the discriminator", sample))
+	}
+
+	documents, err := r.Find(ctx, andFilter(filter, bson.M{d.field: name}))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]C, 0, len(documents))
+	for _, document := range documents {
+		c, ok := any(document).(C)
+		if !ok {
+			return nil, wrapError("FindOfType", r.collectionName(), r.codecRegistry(), filter,
+				fmt.Errorf("document of type %T is not a %T", document, sample))
+		}
+		result = append(result, c)
+	}
+
+	return result, nil
+}
+
+func (r *BaseRepository[T]) discriminatorOf() *Discriminator {
+
+	return r.discriminator
+}
+
+// andFilter combines a user filter with an additional condition.
+func andFilter(filter interface{}, cond bson.M) interface{} {
+
+	if filter == nil {
+		return cond
+	}
+
+	return bson.M{"$and": bson.A{filter, cond}}
+}
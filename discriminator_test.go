@@ -0,0 +1,166 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type event interface {
+	fm.Model
+	Source() string
+}
+
+type clickEvent struct {
+	fm.BaseModel `bson:",inline"`
+
+	Src    string `bson:"source"`
+	Target string `bson:"target"`
+}
+
+func (e *clickEvent) Source() string { return e.Src }
+
+type viewEvent struct {
+	fm.BaseModel `bson:",inline"`
+
+	Src      string `bson:"source"`
+	Duration int    `bson:"duration"`
+}
+
+func (e *viewEvent) Source() string { return e.Src }
+
+func newEventRepo() *fm.BaseRepository[event] {
+	d := fm.NewDiscriminator("kind").
+		Register("click", &clickEvent{}).
+		Register("view", &viewEvent{})
+
+	return fm.NewBaseRepository[event](
+		fm.GetInstance().Database(testDB), "events", nil, fm.WithDiscriminator(d),
+	)
+}
+
+func TestDiscriminator_Register(t *testing.T) {
+	t.Parallel()
+
+	d := fm.NewDiscriminator("kind").Register("click", &clickEvent{})
+
+	name, ok := d.NameOf(&clickEvent{})
+	assert.True(t, ok)
+	assert.Equal(t, "click", name)
+
+	_, ok = d.NameOf(&viewEvent{})
+	assert.False(t, ok)
+
+	assert.Panics(t, func() { d.Register("click", &viewEvent{}) })
+	assert.Panics(t, func() { d.Register("other", &clickEvent{}) })
+}
+
+func TestDiscriminator_FindPolymorphic(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	eventsRepo := newEventRepo()
+
+	_, err := eventsRepo.InsertMany(context.Background(), []event{
+		&clickEvent{Src: "poly", Target: "button"},
+		&viewEvent{Src: "poly", Duration: 42},
+	})
+	require.NoError(t, err)
+
+	raw, err := fm.GetInstance().Database(testDB).Collection("events").
+		FindOne(context.Background(), bson.M{"source": "poly", "target": "button"}).Raw()
+	require.NoError(t, err)
+	assert.Equal(t, "click", raw.Lookup("kind").StringValue())
+
+	events, err := eventsRepo.Find(context.Background(), bson.M{"source": "poly"})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	click, ok := events[0].(*clickEvent)
+	require.True(t, ok)
+	assert.Equal(t, "button", click.Target)
+	assert.False(t, click.ID.IsZero())
+
+	view, ok := events[1].(*viewEvent)
+	require.True(t, ok)
+	assert.Equal(t, 42, view.Duration)
+
+	one, err := eventsRepo.FindOne(context.Background(), bson.M{"source": "poly", "duration": 42})
+	require.NoError(t, err)
+	assert.IsType(t, &viewEvent{}, one)
+}
+
+func TestDiscriminator_FindOfType(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	eventsRepo := newEventRepo()
+
+	_, err := eventsRepo.InsertMany(context.Background(), []event{
+		&clickEvent{Src: "typed", Target: "link"},
+		&viewEvent{Src: "typed", Duration: 1},
+		&viewEvent{Src: "typed", Duration: 2},
+	})
+	require.NoError(t, err)
+
+	views, err := fm.FindOfType[*viewEvent](context.Background(), eventsRepo, bson.M{"source": "typed"})
+	require.NoError(t, err)
+	require.Len(t, views, 2)
+	assert.Equal(t, 1, views[0].Duration)
+	assert.Equal(t, 2, views[1].Duration)
+
+	clicks, err := fm.FindOfType[*clickEvent](context.Background(), eventsRepo, bson.M{"source": "typed"})
+	require.NoError(t, err)
+	require.Len(t, clicks, 1)
+	assert.Equal(t, "link", clicks[0].Target)
+}
+
+func TestDiscriminator_FindOfTypeMemory(t *testing.T) {
+	t.Parallel()
+
+	d := fm.NewDiscriminator("kind").
+		Register("click", &clickEvent{}).
+		Register("view", &viewEvent{})
+	r := fm.NewMemoryRepository[event](nil, fm.WithDiscriminator(d))
+	ctx := context.Background()
+
+	_, err := r.InsertMany(ctx, []event{
+		&clickEvent{Src: "typed", Target: "link"},
+		&viewEvent{Src: "typed", Duration: 1},
+		&viewEvent{Src: "other", Duration: 2},
+	})
+	require.NoError(t, err)
+
+	views, err := fm.FindOfType[*viewEvent](ctx, r, bson.M{"source": "typed"})
+	require.NoError(t, err)
+	require.Len(t, views, 1)
+	assert.Equal(t, 1, views[0].Duration)
+
+	clicks, err := fm.FindOfType[*clickEvent](ctx, r, nil)
+	require.NoError(t, err)
+	require.Len(t, clicks, 1)
+	assert.Equal(t, "link", clicks[0].Target)
+}
+
+func TestDiscriminator_FindOfTypeErrors(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	_, err := fm.FindOfType[*clickEvent](ctx, fm.NewMemoryRepository[event](nil), bson.M{})
+	var fmErr *fm.Error
+	require.ErrorAs(t, err, &fmErr)
+	assert.Equal(t, "FindOfType", fmErr.Op)
+	assert.EqualError(t, err, "FindOfType with filter {}: repository has no discriminator")
+
+	d := fm.NewDiscriminator("kind").Register("click", &clickEvent{})
+	_, err = fm.FindOfType[*viewEvent](ctx, fm.NewMemoryRepository[event](nil, fm.WithDiscriminator(d)), bson.M{})
+	require.ErrorAs(t, err, &fmErr)
+	assert.Equal(t, "FindOfType", fmErr.Op)
+	assert.EqualError(t, err,
+		"FindOfType with filter {}: type *friendlymongo_test.viewEvent is not registered in the discriminator")
+}
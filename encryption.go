@@ -0,0 +1,846 @@
+package friendlymongo
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/bsonrw"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+const (
+	// encryptedSubtype is the BSON binary subtype (user defined range) of encrypted values.
+	encryptedSubtype byte = 0x80
+
+	encryptedVersion byte = 1
+
+	modeRandom        byte = 0
+	modeDeterministic byte = 1
+
+	nonceSize = 12
+)
+
+// ErrUnknownKey is returned by a KeyProvider that does not know the requested key.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// KeyProvider supplies the keys used by the field-level encryption.
+//
+// New values are always encrypted with the current key, while every value stores the identifier of the key used to
+// encrypt it, so that rotating the current key does not prevent reading older documents.
+type KeyProvider interface {
+	// CurrentKey returns the identifier and the material of the key used to encrypt new values.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Key returns the material of the key with the given identifier.
+	Key(id string) ([]byte, error)
+}
+
+// KeyRing is an in-memory KeyProvider supporting key rotation.
+type KeyRing struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyRing creates a new KeyRing whose current key is key. Keys must be at least 16 bytes long.
+func NewKeyRing(id string, key []byte) (*KeyRing, error) {
+
+	k := &KeyRing{keys: make(map[string][]byte)}
+	if err := k.Rotate(id, key); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Rotate adds key to the ring and makes it the current one. Previous keys are kept to decrypt existing values.
+func (k *KeyRing) Rotate(id string, key []byte) error {
+
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("key id must be between 1 and 255 bytes long")
+	}
+	if len(key) < 16 {
+		return fmt.Errorf("key must be at least 16 bytes long")
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("key %s already exists", id)
+	}
+
+	k.keys[id] = append([]byte(nil), key...)
+	k.current = id
+	return nil
+}
+
+// CurrentKey returns the identifier and the material of the current key.
+func (k *KeyRing) CurrentKey() (string, []byte, error) {
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.current, k.keys[k.current], nil
+}
+
+// Key returns the material of the key with the given identifier.
+func (k *KeyRing) Key(id string) ([]byte, error) {
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+
+	return key, nil
+}
+
+// EncryptValue encrypts v in deterministic mode with the current key of p, so that it can be used to query by
+// equality a field tagged with `fm:"encrypt,deterministic"`. The field parameter is the bson key of such field.
+//
+//	email, err := friendlymongo.EncryptValue(keys, "email", "john.doe@test.com")
+//	user, err := repo.FindOne(ctx, bson.M{"email": email})
+//
+// Documents written with a previous key are only matched by values encrypted with that same key.
+func EncryptValue(p KeyProvider, field string, v interface{}) (primitive.Binary, error) {
+
+	t, data, err := bson.MarshalValue(v)
+	if err != nil {
+		return primitive.Binary{}, err
+	}
+
+	ciphertext, err := encrypt(newKeyCache(p), modeDeterministic, field, t, data)
+	if err != nil {
+		return primitive.Binary{}, err
+	}
+
+	return primitive.Binary{Subtype: encryptedSubtype, Data: ciphertext}, nil
+}
+
+// encrypt seals a BSON value. The produced data layout is:
+//
+//	version | mode | len(keyID) | keyID | nonce | AES-GCM ciphertext of (type | value)
+//
+// In deterministic mode the nonce is a MAC of the field and of the value, so that equal values encrypt equally.
+func encrypt(keys *keyCache, mode byte, field string, t bsontype.Type, value []byte) ([]byte, error) {
+
+	id, key, err := keys.current()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := append([]byte{byte(t)}, value...)
+
+	nonce := make([]byte, nonceSize)
+	if mode == modeDeterministic {
+		mac := hmac.New(sha256.New, key.sivKey)
+		mac.Write([]byte(field))
+		mac.Write([]byte{0})
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, 3+len(id)+nonceSize+len(plaintext)+key.aead.Overhead())
+	out = append(out, encryptedVersion, mode, byte(len(id)))
+	out = append(out, id...)
+	out = append(out, nonce...)
+
+	return key.aead.Seal(out, nonce, plaintext, []byte(field)), nil
+}
+
+// decrypt opens data produced by encrypt.
+func decrypt(keys *keyCache, field string, data []byte) (bsontype.Type, []byte, error) {
+
+	if len(data) < 3 || data[0] != encryptedVersion {
+		return 0, nil, fmt.Errorf("field %s: unsupported encrypted value", field)
+	}
+
+	idLen := int(data[2])
+	if len(data) < 3+idLen+nonceSize {
+		return 0, nil, fmt.Errorf("field %s: truncated encrypted value", field)
+	}
+
+	id := string(data[3 : 3+idLen])
+	nonce := data[3+idLen : 3+idLen+nonceSize]
+
+	key, err := keys.key(id)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	plaintext, err := key.aead.Open(nil, nonce, data[3+idLen+nonceSize:], []byte(field))
+	if err != nil {
+		return 0, nil, fmt.Errorf("field %s: %w", field, err)
+	}
+	if len(plaintext) == 0 {
+		return 0, nil, fmt.Errorf("field %s: empty encrypted value", field)
+	}
+
+	return bsontype.Type(plaintext[0]), plaintext[1:], nil
+}
+
+// derivedKey holds the cipher and the deterministic nonce key derived from the material of a key.
+type derivedKey struct {
+	material []byte
+	aead     cipher.AEAD
+	sivKey   []byte
+}
+
+// keyCache derives the keys of a KeyProvider once per key id. The provider is still asked for the key material on
+// every use, so that rotations and revocations apply immediately.
+type keyCache struct {
+	provider KeyProvider
+
+	mu   sync.RWMutex
+	keys map[string]*derivedKey
+}
+
+func newKeyCache(p KeyProvider) *keyCache {
+
+	return &keyCache{provider: p, keys: make(map[string]*derivedKey)}
+}
+
+// current returns the id and the derived current key of the provider.
+func (c *keyCache) current() (string, *derivedKey, error) {
+
+	id, material, err := c.provider.CurrentKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	key, err := c.derive(id, material)
+	return id, key, err
+}
+
+// key returns the derived key with the given id.
+func (c *keyCache) key(id string) (*derivedKey, error) {
+
+	material, err := c.provider.Key(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.derive(id, material)
+}
+
+func (c *keyCache) derive(id string, material []byte) (*derivedKey, error) {
+
+	c.mu.RLock()
+	key, ok := c.keys[id]
+	c.mu.RUnlock()
+
+	// A provider could reuse an id for new material: the cached key only applies to the material it derives from.
+	if ok && hmac.Equal(key.material, material) {
+		return key, nil
+	}
+
+	key, err := newDerivedKey(material)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.keys[id] = key
+	c.mu.Unlock()
+
+	return key, nil
+}
+
+// newDerivedKey derives from the key material an AES-256-GCM cipher and the key used to compute deterministic
+// nonces.
+func newDerivedKey(material []byte) (*derivedKey, error) {
+
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, material)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+
+	block, err := aes.NewCipher(derive("friendlymongo/encryption"))
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &derivedKey{
+		material: append([]byte(nil), material...),
+		aead:     aead,
+		sivKey:   derive("friendlymongo/deterministic"),
+	}, nil
+}
+
+// encryptedStructCodec encodes and decodes a struct with the default struct codec, encrypting and decrypting the
+// fields tagged with `fm:"encrypt"`.
+type encryptedStructCodec struct {
+	keys   *keyCache
+	fields map[string]byte // bson key -> mode
+
+	// plain encodes and decodes the struct itself, which cannot be looked up in the repository registry without
+	// finding the encrypting codec. It caches the encoders of the fields, so it is shared only within a registry.
+	plain *bsoncodec.StructCodec
+}
+
+func (c *encryptedStructCodec) EncodeValue(ec bsoncodec.EncodeContext, vw bsonrw.ValueWriter, val reflect.Value) error {
+
+	var buf sliceWriter
+	dvw, err := bsonrw.NewBSONValueWriter(&buf)
+	if err != nil {
+		return err
+	}
+
+	if err := c.plain.EncodeValue(ec, dvw, val); err != nil {
+		return err
+	}
+
+	doc, err := c.transform(buf, func(key string, v bsoncore.Value) (bsoncore.Value, error) {
+		if v.Type == bsontype.Null {
+			return v, nil
+		}
+
+		data, err := encrypt(c.keys, c.fields[key], key, v.Type, v.Data)
+		if err != nil {
+			return v, err
+		}
+
+		return bsoncore.Value{
+			Type: bsontype.Binary,
+			Data: bsoncore.AppendBinary(nil, encryptedSubtype, data),
+		}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return bsonrw.Copier{}.CopyDocumentFromBytes(vw, doc)
+}
+
+func (c *encryptedStructCodec) DecodeValue(dc bsoncodec.DecodeContext, vr bsonrw.ValueReader, val reflect.Value) error {
+
+	if vr.Type() != bsontype.EmbeddedDocument && vr.Type() != bsontype.Type(0) {
+		return c.plain.DecodeValue(dc, vr, val)
+	}
+
+	raw, err := bsonrw.Copier{}.CopyDocumentToBytes(vr)
+	if err != nil {
+		return err
+	}
+
+	doc, err := c.transform(raw, func(key string, v bsoncore.Value) (bsoncore.Value, error) {
+		subtype, data, ok := v.BinaryOK()
+		if !ok || subtype != encryptedSubtype {
+			// Plain values are left untouched, allowing to read documents written before encryption was enabled.
+			return v, nil
+		}
+
+		t, plain, err := decrypt(c.keys, key, data)
+		if err != nil {
+			return v, err
+		}
+
+		return bsoncore.Value{Type: t, Data: plain}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.plain.DecodeValue(dc, bsonrw.NewBSONDocumentReader(doc), val)
+}
+
+// transform rebuilds doc applying fn to the values of the encrypted fields.
+func (c *encryptedStructCodec) transform(
+	doc []byte,
+	fn func(key string, v bsoncore.Value) (bsoncore.Value, error),
+) ([]byte, error) {
+
+	elems, err := bsoncore.Document(doc).Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	idx, out := bsoncore.AppendDocumentStart(nil)
+	for _, e := range elems {
+		key := e.Key()
+		if _, ok := c.fields[key]; !ok {
+			out = append(out, e...)
+			continue
+		}
+
+		v, err := fn(key, e.Value())
+		if err != nil {
+			return nil, err
+		}
+		out = bsoncore.AppendValueElement(out, key, v)
+	}
+
+	return bsoncore.AppendDocumentEnd(out, idx)
+}
+
+// sliceWriter is an io.Writer appending to a byte slice.
+type sliceWriter []byte
+
+func (sw *sliceWriter) Write(p []byte) (int, error) {
+
+	*sw = append(*sw, p...)
+	return len(p), nil
+}
+
+// registerEncryptionCodecs registers on reg an encrypting codec for every struct reachable from types that has
+// fields tagged with `fm:"encrypt"`. It returns the encryption of the values written to those fields by update
+// operators.
+func registerEncryptionCodecs(reg *bsoncodec.Registry, p KeyProvider, types ...reflect.Type) (*fieldEncryption, error) {
+
+	if _, _, err := p.CurrentKey(); err != nil {
+		return nil, fmt.Errorf("could not get the current key: %w", err)
+	}
+
+	plain, err := bsoncodec.NewStructCodec(bsoncodec.DefaultStructTagParser)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := newKeyCache(p)
+	seen := map[reflect.Type]bool{}
+
+	var visit func(t reflect.Type) error
+	visit = func(t reflect.Type) error {
+		t = elemType(t)
+		if t.Kind() != reflect.Struct || seen[t] {
+			return nil
+		}
+		seen[t] = true
+
+		fields, err := encryptedFields(t)
+		if err != nil {
+			return err
+		}
+
+		if len(fields) > 0 {
+			codec := &encryptedStructCodec{keys: keys, fields: fields, plain: plain}
+			reg.RegisterTypeEncoder(t, codec)
+			reg.RegisterTypeDecoder(t, codec)
+		}
+
+		for i := 0; i < t.NumField(); i++ {
+			if err := visit(t.Field(i).Type); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	enc := &fieldEncryption{keys: keys, paths: map[string]byte{}}
+	for _, t := range types {
+		if err := visit(t); err != nil {
+			return nil, err
+		}
+		encryptedPaths(elemType(t), "", enc.paths, map[reflect.Type]bool{})
+	}
+
+	return enc, nil
+}
+
+// failingCodec fails to encode and decode values with err.
+type failingCodec struct {
+	err error
+}
+
+func (c failingCodec) EncodeValue(bsoncodec.EncodeContext, bsonrw.ValueWriter, reflect.Value) error {
+
+	return c.err
+}
+
+func (c failingCodec) DecodeValue(bsoncodec.DecodeContext, bsonrw.ValueReader, reflect.Value) error {
+
+	return c.err
+}
+
+// registerFailingCodec registers on reg a codec failing with err for types and pointers to them.
+func registerFailingCodec(reg *bsoncodec.Registry, err error, types ...reflect.Type) {
+
+	codec := failingCodec{err: err}
+	for _, t := range types {
+		t = elemType(t)
+		for _, ft := range []reflect.Type{t, reflect.PointerTo(t)} {
+			reg.RegisterTypeEncoder(ft, codec)
+			reg.RegisterTypeDecoder(ft, codec)
+		}
+	}
+}
+
+// elemType returns the type of the values held by t, through pointers and containers.
+func elemType(t reflect.Type) reflect.Type {
+
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array ||
+		t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// encryptedFields returns the bson keys of the fields of t, including the ones of inlined structs, tagged with
+// `fm:"encrypt"` along with their encryption mode.
+func encryptedFields(t reflect.Type) (map[string]byte, error) {
+
+	fields := map[string]byte{}
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		key, inline := bsonKey(sf)
+		if key == "-" {
+			continue
+		}
+
+		if inline {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				inlined, err := encryptedFields(ft)
+				if err != nil {
+					return nil, err
+				}
+				for k, mode := range inlined {
+					fields[k] = mode
+				}
+			}
+			continue
+		}
+
+		if !sf.IsExported() {
+			continue
+		}
+
+		opts := strings.Split(sf.Tag.Get("fm"), ",")
+		if opts[0] != "encrypt" {
+			continue
+		}
+
+		mode := modeRandom
+		for _, opt := range opts[1:] {
+			switch opt {
+			case "deterministic":
+				mode = modeDeterministic
+			case "random":
+			default:
+				return nil, fmt.Errorf("%s.%s: unknown fm tag option %q", t, sf.Name, opt)
+			}
+		}
+		fields[key] = mode
+	}
+
+	return fields, nil
+}
+
+// encryptedPaths adds to paths the dotted paths of the encrypted fields of the struct t, along with their encryption
+// mode. Arrays do not add a segment to the paths, and the keys of maps are matched by a "*" segment.
+func encryptedPaths(t reflect.Type, prefix string, paths map[string]byte, visiting map[reflect.Type]bool) {
+
+	if t.Kind() != reflect.Struct || visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	// The fields cannot fail here: registerEncryptionCodecs has already checked them.
+	fields, _ := encryptedFields(t)
+	for key, mode := range fields {
+		paths[prefix+key] = mode
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		key, inline := bsonKey(sf)
+		if key == "-" || (!inline && !sf.IsExported()) {
+			continue
+		}
+
+		ft := sf.Type
+		if !inline {
+			if _, ok := fields[key]; ok {
+				continue
+			}
+			ft = elemType(ft)
+		} else if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+
+		switch {
+		case inline:
+			encryptedPaths(ft, prefix, paths, visiting)
+		case containsMap(sf.Type):
+			encryptedPaths(ft, prefix+key+".*.", paths, visiting)
+		default:
+			encryptedPaths(ft, prefix+key+".", paths, visiting)
+		}
+	}
+}
+
+func containsMap(t reflect.Type) bool {
+
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+
+	return t.Kind() == reflect.Map
+}
+
+// fieldEncryption encrypts the values that update operators write to the encrypted fields of the models of a
+// repository, which do not go through the codecs of the models.
+type fieldEncryption struct {
+	keys  *keyCache
+	paths map[string]byte // dotted path -> mode, see encryptedPaths
+
+	// err is the error of an invalid encryption configuration, returned by every update.
+	err error
+}
+
+// sealUpdate returns update with the values it writes to encrypted fields encrypted. Operators which cannot write an
+// encrypted value, such as $inc on an encrypted field, are rejected. It returns update unchanged when e is nil.
+func (e *fieldEncryption) sealUpdate(registry *bsoncodec.Registry, update interface{}) (interface{}, error) {
+
+	if e == nil {
+		return update, nil
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	raw, err := bson.MarshalWithRegistry(registry, update)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	for i, op := range doc {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			continue
+		}
+
+		sealed := make(bson.D, len(fields))
+		for j, f := range fields {
+			if sealed[j], err = e.sealOperand(op.Key, f); err != nil {
+				return nil, err
+			}
+		}
+		doc[i].Value = sealed
+	}
+
+	return doc, nil
+}
+
+// sealOperand encrypts the values written by the operator op to the path f.Key.
+func (e *fieldEncryption) sealOperand(op string, f bson.E) (bson.E, error) {
+
+	if op == "$unset" {
+		return f, nil
+	}
+
+	for path, mode := range e.paths {
+		encrypted := strings.Split(path, ".")
+		field := encrypted[len(encrypted)-1]
+
+		rel, rest := relatePath(strings.Split(f.Key, "."), encrypted)
+		if to, ok := f.Value.(string); op == "$rename" && ok && rel == pathUnrelated {
+			rel, _ = relatePath(strings.Split(to, "."), encrypted)
+		}
+
+		var err error
+		switch {
+		case rel == pathUnrelated:
+			continue
+		case (op == "$set" || op == "$setOnInsert") && rel != pathBelow:
+			f.Value, err = e.sealAt(f.Value, rest, mode, field)
+		case (op == "$push" || op == "$addToSet") && rel == pathAbove:
+			f.Value, err = e.sealElements(f.Value, rest, mode, field)
+		default:
+			return f, fmt.Errorf("%w: %s cannot be applied to the encrypted field %s", ErrInvalidUpdate, op, path)
+		}
+		if err != nil {
+			return f, err
+		}
+	}
+
+	return f, nil
+}
+
+// sealElements encrypts the encrypted fields at rest of the element, or of the $each elements, added by $push or
+// $addToSet.
+func (e *fieldEncryption) sealElements(v interface{}, rest []string, mode byte, field string) (interface{}, error) {
+
+	d, ok := v.(bson.D)
+	if !ok || len(d) == 0 || d[0].Key != "$each" {
+		return e.sealAt(v, rest, mode, field)
+	}
+
+	out := append(bson.D{}, d...)
+	sealed, err := e.sealAt(d[0].Value, rest, mode, field)
+	out[0].Value = sealed
+
+	return out, err
+}
+
+// sealAt encrypts the values found at the path rest of v, where arrays are traversed and "*" matches any key.
+func (e *fieldEncryption) sealAt(v interface{}, rest []string, mode byte, field string) (interface{}, error) {
+
+	if len(rest) == 0 {
+		return e.seal(v, mode, field)
+	}
+
+	switch c := v.(type) {
+	case bson.D:
+		out := append(bson.D{}, c...)
+		for i, elem := range c {
+			if rest[0] != "*" && rest[0] != elem.Key {
+				continue
+			}
+
+			sealed, err := e.sealAt(elem.Value, rest[1:], mode, field)
+			if err != nil {
+				return nil, err
+			}
+			out[i].Value = sealed
+		}
+		return out, nil
+	case bson.A:
+		out := make(bson.A, len(c))
+		for i, elem := range c {
+			sealed, err := e.sealAt(elem, rest, mode, field)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = sealed
+		}
+		return out, nil
+	}
+
+	return v, nil
+}
+
+// seal encrypts v as the value of field, unless it is null or already encrypted, e.g. by the codec of its struct or
+// with EncryptValue.
+func (e *fieldEncryption) seal(v interface{}, mode byte, field string) (interface{}, error) {
+
+	if b, ok := v.(primitive.Binary); v == nil || (ok && b.Subtype == encryptedSubtype) {
+		return v, nil
+	}
+
+	t, data, err := bson.MarshalValue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := encrypt(e.keys, mode, field, t, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return primitive.Binary{Subtype: encryptedSubtype, Data: ciphertext}, nil
+}
+
+type pathRelation int
+
+const (
+	pathUnrelated pathRelation = iota
+	// pathExact is the relation of the path of an encrypted field to itself.
+	pathExact
+	// pathAbove is the relation of a path to the encrypted fields of its subdocuments.
+	pathAbove
+	// pathBelow is the relation of a path to an encrypted field it goes through.
+	pathBelow
+)
+
+// relatePath returns the relation of the update path p to the encrypted path e, along with the segments of e below p
+// when p is above e. Array indexes and positional operators of p can match an array, which has no segment in e.
+func relatePath(p, e []string) (pathRelation, []string) {
+
+	switch {
+	case len(p) == 0 && len(e) == 0:
+		return pathExact, nil
+	case len(p) == 0:
+		return pathAbove, e
+	case len(e) == 0:
+		return pathBelow, nil
+	}
+
+	if isArrayIndex(p[0]) {
+		if rel, rest := relatePath(p[1:], e); rel != pathUnrelated {
+			return rel, rest
+		}
+	}
+
+	if e[0] == "*" || e[0] == p[0] {
+		return relatePath(p[1:], e[1:])
+	}
+
+	return pathUnrelated, nil
+}
+
+// isArrayIndex reports whether the path segment s is an array index or a positional operator.
+func isArrayIndex(s string) bool {
+
+	if strings.HasPrefix(s, "$") {
+		return true
+	}
+
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// bsonKey returns the key of a struct field following the rules of the default struct tag parser.
+func bsonKey(sf reflect.StructField) (string, bool) {
+
+	tag, ok := sf.Tag.Lookup("bson")
+	if !ok && !strings.Contains(string(sf.Tag), ":") && len(sf.Tag) > 0 {
+		tag = string(sf.Tag)
+	}
+	if tag == "-" {
+		return "-", false
+	}
+
+	parts := strings.Split(tag, ",")
+	inline := false
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			inline = true
+		}
+	}
+
+	key := parts[0]
+	if key == "" {
+		key = strings.ToLower(sf.Name)
+	}
+
+	return key, inline
+}
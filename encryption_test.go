@@ -0,0 +1,199 @@
+package friendlymongo_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/pmatteo/friendlymongo/update"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+type secretContact struct {
+	Phone string `bson:"phone" fm:"encrypt"`
+}
+
+type secretUser struct {
+	fm.BaseModel `bson:",inline"`
+
+	Name    string         `bson:"name"`
+	Email   string         `bson:"email" fm:"encrypt,deterministic"`
+	Age     int            `bson:"age" fm:"encrypt"`
+	Contact *secretContact `bson:"contact"`
+}
+
+func newSecretUserRepo(t *testing.T, keys fm.KeyProvider) *fm.BaseRepository[*secretUser] {
+	t.Helper()
+
+	return fm.NewBaseRepository(
+		fm.GetInstance().Database(testDB), "secretUsers", new(secretUser), fm.WithEncryption(keys),
+	)
+}
+
+func TestKeyRing_Rotate(t *testing.T) {
+	t.Parallel()
+
+	_, err := fm.NewKeyRing("short", []byte("too short"))
+	require.Error(t, err)
+
+	keys, err := fm.NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+
+	require.NoError(t, keys.Rotate("k2", bytes.Repeat([]byte{2}, 32)))
+	require.Error(t, keys.Rotate("k2", bytes.Repeat([]byte{3}, 32)))
+
+	id, key, err := keys.CurrentKey()
+	require.NoError(t, err)
+	assert.Equal(t, "k2", id)
+	assert.Equal(t, bytes.Repeat([]byte{2}, 32), key)
+
+	old, err := keys.Key("k1")
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte{1}, 32), old)
+
+	_, err = keys.Key("k3")
+	require.ErrorIs(t, err, fm.ErrUnknownKey)
+}
+
+func TestEncryptValue_Deterministic(t *testing.T) {
+	t.Parallel()
+
+	keys, err := fm.NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+
+	a, err := fm.EncryptValue(keys, "email", "john.doe@test.com")
+	require.NoError(t, err)
+	b, err := fm.EncryptValue(keys, "email", "john.doe@test.com")
+	require.NoError(t, err)
+	c, err := fm.EncryptValue(keys, "other", "john.doe@test.com")
+	require.NoError(t, err)
+
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, c)
+	assert.NotContains(t, string(a.Data), "john.doe")
+}
+
+func TestEncryption_RoundTrip(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	keys, err := fm.NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	secretRepo := newSecretUserRepo(t, keys)
+
+	user := &secretUser{
+		Name:    "encrypted roundtrip",
+		Email:   "roundtrip@test.com",
+		Age:     33,
+		Contact: &secretContact{Phone: "+39 123"},
+	}
+	require.NoError(t, secretRepo.InsertOne(context.Background(), user))
+
+	raw, err := fm.GetInstance().Database(testDB).Collection("secretUsers").
+		FindOne(context.Background(), bson.M{"name": "encrypted roundtrip"}).Raw()
+	require.NoError(t, err)
+	assert.Equal(t, bsontype.Binary, raw.Lookup("email").Type)
+	assert.Equal(t, bsontype.Binary, raw.Lookup("age").Type)
+	assert.Equal(t, bsontype.Binary, raw.Lookup("contact", "phone").Type)
+	assert.Equal(t, bsontype.String, raw.Lookup("name").Type)
+
+	found, err := secretRepo.FindOne(context.Background(), bson.M{"name": "encrypted roundtrip"})
+	require.NoError(t, err)
+	assert.Equal(t, "roundtrip@test.com", found.Email)
+	assert.Equal(t, 33, found.Age)
+	assert.Equal(t, "+39 123", found.Contact.Phone)
+}
+
+func TestEncryption_QueryAndRotation(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	keys, err := fm.NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	secretRepo := newSecretUserRepo(t, keys)
+
+	user := &secretUser{Name: "encrypted query", Email: "query@test.com"}
+	require.NoError(t, secretRepo.InsertOne(context.Background(), user))
+
+	email, err := fm.EncryptValue(keys, "email", "query@test.com")
+	require.NoError(t, err)
+
+	found, err := secretRepo.FindOne(context.Background(), bson.M{"email": email})
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+
+	require.NoError(t, keys.Rotate("k2", bytes.Repeat([]byte{2}, 32)))
+
+	found, err = secretRepo.FindOne(context.Background(), bson.M{"name": "encrypted query"})
+	require.NoError(t, err)
+	assert.Equal(t, "query@test.com", found.Email)
+}
+
+func TestEncryption_OperatorUpdates(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	keys, err := fm.NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	r := fm.NewMemoryRepository(new(secretUser), fm.WithEncryption(keys))
+
+	user := &secretUser{Name: "operators", Email: "old@test.com"}
+	require.NoError(t, r.InsertOne(ctx, user))
+
+	_, err = r.UpdateOne(ctx, bson.M{"name": "operators"}, bson.M{"$set": bson.M{"email": "new@test.com"}})
+	require.NoError(t, err)
+
+	u := update.New().Set("contact", &secretContact{Phone: "+39 123"}).Set("age", 40)
+	_, err = r.UpdateOne(ctx, bson.M{"name": "operators"}, u)
+	require.NoError(t, err)
+
+	email, err := fm.EncryptValue(keys, "email", "new@test.com")
+	require.NoError(t, err)
+
+	found, err := r.FindOne(ctx, bson.M{"email": email})
+	require.NoError(t, err, "the email is stored encrypted")
+	assert.Equal(t, "new@test.com", found.Email)
+	assert.Equal(t, 40, found.Age)
+	assert.Equal(t, "+39 123", found.Contact.Phone)
+
+	n, err := r.Count(ctx, bson.M{"$or": bson.A{bson.M{"age": 40}, bson.M{"contact.phone": "+39 123"}}})
+	require.NoError(t, err)
+	assert.Zero(t, n, "no field is stored in plaintext")
+
+	_, err = r.UpdateMany(ctx, bson.M{}, update.New().Set("contact.phone", "+39 456"))
+	require.NoError(t, err)
+
+	found, err = r.FindByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "+39 456", found.Contact.Phone)
+
+	_, err = r.UpdateOne(ctx, bson.M{"name": "operators"}, bson.M{"$inc": bson.M{"age": 1}})
+	require.ErrorIs(t, err, fm.ErrInvalidUpdate)
+
+	_, err = r.UpdateMany(ctx, bson.M{}, update.New().Rename("name", "email"))
+	require.ErrorIs(t, err, fm.ErrInvalidUpdate)
+}
+
+type badSecret struct {
+	fm.BaseModel `bson:",inline"`
+
+	Secret string `bson:"secret" fm:"encrypt,sometimes"`
+}
+
+func TestEncryption_InvalidConfiguration(t *testing.T) {
+	t.Parallel()
+
+	keys, err := fm.NewKeyRing("k1", bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	r := fm.NewMemoryRepository(new(badSecret), fm.WithEncryption(keys))
+
+	err = r.InsertOne(context.Background(), &badSecret{Secret: "s"})
+	require.ErrorContains(t, err, "could not register encryption codecs")
+
+	_, err = r.UpdateMany(context.Background(), bson.M{}, bson.M{"$set": bson.M{"secret": "s"}})
+	require.ErrorContains(t, err, "could not register encryption codecs")
+}
@@ -0,0 +1,257 @@
+package friendlymongo
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Sentinel errors, matched with errors.Is against the errors returned by the repositories.
+var (
+	// ErrNotFound is matched when no document matches the filter of an operation. Such errors also match
+	// mongo.ErrNoDocuments.
+	ErrNotFound = errors.New("document not found")
+	// ErrDuplicateKey is matched when a write violates a unique index. Use errors.As with a *DuplicateKeyError to get
+	// the index and the offending key values.
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrValidationFailed is matched when a write is rejected by the schema validation of the collection.
+	ErrValidationFailed = errors.New("document validation failed")
+	// ErrTimeout is matched when an operation exceeds its context deadline or its server time limit.
+	ErrTimeout = errors.New("operation timed out")
+	// ErrInvalidUpdate is matched when the update parameter of an operation has an unsupported type.
+	ErrInvalidUpdate = errors.New("invalid update parameter")
+)
+
+// documentValidationFailure is the server error code of writes rejected by the collection validator.
+const documentValidationFailure = 121
+
+// Error is the error returned by the repository operations. It wraps the underlying error, typically returned by the
+// driver, with the operation and collection it comes from.
+type Error struct {
+	// Op is the name of the repository method, e.g. "FindOne".
+	Op string
+	// Collection is the name of the collection, empty for a MemoryRepository.
+	Collection string
+	// Err is the underlying error.
+	Err error
+
+	filter   bson.Raw
+	once     sync.Once
+	redacted string
+}
+
+// Filter returns the filter of the operation, as extended JSON where every value is replaced by "?", so that it can be
+// logged without leaking the queried data. The filter is captured when the error is created and rendered on first
+// use; it is empty if the operation has no filter.
+func (e *Error) Filter() string {
+
+	e.once.Do(func() { e.redacted = redactFilter(e.filter) })
+	return e.redacted
+}
+
+func (e *Error) Error() string {
+
+	msg := e.Op
+	if e.Collection != "" {
+		msg += " on " + e.Collection
+	}
+	if f := e.Filter(); f != "" {
+		msg += " with filter " + f
+	}
+
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+
+	return e.Err
+}
+
+// Is matches the sentinel errors of the package against the underlying error.
+func (e *Error) Is(target error) bool {
+
+	switch target {
+	case ErrNotFound:
+		return errors.Is(e.Err, mongo.ErrNoDocuments)
+	case ErrDuplicateKey:
+		return mongo.IsDuplicateKeyError(e.Err)
+	case ErrValidationFailed:
+		var se mongo.ServerError
+		return errors.As(e.Err, &se) && se.HasErrorCode(documentValidationFailure)
+	case ErrTimeout:
+		return mongo.IsTimeout(e.Err) || errors.Is(e.Err, context.DeadlineExceeded)
+	}
+
+	return false
+}
+
+// DuplicateKeyError details a unique index violation.
+type DuplicateKeyError struct {
+	// Index is the name of the violated index.
+	Index string
+	// Keys holds the offending key values, when reported by the server.
+	Keys bson.D
+	// Err is the underlying driver error.
+	Err error
+}
+
+func (e *DuplicateKeyError) Error() string {
+
+	return e.Err.Error()
+}
+
+func (e *DuplicateKeyError) Unwrap() error {
+
+	return e.Err
+}
+
+// Is makes a *DuplicateKeyError match ErrDuplicateKey.
+func (e *DuplicateKeyError) Is(target error) bool {
+
+	return target == ErrDuplicateKey
+}
+
+// IsRetryable reports whether err is a transient error, such as a network error or a primary step down, after which
+// the operation can be retried.
+func IsRetryable(err error) bool {
+
+	if err == nil {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+
+	var le mongo.LabeledError
+	return errors.As(err, &le) &&
+		(le.HasErrorLabel("RetryableWriteError") || le.HasErrorLabel("TransientTransactionError"))
+}
+
+// wrapError wraps err, if any, in an *Error for the operation op. Duplicate key errors are further detailed in a
+// *DuplicateKeyError.
+func wrapError(op, collection string, registry *bsoncodec.Registry, filter interface{}, err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	if mongo.IsDuplicateKeyError(err) {
+		err = newDuplicateKeyError(err)
+	}
+
+	return &Error{Op: op, Collection: collection, Err: err, filter: marshalFilter(registry, filter)}
+}
+
+// dupKeyIndex extracts the index name from the message of a duplicate key error.
+var dupKeyIndex = regexp.MustCompile(`index: (\S+)`)
+
+func newDuplicateKeyError(err error) *DuplicateKeyError {
+
+	dke := &DuplicateKeyError{Err: err}
+
+	var raws []bson.Raw
+	var we mongo.WriteException
+	var bwe mongo.BulkWriteException
+	var ce mongo.CommandError
+	switch {
+	case errors.As(err, &we):
+		for _, e := range we.WriteErrors {
+			raws = append(raws, e.Raw)
+		}
+	case errors.As(err, &bwe):
+		for _, e := range bwe.WriteErrors {
+			raws = append(raws, e.Raw)
+		}
+	case errors.As(err, &ce):
+		raws = append(raws, ce.Raw)
+	}
+
+	for _, raw := range raws {
+		if kv, ok := raw.Lookup("keyValue").DocumentOK(); ok {
+			_ = bson.Unmarshal(kv, &dke.Keys)
+			break
+		}
+	}
+
+	if m := dupKeyIndex.FindStringSubmatch(err.Error()); m != nil {
+		dke.Index = m[1]
+	}
+
+	return dke
+}
+
+// marshalFilter returns a copy of filter as BSON, which the caller can no longer modify. It returns nil if filter is
+// nil or cannot be marshalled.
+func marshalFilter(registry *bsoncodec.Registry, filter interface{}) bson.Raw {
+
+	if filter == nil {
+		return nil
+	}
+	if registry == nil {
+		registry = bson.DefaultRegistry
+	}
+
+	raw, err := bson.MarshalWithRegistry(registry, filter)
+	if err != nil {
+		return nil
+	}
+
+	return raw
+}
+
+// redactFilter renders filter as extended JSON with every value replaced by "?". It returns an empty string if filter
+// is nil.
+func redactFilter(filter bson.Raw) string {
+
+	if filter == nil {
+		return ""
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(filter, &doc); err != nil {
+		return ""
+	}
+
+	b, err := bson.MarshalExtJSON(redact(doc), false, false)
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+// redact replaces the values of v with "?", keeping the field names and operators.
+func redact(v interface{}) interface{} {
+
+	switch c := v.(type) {
+	case bson.D:
+		d := make(bson.D, len(c))
+		for i, e := range c {
+			d[i] = bson.E{Key: e.Key, Value: redact(e.Value)}
+		}
+		return d
+	case bson.A:
+		// Arrays of documents are the clauses of logical operators, e.g. $or, whose structure is kept.
+		a := make(bson.A, len(c))
+		for i, e := range c {
+			if _, ok := e.(bson.D); ok {
+				a[i] = redact(e)
+			} else {
+				a[i] = "?"
+			}
+		}
+		return a
+	default:
+		return "?"
+	}
+}
@@ -0,0 +1,124 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestError_NotFound(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(customModel))
+
+	_, err := r.FindOne(context.Background(), bson.M{"email": "nobody@test.com", "age": bson.M{"$gt": 18}})
+	assert.ErrorIs(t, err, fm.ErrNotFound)
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+	assert.NotErrorIs(t, err, fm.ErrDuplicateKey)
+
+	var e *fm.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, "FindOne", e.Op)
+	assert.NotContains(t, e.Filter(), "nobody@test.com")
+	assert.Contains(t, e.Filter(), `"email":"?"`)
+	assert.Contains(t, e.Filter(), `"$gt":"?"`)
+}
+
+func TestError_FilterSnapshot(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(customModel))
+
+	filter := bson.M{"email": "nobody@test.com"}
+	_, err := r.FindOne(context.Background(), filter)
+	require.Error(t, err)
+
+	filter["age"] = 18
+	delete(filter, "email")
+
+	var e *fm.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, `{"email":"?"}`, e.Filter(), "the filter is captured when the error is created")
+}
+
+func TestError_DuplicateKey(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(customModel))
+
+	model := newCustomModel("duplicated", "dup@test.com", false, nil)
+	require.NoError(t, r.InsertOne(context.Background(), model))
+
+	err := r.InsertOne(context.Background(), model)
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+
+	var dke *fm.DuplicateKeyError
+	require.ErrorAs(t, err, &dke)
+	assert.Equal(t, "_id_", dke.Index)
+	assert.Equal(t, bson.D{{Key: "_id", Value: model.ID}}, dke.Keys)
+}
+
+func TestError_InvalidUpdate(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(customModel))
+
+	_, err := r.UpdateOne(context.Background(), bson.M{}, bson.D{{Key: "$set", Value: bson.M{"name": "x"}}})
+	assert.ErrorIs(t, err, fm.ErrInvalidUpdate)
+
+	_, err = r.UpdateMany(context.Background(), bson.M{}, new(customModel))
+	assert.ErrorIs(t, err, fm.ErrInvalidUpdate)
+}
+
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, fm.IsRetryable(nil))
+	assert.False(t, fm.IsRetryable(errors.New("boom")))
+	assert.False(t, fm.IsRetryable(mongo.CommandError{Code: 11000}))
+	assert.True(t, fm.IsRetryable(mongo.CommandError{Code: 189, Labels: []string{"RetryableWriteError"}}))
+	assert.True(t, fm.IsRetryable(&fm.Error{Op: "Find", Err: mongo.CommandError{
+		Code: 112, Labels: []string{"TransientTransactionError"},
+	}}))
+}
+
+func TestBaseRepository_Errors(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	ctx := context.Background()
+	db := fm.GetInstance().Database(testDB)
+	require.NoError(t, db.Collection("errors").Drop(ctx))
+
+	_, err := db.Collection("errors").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().SetName("email_unique").SetUnique(true),
+	})
+	require.NoError(t, err)
+
+	r := fm.NewBaseRepository(db, "errors", new(customModel))
+	require.NoError(t, r.InsertOne(ctx, newCustomModel("first", "unique@test.com", false, nil)))
+
+	err = r.InsertOne(ctx, newCustomModel("second", "unique@test.com", false, nil))
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+
+	var dke *fm.DuplicateKeyError
+	require.ErrorAs(t, err, &dke)
+	assert.Equal(t, "email_unique", dke.Index)
+	assert.Equal(t, bson.D{{Key: "email", Value: "unique@test.com"}}, dke.Keys)
+
+	_, err = r.FindOne(ctx, bson.M{"email": "missing@test.com"})
+	assert.ErrorIs(t, err, fm.ErrNotFound)
+
+	var e *fm.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, "errors", e.Collection)
+	assert.Equal(t, `{"email":"?"}`, e.Filter())
+}
@@ -0,0 +1,242 @@
+// Package filter builds MongoDB query filters as bson.D, so that operators are checked by the compiler instead of
+// being spelled by hand.
+//
+// Filters can be passed wherever friendlymongo takes a filter, and to StageBuilder.Match:
+//
+//	adults := filter.And(filter.Gte("age", 18), filter.In("status", "active", "pending"))
+//	users, err := repo.Find(ctx, adults)
+package filter
+
+import (
+	"fmt"
+	"regexp"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Eq matches the documents whose field equals v. Unlike a plain {field: v} filter, v is never interpreted as an
+// operator document.
+func Eq(field string, v interface{}) bson.D {
+
+	return compare(field, "$eq", v)
+}
+
+// Ne matches the documents whose field does not equal v, including the ones without the field.
+func Ne(field string, v interface{}) bson.D {
+
+	return compare(field, "$ne", v)
+}
+
+// Gt matches the documents whose field is greater than v.
+func Gt(field string, v interface{}) bson.D {
+
+	return compare(field, "$gt", v)
+}
+
+// Gte matches the documents whose field is greater than or equal to v.
+func Gte(field string, v interface{}) bson.D {
+
+	return compare(field, "$gte", v)
+}
+
+// Lt matches the documents whose field is less than v.
+func Lt(field string, v interface{}) bson.D {
+
+	return compare(field, "$lt", v)
+}
+
+// Lte matches the documents whose field is less than or equal to v.
+func Lte(field string, v interface{}) bson.D {
+
+	return compare(field, "$lte", v)
+}
+
+// In matches the documents whose field equals any of values. Pass a slice as In("status", statuses...).
+func In[V any](field string, values ...V) bson.D {
+
+	return compare(field, "$in", array(values))
+}
+
+// Nin matches the documents whose field equals none of values, including the ones without the field.
+func Nin[V any](field string, values ...V) bson.D {
+
+	return compare(field, "$nin", array(values))
+}
+
+// All matches the documents whose array field contains all of values.
+func All[V any](field string, values ...V) bson.D {
+
+	return compare(field, "$all", array(values))
+}
+
+// Size matches the documents whose array field has n elements.
+func Size(field string, n int) bson.D {
+
+	return compare(field, "$size", n)
+}
+
+// Exists matches the documents that have field when exists is true, and the ones without it otherwise.
+func Exists(field string, exists bool) bson.D {
+
+	return compare(field, "$exists", exists)
+}
+
+// Regex matches the documents whose field matches the regular expression pattern, with the given options, such as
+// "i" for a case-insensitive match. The pattern is used as is: build it with Escape when it includes user input, or
+// use HasPrefix, HasSuffix or Contains.
+func Regex(field, pattern, options string) bson.D {
+
+	return bson.D{{Key: field, Value: primitive.Regex{Pattern: pattern, Options: options}}}
+}
+
+// HasPrefix matches the documents whose field starts with the literal s.
+func HasPrefix(field, s, options string) bson.D {
+
+	return Regex(field, "^"+Escape(s), options)
+}
+
+// HasSuffix matches the documents whose field ends with the literal s.
+func HasSuffix(field, s, options string) bson.D {
+
+	return Regex(field, Escape(s)+"$", options)
+}
+
+// Contains matches the documents whose field contains the literal s.
+func Contains(field, s, options string) bson.D {
+
+	return Regex(field, Escape(s), options)
+}
+
+// Escape quotes the regular expression metacharacters of s, so that the result matches s literally.
+func Escape(s string) string {
+
+	return regexp.QuoteMeta(s)
+}
+
+// ElemMatch matches the documents whose array field has an element matching all of filters. The filters apply to the
+// fields of the elements:
+//
+//	filter.ElemMatch("items", filter.Eq("sku", "A1"), filter.Gte("quantity", 2))
+func ElemMatch(field string, filters ...bson.D) bson.D {
+
+	return compare(field, "$elemMatch", merge(filters))
+}
+
+// And matches the documents matching all of filters, or every document when there are none.
+func And(filters ...bson.D) bson.D {
+
+	if len(filters) == 0 {
+		return bson.D{}
+	}
+
+	return logical("$and", filters)
+}
+
+// Or matches the documents matching any of filters, or no document when there are none.
+func Or(filters ...bson.D) bson.D {
+
+	if len(filters) == 0 {
+		// The server rejects an empty $or: no _id is in an empty list.
+		return compare("_id", "$in", bson.A{})
+	}
+
+	return logical("$or", filters)
+}
+
+// Nor matches the documents matching none of filters, or every document when there are none.
+func Nor(filters ...bson.D) bson.D {
+
+	if len(filters) == 0 {
+		return bson.D{}
+	}
+
+	return logical("$nor", filters)
+}
+
+// Not matches the documents that do not match f. A condition on a single field, such as Not(Gt("age", 18)), is
+// negated with $not; any other filter with $nor.
+func Not(f bson.D) bson.D {
+
+	if len(f) == 1 && f[0].Key != "" && f[0].Key[0] != '$' {
+		switch cond := f[0].Value.(type) {
+		case bson.D:
+			if len(cond) > 0 && cond[0].Key != "" && cond[0].Key[0] == '$' {
+				return compare(f[0].Key, "$not", cond)
+			}
+		case primitive.Regex:
+			return compare(f[0].Key, "$not", cond)
+		}
+	}
+
+	return Nor(f)
+}
+
+// Expr matches the documents for which the aggregation expression expr is true:
+//
+//	filter.Expr(bson.D{{Key: "$gt", Value: bson.A{"$spent", "$budget"}}})
+func Expr(expr interface{}) bson.D {
+
+	return bson.D{{Key: "$expr", Value: expr}}
+}
+
+// Text matches the documents whose text index matches search. The collection needs a text index.
+func Text(search string) bson.D {
+
+	return bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: search}}}}
+}
+
+// JSON renders f as indented relaxed extended JSON, for debugging. It falls back to the Go representation of f when
+// it cannot be marshaled.
+func JSON(f bson.D) string {
+
+	b, err := bson.MarshalExtJSONIndent(f, false, false, "", "  ")
+	if err != nil {
+		return fmt.Sprint(f)
+	}
+
+	return string(b)
+}
+
+func compare(field, op string, v interface{}) bson.D {
+
+	return bson.D{{Key: field, Value: bson.D{{Key: op, Value: v}}}}
+}
+
+func logical(op string, filters []bson.D) bson.D {
+
+	clauses := make(bson.A, len(filters))
+	for i, f := range filters {
+		clauses[i] = f
+	}
+
+	return bson.D{{Key: op, Value: clauses}}
+}
+
+// merge returns a filter matching all of filters, without an $and when they are on distinct fields.
+func merge(filters []bson.D) bson.D {
+
+	seen := make(map[string]bool)
+	merged := bson.D{}
+	for _, f := range filters {
+		for _, e := range f {
+			if seen[e.Key] {
+				return And(filters...)
+			}
+			seen[e.Key] = true
+			merged = append(merged, e)
+		}
+	}
+
+	return merged
+}
+
+func array[V any](values []V) bson.A {
+
+	a := make(bson.A, len(values))
+	for i, v := range values {
+		a[i] = v
+	}
+
+	return a
+}
@@ -0,0 +1,235 @@
+package filter_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/pmatteo/friendlymongo/filter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type item struct {
+	SKU      string `bson:"sku"`
+	Quantity int    `bson:"quantity"`
+}
+
+type order struct {
+	fm.BaseModel `bson:",inline"`
+
+	Customer string   `bson:"customer"`
+	Total    float64  `bson:"total"`
+	Tags     []string `bson:"tags,omitempty"`
+	Items    []item   `bson:"items"`
+}
+
+func newOrderRepo(t *testing.T) *fm.MemoryRepository[*order] {
+	r := fm.NewMemoryRepository(new(order))
+
+	_, err := r.InsertMany(context.Background(), []*order{
+		{Customer: "ada", Total: 120, Tags: []string{"gift", "express"}, Items: []item{{"A1", 3}, {"B2", 1}}},
+		{Customer: "alan", Total: 35.5, Tags: []string{"express"}, Items: []item{{"A1", 1}}},
+		{Customer: "grace", Total: 80, Items: []item{{"C3", 2}}},
+		{Customer: "a.b", Total: 10, Tags: []string{"gift"}},
+	})
+	require.NoError(t, err)
+
+	return r
+}
+
+func customersOf(orders []*order) []string {
+	var customers []string
+	for _, o := range orders {
+		customers = append(customers, o.Customer)
+	}
+	return customers
+}
+
+func TestBuilders(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		got  bson.D
+		want bson.D
+	}{
+		{"Eq", filter.Eq("a", 1), bson.D{{Key: "a", Value: bson.D{{Key: "$eq", Value: 1}}}}},
+		{"Ne", filter.Ne("a", 1), bson.D{{Key: "a", Value: bson.D{{Key: "$ne", Value: 1}}}}},
+		{"Gt", filter.Gt("a", 1), bson.D{{Key: "a", Value: bson.D{{Key: "$gt", Value: 1}}}}},
+		{"Lte", filter.Lte("a", 1), bson.D{{Key: "a", Value: bson.D{{Key: "$lte", Value: 1}}}}},
+		{"In", filter.In("a", "x", "y"), bson.D{{Key: "a", Value: bson.D{{Key: "$in", Value: bson.A{"x", "y"}}}}}},
+		{"Nin", filter.Nin("a", 1, 2), bson.D{{Key: "a", Value: bson.D{{Key: "$nin", Value: bson.A{1, 2}}}}}},
+		{"All", filter.All("a", "x"), bson.D{{Key: "a", Value: bson.D{{Key: "$all", Value: bson.A{"x"}}}}}},
+		{"Size", filter.Size("a", 2), bson.D{{Key: "a", Value: bson.D{{Key: "$size", Value: 2}}}}},
+		{"Exists", filter.Exists("a", false), bson.D{{Key: "a", Value: bson.D{{Key: "$exists", Value: false}}}}},
+		{
+			"Regex",
+			filter.Regex("a", "^x", "i"),
+			bson.D{{Key: "a", Value: primitive.Regex{Pattern: "^x", Options: "i"}}},
+		},
+		{
+			"HasPrefix",
+			filter.HasPrefix("a", "1+1 (", ""),
+			bson.D{{Key: "a", Value: primitive.Regex{Pattern: `^1\+1 \(`}}},
+		},
+		{
+			"And",
+			filter.And(filter.Eq("a", 1), filter.Eq("b", 2)),
+			bson.D{{Key: "$and", Value: bson.A{filter.Eq("a", 1), filter.Eq("b", 2)}}},
+		},
+		{"And empty", filter.And(), bson.D{}},
+		{
+			"Or",
+			filter.Or(filter.Eq("a", 1), filter.Eq("b", 2)),
+			bson.D{{Key: "$or", Value: bson.A{filter.Eq("a", 1), filter.Eq("b", 2)}}},
+		},
+		{"Or empty", filter.Or(), bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: bson.A{}}}}}},
+		{
+			"Nor",
+			filter.Nor(filter.Eq("a", 1), filter.Eq("b", 2)),
+			bson.D{{Key: "$nor", Value: bson.A{filter.Eq("a", 1), filter.Eq("b", 2)}}},
+		},
+		{"Nor empty", filter.Nor(), bson.D{}},
+		{
+			"ElemMatch distinct fields",
+			filter.ElemMatch("items", filter.Eq("sku", "A1"), filter.Gt("quantity", 1)),
+			bson.D{{Key: "items", Value: bson.D{{Key: "$elemMatch", Value: bson.D{
+				{Key: "sku", Value: bson.D{{Key: "$eq", Value: "A1"}}},
+				{Key: "quantity", Value: bson.D{{Key: "$gt", Value: 1}}},
+			}}}}},
+		},
+		{
+			"ElemMatch same field",
+			filter.ElemMatch("items", filter.Gt("quantity", 1), filter.Lt("quantity", 5)),
+			bson.D{{Key: "items", Value: bson.D{{Key: "$elemMatch", Value: filter.And(
+				filter.Gt("quantity", 1),
+				filter.Lt("quantity", 5),
+			)}}}},
+		},
+		{
+			"Not field",
+			filter.Not(filter.Gt("a", 1)),
+			bson.D{{Key: "a", Value: bson.D{{Key: "$not", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+		},
+		{
+			"Not regex",
+			filter.Not(filter.Regex("a", "x", "")),
+			bson.D{{Key: "a", Value: bson.D{{Key: "$not", Value: primitive.Regex{Pattern: "x"}}}}},
+		},
+		{
+			"Not logical",
+			filter.Not(filter.Or(filter.Eq("a", 1))),
+			bson.D{{Key: "$nor", Value: bson.A{filter.Or(filter.Eq("a", 1))}}},
+		},
+		{
+			"Not value",
+			filter.Not(bson.D{{Key: "a", Value: 1}}),
+			bson.D{{Key: "$nor", Value: bson.A{bson.D{{Key: "a", Value: 1}}}}},
+		},
+		{"Expr", filter.Expr(bson.D{{Key: "$gt", Value: bson.A{"$a", "$b"}}}), bson.D{{Key: "$expr", Value: bson.D{
+			{Key: "$gt", Value: bson.A{"$a", "$b"}},
+		}}}},
+		{"Text", filter.Text("coffee"), bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: "coffee"}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.got)
+		})
+	}
+}
+
+func TestJSON(t *testing.T) {
+	t.Parallel()
+
+	f := filter.And(filter.Gte("total", 10.5), filter.In("tags", "gift"))
+
+	assert.Equal(t, `{
+  "$and": [
+    {
+      "total": {
+        "$gte": 10.5
+      }
+    },
+    {
+      "tags": {
+        "$in": [
+          "gift"
+        ]
+      }
+    }
+  ]
+}`, filter.JSON(f))
+
+	invalid := filter.Eq("a", make(chan int))
+	assert.Equal(t, fmt.Sprint(invalid), filter.JSON(invalid))
+}
+
+func TestEscape(t *testing.T) {
+	t.Parallel()
+
+	r := newOrderRepo(t)
+
+	found, err := r.Find(context.Background(), filter.HasPrefix("customer", "a.", ""))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.b"}, customersOf(found))
+
+	found, err = r.Find(context.Background(), filter.Regex("customer", "^a.", ""))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ada", "alan", "a.b"}, customersOf(found))
+}
+
+func TestRepository(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		filter bson.D
+		want   []string
+	}{
+		{"Eq", filter.Eq("customer", "ada"), []string{"ada"}},
+		{"Gt", filter.Gt("total", 50), []string{"ada", "grace"}},
+		{"In", filter.In("customer", []string{"ada", "grace"}...), []string{"ada", "grace"}},
+		{"Nin", filter.Nin("tags", "gift"), []string{"alan", "grace"}},
+		{"All", filter.All("tags", "gift", "express"), []string{"ada"}},
+		{"Size", filter.Size("tags", 1), []string{"alan", "a.b"}},
+		{"Exists", filter.Exists("tags", false), []string{"grace"}},
+		{"Contains", filter.Contains("customer", "RA", "i"), []string{"grace"}},
+		{"ElemMatch", filter.ElemMatch("items", filter.Eq("sku", "A1"), filter.Gte("quantity", 2)), []string{"ada"}},
+		{"Or", filter.Or(filter.Eq("customer", "alan"), filter.Lt("total", 20)), []string{"alan", "a.b"}},
+		{"Nor", filter.Nor(filter.Exists("tags", true), filter.Eq("customer", "ada")), []string{"grace"}},
+		{"Or empty", filter.Or(), nil},
+		{"Nor empty", filter.Nor(), []string{"ada", "alan", "grace", "a.b"}},
+		{"Not", filter.Not(filter.Gte("total", 80)), []string{"alan", "a.b"}},
+		{
+			"And",
+			filter.And(filter.In("tags", "express"), filter.Not(filter.HasPrefix("customer", "ad", ""))),
+			[]string{"alan"},
+		},
+	}
+
+	r := newOrderRepo(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := r.Find(context.Background(), tt.filter)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, customersOf(found))
+		})
+	}
+}
+
+func TestStageBuilderMatch(t *testing.T) {
+	t.Parallel()
+
+	r := newOrderRepo(t)
+
+	pipeline := fm.NewStageBuilder().Match("express", filter.In("tags", "express"))
+
+	found, err := fm.Aggregate[*order](context.Background(), r, pipeline)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ada", "alan"}, customersOf(found))
+}
@@ -0,0 +1,202 @@
+package friendlymongo
+
+import (
+	"reflect"
+	"sync"
+)
+
+// hook is one of the lifecycle methods of the Model interface.
+type hook func(Model)
+
+var (
+	onCreate  hook = Model.OnCreate
+	onUpdate  hook = Model.OnUpdate
+	onReplace hook = Model.OnReplace
+)
+
+var modelType = reflect.TypeOf((*Model)(nil)).Elem()
+
+// runHooks invokes h on every Model embedded in document, walking nested structs, pointers, slices, arrays, maps and
+// interfaces depth-first, and finally on document itself.
+//
+// Embedded (anonymous) fields whose hooks are promoted to the enclosing struct are not invoked on their own, since
+// the enclosing struct's hook already covers them.
+func runHooks(document Model, h hook) {
+
+	v := reflect.ValueOf(document)
+	if v.Kind() == reflect.Pointer && !v.IsNil() {
+		walkHooks(v.Elem(), h, true, map[uintptr]struct{}{})
+	}
+
+	h(document)
+}
+
+func walkHooks(v reflect.Value, h hook, skipSelf bool, visited map[uintptr]struct{}) {
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return
+		}
+		if _, ok := visited[v.Pointer()]; ok {
+			return
+		}
+		visited[v.Pointer()] = struct{}{}
+
+		walkHooks(v.Elem(), h, skipSelf, visited)
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+
+		elem := v.Elem()
+		if elem.Kind() == reflect.Pointer || !v.CanSet() {
+			walkHooks(elem, h, skipSelf, visited)
+			return
+		}
+
+		// Values stored in interfaces are not addressable: walk a copy and store it back.
+		cp := reflect.New(elem.Type()).Elem()
+		cp.Set(elem)
+		walkHooks(cp, h, skipSelf, visited)
+		v.Set(cp)
+
+	case reflect.Struct:
+		plan := planFor(v.Type())
+
+		for _, f := range plan.fields {
+			walkHooks(v.Field(f.index), h, f.promoted, visited)
+		}
+
+		if !skipSelf && plan.isModel && v.CanAddr() {
+			h(v.Addr().Interface().(Model))
+		}
+
+	case reflect.Slice, reflect.Array:
+		if !mayHoldModel(v.Type().Elem()) {
+			return
+		}
+
+		for i := 0; i < v.Len(); i++ {
+			walkHooks(v.Index(i), h, false, visited)
+		}
+
+	case reflect.Map:
+		elemType := v.Type().Elem()
+		if !mayHoldModel(elemType) {
+			return
+		}
+
+		iter := v.MapRange()
+		for iter.Next() {
+			elem := iter.Value()
+			if elemType.Kind() == reflect.Pointer {
+				walkHooks(elem, h, false, visited)
+				continue
+			}
+
+			// Map values are not addressable: walk a copy and store it back.
+			cp := reflect.New(elemType).Elem()
+			cp.Set(elem)
+			walkHooks(cp, h, false, visited)
+			v.SetMapIndex(iter.Key(), cp)
+		}
+	}
+}
+
+// hookField is a struct field that may hold a Model.
+type hookField struct {
+	index int
+	// promoted is true for embedded fields whose hooks are promoted to the enclosing struct.
+	promoted bool
+}
+
+// hookPlan is the cached traversal plan of a struct type.
+type hookPlan struct {
+	isModel bool
+	fields  []hookField
+}
+
+var (
+	hookPlans   sync.Map // reflect.Type -> *hookPlan
+	holdsModels sync.Map // reflect.Type -> bool
+)
+
+func planFor(t reflect.Type) *hookPlan {
+
+	if p, ok := hookPlans.Load(t); ok {
+		return p.(*hookPlan)
+	}
+
+	plan := &hookPlan{isModel: reflect.PointerTo(t).Implements(modelType)}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() || !mayHoldModel(sf.Type) {
+			continue
+		}
+
+		plan.fields = append(plan.fields, hookField{index: i, promoted: sf.Anonymous && plan.isModel})
+	}
+
+	p, _ := hookPlans.LoadOrStore(t, plan)
+	return p.(*hookPlan)
+}
+
+// mayHoldModel reports whether a value of type t can contain a Model.
+func mayHoldModel(t reflect.Type) bool {
+
+	if v, ok := holdsModels.Load(t); ok {
+		return v.(bool)
+	}
+
+	res, _ := computeMayHoldModel(t, map[reflect.Type]bool{})
+	return res
+}
+
+// computeMayHoldModel walks t and caches the result of every type whose answer does not depend on a type still being
+// visited, so that recursive types are resolved correctly.
+func computeMayHoldModel(t reflect.Type, visiting map[reflect.Type]bool) (res bool, pending bool) {
+
+	if v, ok := holdsModels.Load(t); ok {
+		return v.(bool), false
+	}
+	if visiting[t] {
+		return false, true
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		res = true
+
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		visiting[t] = true
+		res, pending = computeMayHoldModel(t.Elem(), visiting)
+		delete(visiting, t)
+
+	case reflect.Struct:
+		if reflect.PointerTo(t).Implements(modelType) {
+			res = true
+			break
+		}
+
+		visiting[t] = true
+		for i := 0; i < t.NumField() && !res; i++ {
+			sf := t.Field(i)
+			if !sf.IsExported() {
+				continue
+			}
+
+			fieldRes, fieldPending := computeMayHoldModel(sf.Type, visiting)
+			res = res || fieldRes
+			pending = pending || fieldPending
+		}
+		delete(visiting, t)
+	}
+
+	if res || !pending {
+		holdsModels.Store(t, res)
+	}
+
+	return res, pending && !res
+}
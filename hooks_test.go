@@ -0,0 +1,87 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type lineItem struct {
+	fm.BaseModel `bson:",inline"`
+
+	SKU string `bson:"sku"`
+}
+
+type shipment struct {
+	fm.BaseModel `bson:",inline"`
+
+	Carrier string `bson:"carrier"`
+}
+
+type order struct {
+	fm.BaseModel `bson:",inline"`
+
+	Code      string               `bson:"code"`
+	Items     []lineItem           `bson:"items"`
+	Shipment  *shipment            `bson:"shipment"`
+	ByCountry map[string]*shipment `bson:"byCountry"`
+	Gifts     map[string]lineItem  `bson:"gifts"`
+}
+
+func newOrderRepo() *fm.BaseRepository[*order] {
+	return fm.NewBaseRepository(fm.GetInstance().Database(testDB), "orders", new(order))
+}
+
+func TestHooks_NestedOnCreate(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	o := &order{
+		Code:      "nested create",
+		Items:     []lineItem{{SKU: "a"}, {SKU: "b"}},
+		Shipment:  &shipment{Carrier: "ups"},
+		ByCountry: map[string]*shipment{"it": {Carrier: "poste"}},
+		Gifts:     map[string]lineItem{"card": {SKU: "c"}},
+	}
+
+	err := newOrderRepo().InsertOne(context.Background(), o)
+	require.NoError(t, err)
+
+	assert.False(t, o.ID.IsZero())
+	for _, item := range o.Items {
+		assert.False(t, item.ID.IsZero())
+		assert.False(t, item.CreatedAt.IsZero())
+	}
+	assert.False(t, o.Shipment.ID.IsZero())
+	assert.False(t, o.ByCountry["it"].ID.IsZero())
+	assert.False(t, o.Gifts["card"].ID.IsZero())
+	assert.NotEqual(t, o.Items[0].ID, o.Items[1].ID)
+
+	found, err := newOrderRepo().FindOne(context.Background(), bson.M{"code": "nested create"})
+	require.NoError(t, err)
+	require.Len(t, found.Items, 2)
+	assert.Equal(t, o.Items[0].ID, found.Items[0].ID)
+	assert.Equal(t, o.Gifts["card"].ID, found.Gifts["card"].ID)
+}
+
+func TestHooks_NestedOnReplace(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	o := &order{Code: "nested replace", Items: []lineItem{{SKU: "a"}}}
+	err := newOrderRepo().InsertOne(context.Background(), o)
+	require.NoError(t, err)
+
+	createdAt := o.Items[0].CreatedAt
+	updatedAt := o.Items[0].UpdatedAt
+
+	_, err = newOrderRepo().ReplaceOne(context.Background(), bson.M{"code": "nested replace"}, o)
+	require.NoError(t, err)
+
+	assert.Equal(t, createdAt, o.Items[0].CreatedAt)
+	assert.NotEqual(t, updatedAt, o.Items[0].UpdatedAt)
+}
@@ -0,0 +1,305 @@
+package friendlymongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// InsertManyResult reports which documents InsertMany inserted and which it could not.
+type InsertManyResult struct {
+	// InsertedIDs maps the index, in the documents given to InsertMany, of each inserted document to its _id.
+	InsertedIDs map[int]interface{}
+	// Errors lists the documents that could not be inserted, by increasing index. With ordered inserts, the documents
+	// following the first failure are neither inserted nor listed.
+	Errors []InsertError
+	// WriteConcernError is the write concern error of the first chunk whose write concern could not be satisfied. The
+	// documents of that chunk were written and are listed in InsertedIDs, unless they failed.
+	WriteConcernError error
+}
+
+// InsertError is the failure to insert one of the documents given to InsertMany.
+type InsertError struct {
+	// Index is the index of the document in the documents given to InsertMany.
+	Index int
+	// Err is the error of the document, e.g. matching ErrDuplicateKey.
+	Err error
+}
+
+// WithOrdered sets whether InsertMany stops at the first failure, which is the default, or attempts every document.
+func WithOrdered(ordered bool) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.ordered = &ordered
+		opts.mark(optOrdered)
+	}
+}
+
+// WithChunkSize splits the documents given to InsertMany into chunks of at most n documents, each sent separately.
+func WithChunkSize(n int) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.chunkSize = n
+		opts.mark(optChunkSize)
+	}
+}
+
+// WithChunkBytes splits the documents given to InsertMany into chunks of at most n bytes of BSON, each sent
+// separately. A document larger than n is sent alone.
+func WithChunkBytes(n int) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.chunkBytes = n
+		opts.mark(optChunkBytes)
+	}
+}
+
+// WithParallelism sends up to n chunks of an unordered InsertMany concurrently.
+func WithParallelism(n int) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.parallelism = n
+		opts.mark(optParallelism)
+	}
+}
+
+// isOrdered reports whether the inserts are ordered, and whether the options are consistent.
+func (o *queryOpts) isOrdered() (bool, error) {
+
+	ordered := o.ordered == nil || *o.ordered
+	if ordered && o.parallelism > 1 {
+		return false, fmt.Errorf("option parallelism requires unordered inserts, see WithOrdered")
+	}
+
+	return ordered, nil
+}
+
+// InsertMany inserts multiple documents into the collection. OnCreate is invoked on every document, and documents
+// without an _id are given an ObjectID.
+//
+// The documents are sent in a single request, split by the driver as needed, unless WithChunkSize or WithChunkBytes
+// are used. Ordered inserts, the default, stop at the first failure; with WithOrdered(false) every document is
+// attempted, and chunks can be sent concurrently with WithParallelism.
+//
+// The result reports the inserted documents and the failed ones by index, also when an error is returned. The
+// returned error is the one of the first failed document or, when every document was written, the write concern
+// error.
+//
+//	res, err := repo.InsertMany(ctx, users, friendlymongo.WithOrdered(false), friendlymongo.WithChunkSize(500))
+//	for _, e := range res.Errors {
+//		if errors.Is(e.Err, friendlymongo.ErrDuplicateKey) {
+//			log.Printf("user %s already exists", users[e.Index].Email)
+//		}
+//	}
+func (r *BaseRepository[T]) InsertMany(
+	ctx context.Context,
+	documents []T,
+	opts ...QueryOptsFunc,
+) (*InsertManyResult, error) {
+
+	op := &Operation{Kind: "InsertMany", Collection: r.collection.Name(), Documents: documentsOf(documents)}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*InsertManyResult, error) {
+		documents, err := modelsOf[T](op)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.insertMany(ctx, documents, opts...)
+	})
+}
+
+func (r *BaseRepository[T]) insertMany(
+	ctx context.Context,
+	documents []T,
+	opts ...QueryOptsFunc,
+) (_ *InsertManyResult, err error) {
+
+	defer r.wrapErr(&err, "InsertMany", nil)
+	defer r.invalidate(ctx)
+
+	o, err := newQueryOpts("InsertMany", opts, insertManySupported...)
+	if err != nil {
+		return nil, err
+	}
+	ordered, err := o.isOrdered()
+	if err != nil {
+		return nil, err
+	}
+
+	raws := make([]bson.Raw, len(documents))
+	for i, d := range documents {
+		runHooks(d, onCreate)
+
+		if raws[i], err = marshalDocument(r.registry, r.discriminator, d); err != nil {
+			return nil, err
+		}
+		raws[i] = withRawID(raws[i])
+	}
+
+	res := &InsertManyResult{InsertedIDs: make(map[int]interface{}, len(documents))}
+	var mu sync.Mutex
+	wcStart := len(documents)
+	report := func(start int, chunk []bson.Raw, err error) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		failed, wcErr := r.reportChunk(res, start, chunk, ordered, err)
+		if wcErr != nil && start < wcStart {
+			res.WriteConcernError, wcStart = wcErr, start
+		}
+		return !(ordered && failed)
+	}
+
+	chunks := chunkRaws(raws, o.chunkSize, o.chunkBytes)
+	if ordered || o.parallelism <= 1 {
+		for _, c := range chunks {
+			if !report(c.start, c.raws, r.insertChunk(ctx, c.raws, ordered)) {
+				break
+			}
+		}
+	} else {
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, o.parallelism)
+		for _, c := range chunks {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				report(c.start, c.raws, r.insertChunk(ctx, c.raws, ordered))
+			}()
+		}
+		wg.Wait()
+	}
+
+	sort.Slice(res.Errors, func(i, j int) bool { return res.Errors[i].Index < res.Errors[j].Index })
+	if len(res.Errors) > 0 {
+		return res, res.Errors[0].Err
+	}
+
+	return res, res.WriteConcernError
+}
+
+func (r *BaseRepository[T]) insertChunk(ctx context.Context, chunk []bson.Raw, ordered bool) error {
+
+	docs := make([]interface{}, len(chunk))
+	for i, raw := range chunk {
+		docs[i] = raw
+	}
+
+	_, err := r.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(ordered))
+	return err
+}
+
+// reportChunk records in res the outcome of inserting chunk, whose first document is at index start, and reports
+// whether any of its documents failed. A write concern error does not fail the documents, which were written: it is
+// returned instead.
+func (r *BaseRepository[T]) reportChunk(
+	res *InsertManyResult,
+	start int,
+	chunk []bson.Raw,
+	ordered bool,
+	err error,
+) (bool, error) {
+
+	failed := make(map[int]error)
+	var wcErr error
+	var bwe mongo.BulkWriteException
+	switch {
+	case err == nil:
+	case errors.As(err, &bwe) && (len(bwe.WriteErrors) > 0 || bwe.WriteConcernError != nil):
+		for _, we := range bwe.WriteErrors {
+			e := we.WriteError
+			e.Index += start
+			failed[we.Index] = mongo.WriteException{WriteErrors: mongo.WriteErrors{e}, Labels: bwe.Labels}
+		}
+		if bwe.WriteConcernError != nil {
+			wce := mongo.WriteException{WriteConcernError: bwe.WriteConcernError, Labels: bwe.Labels}
+			wcErr = wrapError("InsertMany", r.collection.Name(), nil, nil, wce)
+		}
+	default:
+		// The outcome of the chunk is unknown, e.g. after a network error.
+		for i := range chunk {
+			failed[i] = err
+		}
+	}
+
+	firstFailure := len(chunk)
+	for i := range chunk {
+		if _, ok := failed[i]; ok {
+			firstFailure = min(firstFailure, i)
+		}
+	}
+
+	for i, raw := range chunk {
+		if e, ok := failed[i]; ok {
+			if !ordered || i == firstFailure {
+				err := wrapError("InsertMany", r.collection.Name(), nil, nil, e)
+				res.Errors = append(res.Errors, InsertError{Index: start + i, Err: err})
+			}
+			continue
+		}
+		if ordered && i > firstFailure {
+			continue
+		}
+
+		var id interface{}
+		_ = raw.Lookup("_id").Unmarshal(&id)
+		res.InsertedIDs[start+i] = id
+	}
+
+	return len(failed) > 0, wcErr
+}
+
+// rawChunk is a chunk of the documents given to InsertMany, whose first document is at index start.
+type rawChunk struct {
+	start int
+	raws  []bson.Raw
+}
+
+// chunkRaws splits raws into chunks of at most size documents and bytes bytes. A limit of zero or less is no limit.
+func chunkRaws(raws []bson.Raw, size, bytes int) []rawChunk {
+
+	var chunks []rawChunk
+	current := rawChunk{}
+	currentBytes := 0
+
+	for i, raw := range raws {
+		full := (size > 0 && len(current.raws) >= size) || (bytes > 0 && currentBytes+len(raw) > bytes)
+		if len(current.raws) > 0 && full {
+			chunks = append(chunks, current)
+			current, currentBytes = rawChunk{start: i}, 0
+		}
+
+		current.raws = append(current.raws, raw)
+		currentBytes += len(raw)
+	}
+
+	if len(current.raws) > 0 {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
+
+// withRawID returns raw with a new ObjectID as first field if it has no _id.
+func withRawID(raw bson.Raw) bson.Raw {
+
+	if _, err := raw.LookupErr("_id"); err == nil {
+		return raw
+	}
+
+	idx, doc := bsoncore.AppendDocumentStart(nil)
+	doc = bsoncore.AppendObjectIDElement(doc, "_id", primitive.NewObjectID())
+	doc = append(doc, raw[4:len(raw)-1]...)
+	doc, _ = bsoncore.AppendDocumentEnd(doc, idx)
+
+	return doc
+}
@@ -0,0 +1,108 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// artworksWithDuplicates returns n artworks, where the ones at the indexes of dups reuse the _id of the first one.
+func artworksWithDuplicates(n int, dups ...int) []*artwork {
+	id := primitive.NewObjectID()
+
+	artworks := make([]*artwork, n)
+	for i := range artworks {
+		artworks[i] = &artwork{Title: fmt.Sprintf("artwork %d", i)}
+	}
+	artworks[0].ID = id
+	for _, i := range dups {
+		artworks[i].ID = id
+	}
+
+	return artworks
+}
+
+func TestInsertMany_Ordered(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "insert_many_ordered")
+	ctx := context.Background()
+
+	res, err := r.InsertMany(ctx, artworksWithDuplicates(10, 4), fm.WithChunkSize(3))
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+	require.NotNil(t, res)
+	assert.Len(t, res.InsertedIDs, 4)
+	require.Len(t, res.Errors, 1)
+	assert.Equal(t, 4, res.Errors[0].Index)
+
+	count, err := r.Count(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+}
+
+func TestInsertMany_Unordered(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "insert_many_unordered")
+	ctx := context.Background()
+
+	artworks := artworksWithDuplicates(50, 7, 31)
+	res, err := r.InsertMany(ctx, artworks,
+		fm.WithOrdered(false), fm.WithChunkSize(10), fm.WithChunkBytes(1024), fm.WithParallelism(3))
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+	require.NotNil(t, res)
+	assert.Len(t, res.InsertedIDs, 48)
+	assert.Equal(t, artworks[0].ID, res.InsertedIDs[0])
+
+	require.Len(t, res.Errors, 2)
+	assert.Equal(t, []int{7, 31}, []int{res.Errors[0].Index, res.Errors[1].Index})
+	var dke *fm.DuplicateKeyError
+	require.True(t, errors.As(res.Errors[1].Err, &dke))
+	assert.Equal(t, "_id_", dke.Index)
+
+	for i, id := range res.InsertedIDs {
+		found, err := r.FindByID(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, artworks[i].Title, found.Title)
+	}
+
+	_, err = r.InsertMany(ctx, artworksWithDuplicates(2), fm.WithParallelism(2))
+	assert.Error(t, err)
+}
+
+func TestInsertMany_WriteConcernError(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+	requireReplicaSet(t)
+
+	// No replica set has 50 members: the documents are written but the write concern cannot be satisfied.
+	db := fm.GetInstance().Client().Database(testDB, options.Database().SetWriteConcern(&writeconcern.WriteConcern{W: 50}))
+	r := fm.NewBaseRepository(db, "insert_many_write_concern", new(artwork))
+	ctx := context.Background()
+
+	_, err := r.Delete(ctx, bson.M{})
+	require.NoError(t, err)
+
+	res, err := r.InsertMany(ctx, artworksWithDuplicates(6), fm.WithChunkSize(2))
+	require.NotNil(t, res)
+	assert.Len(t, res.InsertedIDs, 6)
+	assert.Empty(t, res.Errors)
+	require.Error(t, res.WriteConcernError)
+	assert.Equal(t, res.WriteConcernError, err)
+
+	var we mongo.WriteException
+	require.ErrorAs(t, err, &we)
+	assert.NotNil(t, we.WriteConcernError)
+}
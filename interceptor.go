@@ -0,0 +1,224 @@
+package friendlymongo
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"sync"
+)
+
+// Operation describes a repository operation, as seen by the interceptors.
+type Operation struct {
+	// Kind is the name of the repository method, e.g. "FindOne" or "InsertMany". The ID-based methods are seen as
+	// their filter-based counterparts, e.g. FindByID as "FindOne", except FindByIDs and UpsertByID. The generic
+	// functions are seen as "Distinct", "Aggregate" and "AggregateOne", and the execution of a Bulk as "BulkWrite".
+	Kind string
+	// Collection is the name of the collection, empty for a MemoryRepository.
+	Collection string
+	// Filter is the filter of the operation, nil if it has none. Interceptors can replace it, e.g. to restrict the
+	// operation to the documents of a tenant.
+	Filter interface{}
+	// Update is the update parameter of UpdateOne and UpdateMany. Interceptors can replace it.
+	Update interface{}
+	// Pipeline is the pipeline of the aggregations and change streams. Interceptors can replace it.
+	Pipeline interface{}
+	// Documents are the models written by the inserts, replaces and upserts. Interceptors can modify them in place or
+	// replace them with models of the repository type. For BulkWrite, they are the models the bulk was built with,
+	// already encoded: changing them has no effect.
+	Documents []interface{}
+}
+
+// Handler runs an operation and returns its result: the first result of the repository method, e.g. a T for FindOne,
+// or nil for the methods only returning an error.
+type Handler func(ctx context.Context, op *Operation) (interface{}, error)
+
+// Interceptor wraps the repository operations, e.g. to log them, measure them or authorize them. It calls next to run
+// the operation, possibly after modifying op, or short-circuits it by returning without calling next. A result
+// returned without calling next must have the type next would have returned, or be nil for the zero value.
+//
+//	func logging(ctx context.Context, op *friendlymongo.Operation, next friendlymongo.Handler) (interface{}, error) {
+//		start := time.Now()
+//		res, err := next(ctx, op)
+//		log.Printf("%s on %s took %s: %v", op.Kind, op.Collection, time.Since(start), err)
+//		return res, err
+//	}
+//
+// The iterators returned by FindIter, AggregateIter and Watch run the interceptors around their whole iteration, from
+// when they are ranged over: next returns when the iteration ends, with a nil result and the error that ended it,
+// which was already yielded. An interceptor can short-circuit them by returning an iterator of the same type.
+type Interceptor func(ctx context.Context, op *Operation, next Handler) (interface{}, error)
+
+var globalInterceptors struct {
+	mu   sync.RWMutex
+	list []Interceptor
+}
+
+// UseInterceptors registers interceptors applying to every repository, including the ones already created. They run
+// before the interceptors of the repositories, in the order they were registered.
+func UseInterceptors(interceptors ...Interceptor) {
+
+	globalInterceptors.mu.Lock()
+	defer globalInterceptors.mu.Unlock()
+
+	globalInterceptors.list = append(globalInterceptors.list, interceptors...)
+}
+
+// intercept runs fn through the global interceptors and then local ones. Errors are wrapped in an *Error.
+func intercept[R any](
+	ctx context.Context,
+	local []Interceptor,
+	op *Operation,
+	fn func(ctx context.Context, op *Operation) (R, error),
+) (R, error) {
+
+	globalInterceptors.mu.RLock()
+	chain := append(globalInterceptors.list[:len(globalInterceptors.list):len(globalInterceptors.list)], local...)
+	globalInterceptors.mu.RUnlock()
+
+	var zero R
+	if len(chain) == 0 {
+		res, err := fn(ctx, op)
+		return res, wrapError(op.Kind, op.Collection, nil, op.Filter, err)
+	}
+
+	next := Handler(func(ctx context.Context, op *Operation) (interface{}, error) {
+		return fn(ctx, op)
+	})
+	for i := len(chain) - 1; i >= 0; i-- {
+		interceptor, inner := chain[i], next
+		next = func(ctx context.Context, op *Operation) (interface{}, error) {
+			return interceptor(ctx, op, inner)
+		}
+	}
+
+	res, err := next(ctx, op)
+	if err != nil {
+		return zero, wrapError(op.Kind, op.Collection, nil, op.Filter, err)
+	}
+	if res == nil {
+		return zero, nil
+	}
+
+	typed, ok := res.(R)
+	if !ok {
+		err := fmt.Errorf("interceptor returned a %T, want a %T", res, zero)
+		return zero, wrapError(op.Kind, op.Collection, nil, op.Filter, err)
+	}
+
+	return typed, nil
+}
+
+// interceptErr runs fn, which only returns an error, through the interceptors.
+func interceptErr(
+	ctx context.Context,
+	local []Interceptor,
+	op *Operation,
+	fn func(ctx context.Context, op *Operation) error,
+) error {
+
+	_, err := intercept(ctx, local, op, func(ctx context.Context, op *Operation) (interface{}, error) {
+		return nil, fn(ctx, op)
+	})
+
+	return err
+}
+
+// interceptSeq runs the interceptors around the whole iteration of the iterator returned by fn. Errors met during
+// the iteration are yielded as they occur; the error returned by the interceptors is only yielded if it is another
+// one, e.g. when an interceptor short-circuits the operation.
+func interceptSeq[V any](
+	ctx context.Context,
+	local []Interceptor,
+	op *Operation,
+	fn func(ctx context.Context, op *Operation) (iter.Seq2[V, error], error),
+) iter.Seq2[V, error] {
+
+	return func(yield func(V, error) bool) {
+		stopped, failed := false, false
+		forward := func(v V, err error) bool {
+			if stopped {
+				return false
+			}
+			failed = failed || err != nil
+			stopped = !yield(v, err)
+			return !stopped
+		}
+
+		seq, err := intercept(ctx, local, op, func(ctx context.Context, op *Operation) (iter.Seq2[V, error], error) {
+			seq, err := fn(ctx, op)
+			if err != nil {
+				return nil, err
+			}
+
+			var last error
+			for v, err := range seq {
+				if err != nil {
+					last = err
+				}
+				if !forward(v, err) {
+					break
+				}
+			}
+
+			return nil, last
+		})
+
+		// A short-circuiting interceptor returns the iterator to run instead.
+		if seq != nil {
+			for v, err := range seq {
+				if !forward(v, err) {
+					return
+				}
+			}
+		}
+
+		if err != nil && !failed {
+			var zero V
+			forward(zero, err)
+		}
+	}
+}
+
+// documentsOf returns documents as a slice of interfaces, for Operation.Documents.
+func documentsOf[T Model](documents []T) []interface{} {
+
+	res := make([]interface{}, len(documents))
+	for i, d := range documents {
+		res[i] = d
+	}
+
+	return res
+}
+
+// modelsOf returns the documents of op as models of type T, so that the documents replaced by the interceptors are
+// the ones written.
+func modelsOf[T Model](op *Operation) ([]T, error) {
+
+	res := make([]T, len(op.Documents))
+	for i, d := range op.Documents {
+		m, ok := d.(T)
+		if !ok {
+			var zero T
+			return nil, fmt.Errorf("interceptor set a %T document, want a %T", d, zero)
+		}
+		res[i] = m
+	}
+
+	return res, nil
+}
+
+// modelOf returns the only document of op as a model of type T. See modelsOf.
+func modelOf[T Model](op *Operation) (T, error) {
+
+	var zero T
+
+	documents, err := modelsOf[T](op)
+	if err != nil {
+		return zero, err
+	}
+	if len(documents) != 1 {
+		return zero, fmt.Errorf("interceptor left %d documents, want 1", len(documents))
+	}
+
+	return documents[0], nil
+}
@@ -0,0 +1,275 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"sync"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// recorder is an interceptor recording the kinds of the operations it sees.
+type recorder struct {
+	mu    sync.Mutex
+	kinds []string
+}
+
+func (rec *recorder) intercept(ctx context.Context, op *fm.Operation, next fm.Handler) (interface{}, error) {
+	rec.mu.Lock()
+	rec.kinds = append(rec.kinds, op.Kind)
+	rec.mu.Unlock()
+
+	return next(ctx, op)
+}
+
+// scopeToArtist restricts every filtered operation to the artworks of artist.
+func scopeToArtist(artist string) fm.Interceptor {
+	return func(ctx context.Context, op *fm.Operation, next fm.Handler) (interface{}, error) {
+		if op.Filter != nil {
+			op.Filter = bson.D{{Key: "$and", Value: bson.A{op.Filter, bson.D{{Key: "artist", Value: artist}}}}}
+		}
+		return next(ctx, op)
+	}
+}
+
+var errForbidden = errors.New("forbidden")
+
+func denyDeletes(ctx context.Context, op *fm.Operation, next fm.Handler) (interface{}, error) {
+	if op.Kind == "Delete" || op.Kind == "DeleteOne" {
+		return nil, errForbidden
+	}
+	return next(ctx, op)
+}
+
+func TestInterceptors_Memory(t *testing.T) {
+	t.Parallel()
+
+	rec := &recorder{}
+	r := fm.NewMemoryRepository(new(artwork),
+		fm.WithInterceptors(rec.intercept, scopeToArtist("Munch"), denyDeletes))
+	ctx := context.Background()
+
+	_, err := r.InsertMany(ctx, []*artwork{
+		{Title: "The Scream", Artist: "Munch", Year: 1893},
+		{Title: "Madonna", Artist: "Munch", Year: 1894},
+		{Title: "Dancer", Artist: "Miro", Year: 1925},
+	})
+	require.NoError(t, err)
+
+	found, err := r.Find(ctx, bson.M{}, fm.WithSort(bson.M{"year": 1}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"The Scream", "Madonna"}, titlesOf(found))
+
+	count, err := r.Count(ctx, bson.M{"year": bson.M{"$gt": 1900}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	_, err = r.FindOne(ctx, bson.M{"title": "Dancer"})
+	assert.ErrorIs(t, err, fm.ErrNotFound)
+
+	var titles []string
+	for a, err := range r.FindIter(ctx, bson.M{}) {
+		require.NoError(t, err)
+		titles = append(titles, a.Title)
+	}
+	assert.Len(t, titles, 2)
+
+	artists, err := fm.Distinct[string](ctx, r, "artist", bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Munch"}, artists)
+
+	_, err = r.Delete(ctx, bson.M{})
+	assert.ErrorIs(t, err, errForbidden)
+	var e *fm.Error
+	require.ErrorAs(t, err, &e)
+	assert.Equal(t, "Delete", e.Op)
+
+	res, err := r.UpdateMany(ctx, bson.M{}, bson.M{"$set": bson.M{"price": 10}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.MatchedCount)
+
+	_, err = r.Bulk().Insert(&artwork{Title: "Vampire", Artist: "Munch"}).Execute(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"InsertMany", "Find", "Count", "FindOne", "FindIter", "Distinct", "Delete", "UpdateMany", "BulkWrite",
+	}, rec.kinds)
+}
+
+func TestInterceptors_ShortCircuit(t *testing.T) {
+	t.Parallel()
+
+	cached := &artwork{Title: "cached"}
+	r := fm.NewMemoryRepository(new(artwork), fm.WithInterceptors(
+		func(ctx context.Context, op *fm.Operation, next fm.Handler) (interface{}, error) {
+			switch op.Kind {
+			case "FindOne":
+				return cached, nil
+			case "Count":
+				return "not a count", nil
+			case "InsertOne":
+				op.Documents[0].(*artwork).Tags = []string{"intercepted"}
+			}
+			return next(ctx, op)
+		}))
+	ctx := context.Background()
+
+	found, err := r.FindByID(ctx, "000000000000000000000000")
+	require.NoError(t, err)
+	assert.Same(t, cached, found)
+
+	_, err = r.Count(ctx, bson.M{})
+	assert.ErrorContains(t, err, "interceptor returned a string")
+
+	require.NoError(t, r.InsertOne(ctx, &artwork{Title: "inserted"}))
+	all, err := r.Find(ctx, bson.M{})
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, []string{"intercepted"}, all[0].Tags)
+}
+
+func TestInterceptors_Iteration(t *testing.T) {
+	t.Parallel()
+
+	var seen []string
+	r := fm.NewMemoryRepository(new(artwork), fm.WithInterceptors(
+		func(ctx context.Context, op *fm.Operation, next fm.Handler) (interface{}, error) {
+			res, err := next(ctx, op)
+			seen = append(seen, op.Kind)
+			return res, err
+		}))
+	ctx := context.Background()
+
+	_, err := r.InsertMany(ctx, []*artwork{{Title: "The Scream"}, {Title: "Madonna"}})
+	require.NoError(t, err)
+
+	// The interceptor returns once the iteration is over.
+	for range r.FindIter(ctx, bson.M{}) {
+		assert.Equal(t, []string{"InsertMany"}, seen)
+	}
+	assert.Equal(t, []string{"InsertMany", "FindIter"}, seen)
+
+	var errs []error
+	for _, err := range r.FindIter(ctx, bson.M{"$where": "true"}) {
+		errs = append(errs, err)
+	}
+	require.Len(t, errs, 1, "the error of the iteration is yielded once")
+	assert.ErrorContains(t, errs[0], "unsupported query operator $where")
+
+	short := fm.NewMemoryRepository(new(artwork), fm.WithInterceptors(
+		func(ctx context.Context, op *fm.Operation, next fm.Handler) (interface{}, error) {
+			return iter.Seq2[*artwork, error](func(yield func(*artwork, error) bool) {
+				yield(&artwork{Title: "cached"}, nil)
+			}), nil
+		}))
+
+	var titles []string
+	for a, err := range short.FindIter(ctx, bson.M{}) {
+		require.NoError(t, err)
+		titles = append(titles, a.Title)
+	}
+	assert.Equal(t, []string{"cached"}, titles)
+}
+
+func TestInterceptors_ReplaceDocuments(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(artwork), fm.WithInterceptors(
+		func(ctx context.Context, op *fm.Operation, next fm.Handler) (interface{}, error) {
+			if op.Kind == "InsertOne" || op.Kind == "UpsertOne" {
+				op.Documents = []interface{}{&artwork{Title: "replaced", Artist: "Munch"}}
+			}
+			return next(ctx, op)
+		}))
+	ctx := context.Background()
+
+	require.NoError(t, r.InsertOne(ctx, &artwork{Title: "original"}))
+
+	res, err := r.UpsertOne(ctx, bson.M{"title": "replaced"}, &artwork{Title: "original"})
+	require.NoError(t, err)
+	assert.False(t, res.Inserted)
+	assert.Equal(t, "replaced", res.Document.Title)
+
+	all, err := r.Find(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"replaced"}, titlesOf(all))
+
+	wrong := fm.NewMemoryRepository(new(artwork), fm.WithInterceptors(
+		func(ctx context.Context, op *fm.Operation, next fm.Handler) (interface{}, error) {
+			op.Documents = []interface{}{"not a model"}
+			return next(ctx, op)
+		}))
+	err = wrong.InsertOne(ctx, &artwork{Title: "original"})
+	assert.ErrorContains(t, err, "interceptor set a string document")
+}
+
+type globalInterceptorKey struct{}
+
+func TestInterceptors_Global(t *testing.T) {
+	t.Parallel()
+
+	// Global interceptors see the operations of every test: only count the ones of this test.
+	var mu sync.Mutex
+	var kinds []string
+	fm.UseInterceptors(func(ctx context.Context, op *fm.Operation, next fm.Handler) (interface{}, error) {
+		if ctx.Value(globalInterceptorKey{}) != nil {
+			mu.Lock()
+			kinds = append(kinds, op.Kind)
+			mu.Unlock()
+		}
+		return next(ctx, op)
+	})
+
+	rec := &recorder{}
+	r := fm.NewMemoryRepository(new(artwork), fm.WithInterceptors(rec.intercept))
+	ctx := context.WithValue(context.Background(), globalInterceptorKey{}, true)
+
+	require.NoError(t, r.InsertOne(ctx, &artwork{Title: "a"}))
+	_, err := r.EstimatedCount(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"InsertOne", "EstimatedCount"}, kinds)
+	assert.Equal(t, kinds, rec.kinds)
+}
+
+func TestInterceptors(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	rec := &recorder{}
+	db := fm.GetInstance().Database(testDB)
+	r := fm.NewBaseRepository(db, "interceptors", new(artwork), fm.WithInterceptors(rec.intercept))
+	scoped := fm.NewBaseRepository(db, "interceptors", new(artwork), fm.WithInterceptors(scopeToArtist("Munch")))
+	ctx := context.Background()
+
+	_, err := r.Delete(ctx, bson.M{})
+	require.NoError(t, err)
+
+	scream := &artwork{Title: "The Scream", Artist: "Munch"}
+	dancer := &artwork{Title: "Dancer", Artist: "Miro"}
+	_, err = r.InsertMany(ctx, []*artwork{scream, dancer})
+	require.NoError(t, err)
+
+	found, missing, err := scoped.FindByIDs(ctx, []interface{}{scream.ID, dancer.ID})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"The Scream"}, titlesOf(found))
+	assert.Equal(t, []interface{}{dancer.ID}, missing)
+
+	_, err = scoped.UpdateOne(ctx, bson.M{"title": "Dancer"}, bson.M{"$set": bson.M{"year": 1925}})
+	assert.ErrorIs(t, err, fm.ErrNotFound)
+
+	n, err := scoped.Delete(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	count, err := r.Count(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	assert.Equal(t, []string{"Delete", "InsertMany", "Count"}, rec.kinds)
+}
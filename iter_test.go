@@ -0,0 +1,82 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newIterArtworkRepo(t *testing.T, collection string) *fm.BaseRepository[*artwork] {
+	r := fm.NewBaseRepository(fm.GetInstance().Database(testDB), collection, new(artwork))
+
+	_, err := r.Delete(context.Background(), bson.M{})
+	require.NoError(t, err)
+
+	artworks := make([]*artwork, 25)
+	for i := range artworks {
+		artworks[i] = &artwork{Title: fmt.Sprintf("streamed %02d", i), Artist: "iter", Year: 1900 + i}
+	}
+	_, err = r.InsertMany(context.Background(), artworks)
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestFindIter(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newIterArtworkRepo(t, "findIter")
+
+	var titles []string
+	for a, err := range r.FindIter(context.Background(), bson.M{"artist": "iter"},
+		fm.WithSort(bson.M{"year": 1}), fm.WithBatchSize(4)) {
+		require.NoError(t, err)
+		titles = append(titles, a.Title)
+	}
+	require.Len(t, titles, 25)
+	assert.Equal(t, "streamed 00", titles[0])
+	assert.Equal(t, "streamed 24", titles[24])
+
+	// Breaking early closes the cursor and leaves the repository usable.
+	count := 0
+	for _, err := range r.FindIter(context.Background(), bson.M{"artist": "iter"}, fm.WithBatchSize(2)) {
+		require.NoError(t, err)
+		if count++; count == 3 {
+			break
+		}
+	}
+	assert.Equal(t, 3, count)
+
+	for _, err := range r.FindIter(context.Background(), bson.M{}, fm.WithBatchSize(2), fm.WithLimit(1)) {
+		require.NoError(t, err)
+	}
+}
+
+func TestAggregateIter(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newIterArtworkRepo(t, "aggregateIter")
+
+	pipeline := fm.NewStageBuilder().
+		Match("stg1", bson.M{"artist": "iter", "year": bson.M{"$gte": 1920}}).
+		Sort("stg2", bson.M{"year": -1}).
+		Build()
+
+	var years []int
+	for a, err := range r.AggregateIter(context.Background(), pipeline, fm.WithBatchSize(2), fm.WithAllowDiskUse(true)) {
+		require.NoError(t, err)
+		years = append(years, a.Year)
+	}
+	assert.Equal(t, []int{1924, 1923, 1922, 1921, 1920}, years)
+
+	for _, err := range r.AggregateIter(context.Background(), pipeline, fm.WithSkip(1)) {
+		assert.EqualError(t, err, "AggregateIter on aggregateIter: option skip is not supported by AggregateIter")
+	}
+}
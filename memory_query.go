@@ -0,0 +1,541 @@
+package friendlymongo
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// matchDocument reports whether doc matches the query filter, evaluating it the way the MongoDB server does for the
+// supported operators.
+func matchDocument(doc bson.D, filter bson.D) (bool, error) {
+
+	for _, e := range filter {
+		var ok bool
+		var err error
+
+		switch e.Key {
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, e.Key, e.Value)
+		default:
+			if strings.HasPrefix(e.Key, "$") {
+				return false, fmt.Errorf("unsupported query operator %s", e.Key)
+			}
+			ok, err = matchField(doc, e.Key, e.Value)
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func matchLogical(doc bson.D, op string, v interface{}) (bool, error) {
+
+	clauses, ok := v.(bson.A)
+	if !ok || len(clauses) == 0 {
+		return false, fmt.Errorf("%s must be a nonempty array", op)
+	}
+
+	for _, c := range clauses {
+		sub, ok := c.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("%s entries must be documents", op)
+		}
+
+		matched, err := matchDocument(doc, sub)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case op == "$and" && !matched:
+			return false, nil
+		case op == "$or" && matched:
+			return true, nil
+		case op == "$nor" && matched:
+			return false, nil
+		}
+	}
+
+	return op != "$or", nil
+}
+
+// matchField reports whether the values at path in doc satisfy cond, which is either an operator document or a value
+// to compare by equality.
+func matchField(doc bson.D, path string, cond interface{}) (bool, error) {
+
+	return matchValues(lookupPath(doc, strings.Split(path, ".")), cond)
+}
+
+// matchValues reports whether values, found at the same path, satisfy cond.
+func matchValues(values []interface{}, cond interface{}) (bool, error) {
+
+	if ops, ok := cond.(bson.D); ok && isOperatorDocument(ops) {
+		for _, op := range ops {
+			ok, err := matchOperator(values, op.Key, op.Value, ops)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	if re, ok := cond.(primitive.Regex); ok {
+		return matchRegex(values, re.Pattern, re.Options)
+	}
+
+	return matchEqual(values, cond), nil
+}
+
+func isOperatorDocument(d bson.D) bool {
+
+	return len(d) > 0 && strings.HasPrefix(d[0].Key, "$")
+}
+
+func matchOperator(values []interface{}, op string, v interface{}, ops bson.D) (bool, error) {
+
+	switch op {
+	case "$eq":
+		return matchEqual(values, v), nil
+	case "$ne":
+		return !matchEqual(values, v), nil
+	case "$gt", "$gte", "$lt", "$lte":
+		return matchCompare(values, op, v), nil
+	case "$in", "$nin":
+		list, ok := v.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("%s needs an array", op)
+		}
+
+		in, err := matchIn(values, list)
+		if err != nil {
+			return false, err
+		}
+		return in == (op == "$in"), nil
+	case "$all":
+		list, ok := v.(bson.A)
+		if !ok {
+			return false, fmt.Errorf("$all needs an array")
+		}
+
+		for _, item := range list {
+			if !matchEqual(values, item) {
+				return false, nil
+			}
+		}
+		return len(list) > 0, nil
+	case "$exists":
+		return (len(values) > 0) == truthy(v), nil
+	case "$size":
+		n, ok := toFloat(v)
+		if !ok {
+			return false, fmt.Errorf("$size needs a number")
+		}
+
+		for _, value := range values {
+			if arr, ok := value.(bson.A); ok && float64(len(arr)) == n {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$regex":
+		var options string
+		for _, o := range ops {
+			if o.Key == "$options" {
+				options, _ = o.Value.(string)
+			}
+		}
+
+		switch re := v.(type) {
+		case string:
+			return matchRegex(values, re, options)
+		case primitive.Regex:
+			if options == "" {
+				options = re.Options
+			}
+			return matchRegex(values, re.Pattern, options)
+		default:
+			return false, fmt.Errorf("$regex has to be a string")
+		}
+	case "$options":
+		return true, nil
+	case "$elemMatch":
+		cond, ok := v.(bson.D)
+		if !ok {
+			return false, fmt.Errorf("$elemMatch needs an object")
+		}
+		return matchElem(values, cond)
+	case "$not":
+		var matched bool
+		var err error
+
+		switch cond := v.(type) {
+		case bson.D:
+			matched, err = matchValues(values, cond)
+		case primitive.Regex:
+			matched, err = matchRegex(values, cond.Pattern, cond.Options)
+		default:
+			return false, fmt.Errorf("$not needs a regex or a document")
+		}
+		return !matched, err
+	default:
+		return false, fmt.Errorf("unsupported query operator %s", op)
+	}
+}
+
+// lookupPath returns the values found at the dotted path segs of v. Arrays met along the path are traversed, either
+// by index when the segment is numeric or by looking the rest of the path up in each of their elements.
+func lookupPath(v interface{}, segs []string) []interface{} {
+
+	if len(segs) == 0 {
+		return []interface{}{v}
+	}
+
+	switch c := v.(type) {
+	case bson.D:
+		for _, e := range c {
+			if e.Key == segs[0] {
+				return lookupPath(e.Value, segs[1:])
+			}
+		}
+	case bson.A:
+		var res []interface{}
+		if i, err := strconv.Atoi(segs[0]); err == nil {
+			if i >= 0 && i < len(c) {
+				res = append(res, lookupPath(c[i], segs[1:])...)
+			}
+		}
+		for _, e := range c {
+			if d, ok := e.(bson.D); ok {
+				res = append(res, lookupPath(d, segs)...)
+			}
+		}
+		return res
+	}
+
+	return nil
+}
+
+// expand returns values followed by the elements of the arrays among them, since a condition on an array field is
+// satisfied by the array itself or by any of its elements.
+func expand(values []interface{}) []interface{} {
+
+	res := append([]interface{}{}, values...)
+	for _, v := range values {
+		if arr, ok := v.(bson.A); ok {
+			res = append(res, arr...)
+		}
+	}
+
+	return res
+}
+
+func matchEqual(values []interface{}, v interface{}) bool {
+
+	if len(values) == 0 {
+		return v == nil
+	}
+
+	for _, value := range expand(values) {
+		if valuesEqual(value, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchIn(values []interface{}, list bson.A) (bool, error) {
+
+	for _, item := range list {
+		if re, ok := item.(primitive.Regex); ok {
+			matched, err := matchRegex(values, re.Pattern, re.Options)
+			if err != nil || matched {
+				return matched, err
+			}
+			continue
+		}
+
+		if matchEqual(values, item) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func matchCompare(values []interface{}, op string, v interface{}) bool {
+
+	for _, value := range expand(values) {
+		if typeClass(value) != typeClass(v) {
+			continue
+		}
+
+		c := compareValues(value, v)
+		switch {
+		case op == "$gt" && c > 0, op == "$gte" && c >= 0, op == "$lt" && c < 0, op == "$lte" && c <= 0:
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchRegex(values []interface{}, pattern, options string) (bool, error) {
+
+	var flags string
+	for _, o := range options {
+		switch o {
+		case 'i', 'm', 's':
+			flags += string(o)
+		case 'x':
+		default:
+			return false, fmt.Errorf("invalid regex option %q", o)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+
+	for _, value := range expand(values) {
+		if s, ok := value.(string); ok && re.MatchString(s) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func matchElem(values []interface{}, cond bson.D) (bool, error) {
+
+	for _, value := range values {
+		arr, ok := value.(bson.A)
+		if !ok {
+			continue
+		}
+
+		for _, elem := range arr {
+			var matched bool
+			var err error
+
+			if isOperatorDocument(cond) {
+				matched, err = matchValues([]interface{}{elem}, cond)
+			} else if d, ok := elem.(bson.D); ok {
+				matched, err = matchDocument(d, cond)
+			}
+
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func truthy(v interface{}) bool {
+
+	switch b := v.(type) {
+	case bool:
+		return b
+	case nil:
+		return false
+	}
+
+	if f, ok := toFloat(v); ok {
+		return f != 0
+	}
+
+	return true
+}
+
+// typeClass returns the canonical BSON sort order of the type of v. Numbers share a single class.
+func typeClass(v interface{}) int {
+
+	switch v.(type) {
+	case nil, primitive.Null:
+		return 1
+	case int32, int64, float64, primitive.Decimal128:
+		return 2
+	case string, primitive.Symbol:
+		return 3
+	case bson.D:
+		return 4
+	case bson.A:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	default:
+		return 12
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(n.String(), 64)
+		return f, err == nil
+	}
+
+	return 0, false
+}
+
+func valuesEqual(a, b interface{}) bool {
+
+	return typeClass(a) == typeClass(b) && compareValues(a, b) == 0
+}
+
+// compareValues compares a and b following the BSON comparison order.
+func compareValues(a, b interface{}) int {
+
+	if ca, cb := typeClass(a), typeClass(b); ca != cb {
+		return cmpInt(ca, cb)
+	}
+
+	switch x := a.(type) {
+	case nil, primitive.Null:
+		return 0
+	case int32, int64, float64, primitive.Decimal128:
+		if ia, ok := toInt64(x); ok {
+			if ib, ok := toInt64(b); ok {
+				return cmpInt64(ia, ib)
+			}
+		}
+
+		fa, _ := toFloat(x)
+		fb, _ := toFloat(b)
+		switch {
+		case fa < fb, math.IsNaN(fa) && !math.IsNaN(fb):
+			return -1
+		case fa > fb, !math.IsNaN(fa) && math.IsNaN(fb):
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(x, stringOf(b))
+	case primitive.Symbol:
+		return strings.Compare(string(x), stringOf(b))
+	case bson.D:
+		y := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := strings.Compare(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+			if c := compareValues(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(x), len(y))
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compareValues(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return cmpInt(len(x), len(y))
+	case primitive.Binary:
+		y := b.(primitive.Binary)
+		if len(x.Data) != len(y.Data) {
+			return cmpInt(len(x.Data), len(y.Data))
+		}
+		if x.Subtype != y.Subtype {
+			return cmpInt(int(x.Subtype), int(y.Subtype))
+		}
+		return bytes.Compare(x.Data, y.Data)
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		return cmpInt64(int64(x), int64(b.(primitive.DateTime)))
+	case primitive.Timestamp:
+		return primitive.CompareTimestamp(x, b.(primitive.Timestamp))
+	case primitive.Regex:
+		y := b.(primitive.Regex)
+		if c := strings.Compare(x.Pattern, y.Pattern); c != 0 {
+			return c
+		}
+		return strings.Compare(x.Options, y.Options)
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+func stringOf(v interface{}) string {
+
+	if s, ok := v.(primitive.Symbol); ok {
+		return string(s)
+	}
+
+	s, _ := v.(string)
+	return s
+}
+
+func toInt64(v interface{}) (int64, bool) {
+
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+
+	return 0, false
+}
+
+func cmpInt(a, b int) int {
+
+	return cmpInt64(int64(a), int64(b))
+}
+
+func cmpInt64(a, b int64) int {
+
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,1542 @@
+package friendlymongo
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Compile-time check that MemoryRepository satisfies the repository interface.
+var _ MongoRepository[*BaseModel] = (*MemoryRepository[*BaseModel])(nil)
+
+// MemoryRepository is an in-memory implementation of the MongoRepository interface, meant to replace BaseRepository
+// in unit tests that should not depend on a running MongoDB.
+//
+// Documents go through the same lifecycle hooks as with BaseRepository and are stored as BSON, encoded and decoded
+// with the repository codec registry. Filters support the comparison, logical, element and array query operators
+// ($eq, $ne, $gt, $gte, $lt, $lte, $in, $nin, $and, $or, $nor, $not, $exists, $regex, $elemMatch, $size, $all) on
+// dotted paths. Updates support $set, $unset, $setOnInsert, $inc, $mul, $min, $max, $rename, $push (with $each,
+// $position, $sort and $slice), $addToSet, $pull and $currentDate. Aggregate supports the $match, $sort, $skip, $limit
+// and $count stages. Unsupported operators are reported as errors.
+//
+// The sort, projection, skip and limit query options are evaluated in memory; the other query options only tune the
+// server execution and are ignored.
+type MemoryRepository[T Model] struct {
+	mu            sync.RWMutex
+	documents     []bson.D
+	registry      *bsoncodec.Registry
+	discriminator *Discriminator
+	encryption    *fieldEncryption
+	interceptors  []Interceptor
+}
+
+// NewMemoryRepository creates a new, empty, MemoryRepository. The codec options are honoured as in
+// NewBaseRepository, and the codecs of the client instance apply when one is set.
+func NewMemoryRepository[T Model](t T, opts ...RepositoryOptsFunc) *MemoryRepository[T] {
+
+	repoOpts := repositoryOpts{}
+	for _, opt := range opts {
+		opt(&repoOpts)
+	}
+
+	base := bson.DefaultRegistry
+	if instance != nil {
+		base = instance.Registry()
+	}
+
+	reg, _, enc := repoOpts.buildRegistry(base, modelTypesOf[T](repoOpts.discriminator))
+
+	return &MemoryRepository[T]{
+		registry:      reg,
+		discriminator: repoOpts.discriminator,
+		encryption:    enc,
+		interceptors:  repoOpts.interceptors,
+	}
+}
+
+// InsertOne inserts a single document into the repository. OnCreate is invoked on the document and on every Model
+// embedded in it.
+func (r *MemoryRepository[T]) InsertOne(ctx context.Context, document T) error {
+
+	op := &Operation{Kind: "InsertOne", Documents: []interface{}{document}}
+	return interceptErr(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) error {
+		document, err := modelOf[T](op)
+		if err != nil {
+			return err
+		}
+
+		return r.insertOne(ctx, document)
+	})
+}
+
+func (r *MemoryRepository[T]) insertOne(_ context.Context, document T) (err error) {
+
+	defer r.wrapErr(&err, "InsertOne", nil)
+
+	runHooks(document, onCreate)
+
+	doc, err := r.toStored(document)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.insert(doc)
+}
+
+// InsertMany inserts multiple documents into the repository. Ordered inserts, the default, stop at the first failure;
+// with WithOrdered(false) every document is attempted. The chunking options are accepted but have no effect.
+func (r *MemoryRepository[T]) InsertMany(
+	ctx context.Context,
+	documents []T,
+	opts ...QueryOptsFunc,
+) (*InsertManyResult, error) {
+
+	op := &Operation{Kind: "InsertMany", Documents: documentsOf(documents)}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*InsertManyResult, error) {
+		documents, err := modelsOf[T](op)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.insertMany(ctx, documents, opts...)
+	})
+}
+
+func (r *MemoryRepository[T]) insertMany(
+	_ context.Context,
+	documents []T,
+	opts ...QueryOptsFunc,
+) (_ *InsertManyResult, err error) {
+
+	defer r.wrapErr(&err, "InsertMany", nil)
+
+	o, err := newQueryOpts("InsertMany", opts, insertManySupported...)
+	if err != nil {
+		return nil, err
+	}
+	ordered, err := o.isOrdered()
+	if err != nil {
+		return nil, err
+	}
+
+	docs := make([]bson.D, len(documents))
+	for i, d := range documents {
+		runHooks(d, onCreate)
+
+		doc, err := r.toStored(d)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = doc
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res := &InsertManyResult{InsertedIDs: make(map[int]interface{}, len(docs))}
+	for i, doc := range docs {
+		if err := r.insert(doc); err != nil {
+			res.Errors = append(res.Errors, InsertError{Index: i, Err: wrapError("InsertMany", "", nil, nil, err)})
+			if ordered {
+				break
+			}
+			continue
+		}
+
+		res.InsertedIDs[i], _ = lookupKey(doc, "_id")
+	}
+
+	if len(res.Errors) > 0 {
+		return res, res.Errors[0].Err
+	}
+
+	return res, nil
+}
+
+// FindOne finds a single document in the repository. It returns mongo.ErrNoDocuments when no document matches.
+func (r *MemoryRepository[T]) FindOne(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (T, error) {
+
+	op := &Operation{Kind: "FindOne", Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (T, error) {
+		return r.findOne(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *MemoryRepository[T]) findOne(_ context.Context, filter interface{}, opts ...QueryOptsFunc) (_ T, err error) {
+
+	defer r.wrapErr(&err, "FindOne", filter)
+
+	var document T
+
+	o, err := newQueryOpts("FindOne", opts, findOneSupported...)
+	if err != nil {
+		return document, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	idx, err := r.query(filter, o)
+	if err != nil {
+		return document, err
+	}
+	if len(idx) == 0 {
+		return document, mongo.ErrNoDocuments
+	}
+
+	return r.decodeProjected(r.documents[idx[0]], o)
+}
+
+// Find finds multiple documents in the repository, in insertion order unless sorted with WithSort.
+func (r *MemoryRepository[T]) Find(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) ([]T, error) {
+
+	op := &Operation{Kind: "Find", Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) ([]T, error) {
+		return r.find(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *MemoryRepository[T]) find(_ context.Context, filter interface{}, opts ...QueryOptsFunc) (_ []T, err error) {
+
+	defer r.wrapErr(&err, "Find", filter)
+
+	var documents []T
+
+	o, err := newQueryOpts("Find", opts, findSupported...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	idx, err := r.query(filter, o)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range idx {
+		document, err := r.decodeProjected(r.documents[i], o)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+
+	return documents, nil
+}
+
+// FindIter returns an iterator over the documents matching filter. The matching documents are snapshotted when the
+// iteration starts, so the repository can be modified while iterating.
+func (r *MemoryRepository[T]) FindIter(
+	ctx context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) iter.Seq2[T, error] {
+
+	op := &Operation{Kind: "FindIter", Filter: filter}
+	return interceptSeq(ctx, r.interceptors, op,
+		func(ctx context.Context, op *Operation) (iter.Seq2[T, error], error) {
+			return r.findIter(ctx, op.Filter, opts...), nil
+		})
+}
+
+func (r *MemoryRepository[T]) findIter(
+	_ context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) iter.Seq2[T, error] {
+
+	return func(yield func(T, error) bool) {
+		var zero T
+		yield = r.wrapYield(yield, "FindIter", filter)
+
+		o, err := newQueryOpts("FindIter", opts, findSupported...)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		r.mu.RLock()
+		idx, err := r.query(filter, o)
+		docs := make([]bson.D, len(idx))
+		for i, j := range idx {
+			docs[i] = r.documents[j]
+		}
+		r.mu.RUnlock()
+
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		for _, doc := range docs {
+			document, err := r.decodeProjected(doc, o)
+			if !yield(document, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// FindByID finds the document with the given ID. See BaseRepository.FindByID.
+func (r *MemoryRepository[T]) FindByID(ctx context.Context, id interface{}, opts ...QueryOptsFunc) (T, error) {
+
+	return r.FindOne(ctx, idFilter(id), opts...)
+}
+
+// FindByIDs finds the documents with the given IDs, in the same order, and reports the missing ones.
+func (r *MemoryRepository[T]) FindByIDs(ctx context.Context, ids []interface{}) ([]T, []interface{}, error) {
+
+	var missing []interface{}
+
+	op := &Operation{Kind: "FindByIDs", Filter: idsFilter(ids)}
+	documents, err := intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) ([]T, error) {
+		var documents []T
+		var err error
+		documents, missing, err = r.findByIDs(ids, op.Filter)
+		return documents, err
+	})
+
+	return documents, missing, err
+}
+
+func (r *MemoryRepository[T]) findByIDs(ids []interface{}, filter interface{}) (_ []T, _ []interface{}, err error) {
+
+	defer r.wrapErr(&err, "FindByIDs", filter)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	idx, err := r.match(filter, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	found := make(map[string]bson.D, len(idx))
+	for _, i := range idx {
+		id, _ := lookupKey(r.documents[i], "_id")
+		if keys := idKeys(r.registry, id); len(keys) > 0 {
+			found[keys[0]] = r.documents[i]
+		}
+	}
+
+	docs, missing := orderByIDs(r.registry, ids, found)
+
+	documents := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		document, err := r.decode(doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		documents = append(documents, document)
+	}
+
+	return documents, missing, nil
+}
+
+// Count counts the documents matching filter, honouring WithSkip and WithLimit.
+func (r *MemoryRepository[T]) Count(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (int64, error) {
+
+	op := &Operation{Kind: "Count", Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (int64, error) {
+		return r.count(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *MemoryRepository[T]) count(_ context.Context, filter interface{}, opts ...QueryOptsFunc) (_ int64, err error) {
+
+	defer r.wrapErr(&err, "Count", filter)
+
+	o, err := newQueryOpts("Count", opts, countSupported...)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	idx, err := r.query(filter, o)
+	return int64(len(idx)), err
+}
+
+// Exists reports whether at least one document matches filter.
+func (r *MemoryRepository[T]) Exists(ctx context.Context, filter interface{}) (bool, error) {
+
+	op := &Operation{Kind: "Exists", Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (bool, error) {
+		return r.exists(ctx, op.Filter)
+	})
+}
+
+func (r *MemoryRepository[T]) exists(_ context.Context, filter interface{}) (_ bool, err error) {
+
+	defer r.wrapErr(&err, "Exists", filter)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	idx, err := r.match(filter, 1)
+	return len(idx) > 0, err
+}
+
+// EstimatedCount returns the number of documents in the repository.
+func (r *MemoryRepository[T]) EstimatedCount(ctx context.Context) (int64, error) {
+
+	op := &Operation{Kind: "EstimatedCount"}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (int64, error) {
+		return r.estimatedCount(ctx)
+	})
+}
+
+func (r *MemoryRepository[T]) estimatedCount(_ context.Context) (_ int64, err error) {
+
+	defer r.wrapErr(&err, "EstimatedCount", nil)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return int64(len(r.documents)), nil
+}
+
+func (r *MemoryRepository[T]) distinct(ctx context.Context, field string, filter interface{}) ([]interface{}, error) {
+
+	op := &Operation{Kind: "Distinct", Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) ([]interface{}, error) {
+		return r.distinctValues(field, op.Filter)
+	})
+}
+
+// distinctValues returns the distinct values of field, array elements being considered individually as by the
+// server.
+func (r *MemoryRepository[T]) distinctValues(field string, filter interface{}) (_ []interface{}, err error) {
+
+	defer r.wrapErr(&err, "Distinct", filter)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	idx, err := r.match(filter, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	for _, i := range idx {
+		for _, v := range lookupPath(r.documents[i], strings.Split(field, ".")) {
+			candidates := []interface{}{v}
+			if arr, ok := v.(bson.A); ok {
+				candidates = arr
+			}
+
+			for _, c := range candidates {
+				seen := false
+				for _, existing := range values {
+					seen = seen || valuesEqual(existing, c)
+				}
+				if !seen {
+					values = append(values, cloneValue(c))
+				}
+			}
+		}
+	}
+
+	return values, nil
+}
+
+func (r *MemoryRepository[T]) codecRegistry() *bsoncodec.Registry {
+
+	return r.registry
+}
+
+// collectionName is empty, as a MemoryRepository has no collection.
+func (r *MemoryRepository[T]) collectionName() string {
+
+	return ""
+}
+
+func (r *MemoryRepository[T]) discriminatorOf() *Discriminator {
+
+	return r.discriminator
+}
+
+// wrapErr wraps *err, if any, in an *Error for the operation op on filter, like BaseRepository does.
+func (r *MemoryRepository[T]) wrapErr(err *error, op string, filter interface{}) {
+
+	*err = wrapError(op, "", r.registry, filter, *err)
+}
+
+// wrapYield wraps the errors passed to yield like wrapErr.
+func (r *MemoryRepository[T]) wrapYield(yield func(T, error) bool, op string, filter interface{}) func(T, error) bool {
+
+	return func(document T, err error) bool {
+		return yield(document, wrapError(op, "", r.registry, filter, err))
+	}
+}
+
+// UpdateOne finds a single document and updates it, returning the document as it was before the update.
+// The update parameter must be a bson.M of update operators, an UpdateBuilder or a struct that implements the Model
+// interface.
+func (r *MemoryRepository[T]) UpdateOne(
+	ctx context.Context,
+	filters interface{},
+	update interface{},
+	opts ...QueryOptsFunc,
+) (T, error) {
+
+	op := &Operation{Kind: "UpdateOne", Filter: filters, Update: update}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (T, error) {
+		return r.updateOne(ctx, op.Filter, op.Update, opts...)
+	})
+}
+
+func (r *MemoryRepository[T]) updateOne(
+	_ context.Context,
+	filters interface{},
+	update interface{},
+	opts ...QueryOptsFunc,
+) (_ T, err error) {
+
+	defer r.wrapErr(&err, "UpdateOne", filters)
+
+	var document T
+	var updateQuery bson.D
+
+	o, err := newQueryOpts("UpdateOne", opts, updateOneSupported...)
+	if err != nil {
+		return document, err
+	}
+
+	switch u := update.(type) {
+	case T:
+		runHooks(u, onUpdate)
+
+		doc, err := r.encode(u)
+		if err != nil {
+			return document, err
+		}
+		updateQuery = bson.D{{Key: "$set", Value: doc}}
+	case bson.M:
+		if updateQuery, err = r.normalizeUpdate(withUpdatedAt(u)); err != nil {
+			return document, err
+		}
+	case UpdateBuilder:
+		built, err := buildUpdate(u)
+		if err != nil {
+			return document, err
+		}
+		if updateQuery, err = r.normalizeUpdate(built); err != nil {
+			return document, err
+		}
+	default:
+		return document, fmt.Errorf("%w: must be a bson.M, an UpdateBuilder or a Model, got %T", ErrInvalidUpdate, update)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	idx, err := r.query(filters, o)
+	if err != nil {
+		return document, err
+	}
+	if len(idx) == 0 {
+		if o.upsert == nil || !*o.upsert {
+			return document, mongo.ErrNoDocuments
+		}
+
+		f, err := r.normalize(filters)
+		if err != nil {
+			return document, err
+		}
+		doc, err := upsertDocument(f)
+		if err != nil {
+			return document, err
+		}
+		inserted, err := applyUpdate(doc, updateQuery, time.Now(), true)
+		if err != nil {
+			return document, err
+		}
+		if _, ok := lookupKey(inserted, "_id"); !ok {
+			inserted = withID(inserted, primitive.NewObjectID())
+		}
+		if err := r.insert(inserted); err != nil {
+			return document, err
+		}
+
+		return r.returned(nil, inserted, o)
+	}
+
+	old := r.documents[idx[0]]
+	updated, err := applyUpdate(cloneDocument(old), updateQuery, time.Now(), false)
+	if err != nil {
+		return document, err
+	}
+	r.documents[idx[0]] = updated
+
+	return r.returned(old, updated, o)
+}
+
+// returned decodes the document returned by a find-and-modify operation: before, nil after an upsert, or after when
+// the options return the document after the change.
+func (r *MemoryRepository[T]) returned(before, after bson.D, o *queryOpts) (T, error) {
+
+	if o.returnDocument() == options.After {
+		return r.decodeProjected(after, o)
+	}
+	if before == nil {
+		var zero T
+		return zero, mongo.ErrNoDocuments
+	}
+
+	return r.decodeProjected(before, o)
+}
+
+// ReplaceOne replaces a single document in the repository. The replacement keeps the ID of the replaced document and
+// must either have the same ID or none at all.
+func (r *MemoryRepository[T]) ReplaceOne(
+	ctx context.Context,
+	filter interface{},
+	replacement T,
+	opts ...QueryOptsFunc,
+) (T, error) {
+
+	op := &Operation{Kind: "ReplaceOne", Filter: filter, Documents: []interface{}{replacement}}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (T, error) {
+		replacement, err := modelOf[T](op)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+
+		return r.replaceOne(ctx, op.Filter, replacement, opts...)
+	})
+}
+
+func (r *MemoryRepository[T]) replaceOne(
+	_ context.Context,
+	filter interface{},
+	replacement T,
+	opts ...QueryOptsFunc,
+) (_ T, err error) {
+
+	defer r.wrapErr(&err, "ReplaceOne", filter)
+
+	var document T
+
+	o, err := newQueryOpts("ReplaceOne", opts, replaceOneSupported...)
+	if err != nil {
+		return document, err
+	}
+
+	runHooks(replacement, onReplace)
+
+	doc, err := r.encode(replacement)
+	if err != nil {
+		return document, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	idx, err := r.query(filter, o)
+	if err != nil {
+		return document, err
+	}
+	if len(idx) == 0 {
+		if o.upsert == nil || !*o.upsert {
+			return document, mongo.ErrNoDocuments
+		}
+
+		// As on the server, the inserted document takes the _id of the filter when it has none.
+		f, err := r.normalize(filter)
+		if err != nil {
+			return document, err
+		}
+		if _, ok := lookupKey(doc, "_id"); !ok {
+			id, ok := lookupKey(f, "_id")
+			if _, isOp := id.(bson.D); !ok || isOp {
+				id = primitive.NewObjectID()
+			}
+			doc = withID(doc, id)
+		}
+		if err := r.insert(doc); err != nil {
+			return document, err
+		}
+
+		return r.returned(nil, doc, o)
+	}
+
+	old := r.documents[idx[0]]
+	id, _ := lookupKey(old, "_id")
+	if newID, ok := lookupKey(doc, "_id"); ok && !valuesEqual(id, newID) {
+		return document, fmt.Errorf("the _id field cannot be changed from %v to %v", id, newID)
+	}
+
+	replaced := withID(doc, id)
+	r.documents[idx[0]] = replaced
+
+	return r.returned(old, replaced, o)
+}
+
+// UpdateByID updates the document with the given ID. See UpdateOne.
+func (r *MemoryRepository[T]) UpdateByID(
+	ctx context.Context,
+	id interface{},
+	update interface{},
+	opts ...QueryOptsFunc,
+) (T, error) {
+
+	return r.UpdateOne(ctx, idFilter(id), update, opts...)
+}
+
+// ReplaceByID replaces the document with the given ID. See ReplaceOne.
+func (r *MemoryRepository[T]) ReplaceByID(
+	ctx context.Context,
+	id interface{},
+	replacement T,
+	opts ...QueryOptsFunc,
+) (T, error) {
+
+	return r.ReplaceOne(ctx, idFilter(id), replacement, opts...)
+}
+
+// UpsertOne updates the first document matching filter with the fields of document, or inserts document when none
+// matches. As with BaseRepository, OnCreate and OnUpdate are invoked on document, its _id and createdAt fields are only
+// written on insert and document then holds the stored document.
+func (r *MemoryRepository[T]) UpsertOne(
+	ctx context.Context,
+	filter interface{},
+	document T,
+) (*UpsertResult[T], error) {
+
+	op := &Operation{Kind: "UpsertOne", Filter: filter, Documents: []interface{}{document}}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*UpsertResult[T], error) {
+		document, err := modelOf[T](op)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.upsertOne(ctx, op.Filter, document)
+	})
+}
+
+func (r *MemoryRepository[T]) upsertOne(
+	_ context.Context,
+	filter interface{},
+	document T,
+) (_ *UpsertResult[T], err error) {
+
+	defer r.wrapErr(&err, "UpsertOne", filter)
+
+	update, err := upsertUpdate(r.registry, r.discriminator, document, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.upsert(filter, update, document)
+}
+
+// UpsertByID updates the document with the given ID with the fields of document, or inserts document with that ID
+// when it does not exist. The _id of document is ignored and, once the upsert is done, set to id. A hex string id
+// matches the ObjectID it encodes as well, which an inserted document then takes.
+func (r *MemoryRepository[T]) UpsertByID(
+	ctx context.Context,
+	id interface{},
+	document T,
+) (*UpsertResult[T], error) {
+
+	op := &Operation{Kind: "UpsertByID", Filter: idFilter(id), Documents: []interface{}{document}}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*UpsertResult[T], error) {
+		document, err := modelOf[T](op)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.upsertByID(op.Filter, id, document)
+	})
+}
+
+func (r *MemoryRepository[T]) upsertByID(
+	filter interface{},
+	id interface{},
+	document T,
+) (_ *UpsertResult[T], err error) {
+
+	defer r.wrapErr(&err, "UpsertByID", filter)
+
+	update, err := upsertUpdate(r.registry, r.discriminator, document, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.upsert(filter, insertWithID(update, id), document)
+}
+
+func (r *MemoryRepository[T]) upsert(filter interface{}, update bson.D, document T) (*UpsertResult[T], error) {
+
+	f, err := r.normalize(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	idx, err := r.match(f, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored bson.D
+	inserted := len(idx) == 0
+	if !inserted {
+		if stored, err = applyUpdate(cloneDocument(r.documents[idx[0]]), update, time.Now(), false); err != nil {
+			return nil, err
+		}
+		r.documents[idx[0]] = stored
+	} else {
+		doc, err := upsertDocument(f)
+		if err != nil {
+			return nil, err
+		}
+		if stored, err = applyUpdate(doc, update, time.Now(), true); err != nil {
+			return nil, err
+		}
+		if _, ok := lookupKey(stored, "_id"); !ok {
+			stored = withID(stored, primitive.NewObjectID())
+		}
+		if err := r.insert(stored); err != nil {
+			return nil, err
+		}
+	}
+
+	return upsertResult(r.registry, r.discriminator, stored, inserted, document)
+}
+
+// Delete deletes multiple documents from the repository.
+func (r *MemoryRepository[T]) Delete(
+	ctx context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) (int64, error) {
+
+	op := &Operation{Kind: "Delete", Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (int64, error) {
+		return r.deleteMany(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *MemoryRepository[T]) deleteMany(
+	_ context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) (_ int64, err error) {
+
+	defer r.wrapErr(&err, "Delete", filter)
+
+	if _, err := newQueryOpts("Delete", opts, deleteSupported...); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	idx, err := r.match(filter, 0)
+	if err != nil {
+		return 0, err
+	}
+	r.remove(idx)
+
+	return int64(len(idx)), nil
+}
+
+// UpdateMany updates all the documents matching filter. See BaseRepository.UpdateMany.
+func (r *MemoryRepository[T]) UpdateMany(
+	ctx context.Context,
+	filter interface{},
+	update interface{},
+	opts ...QueryOptsFunc,
+) (*UpdateResult, error) {
+
+	op := &Operation{Kind: "UpdateMany", Filter: filter, Update: update}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*UpdateResult, error) {
+		return r.updateMany(ctx, op.Filter, op.Update, opts...)
+	})
+}
+
+func (r *MemoryRepository[T]) updateMany(
+	_ context.Context,
+	filter interface{},
+	update interface{},
+	opts ...QueryOptsFunc,
+) (_ *UpdateResult, err error) {
+
+	defer r.wrapErr(&err, "UpdateMany", filter)
+
+	if _, err := newQueryOpts("UpdateMany", opts, updateManySupported...); err != nil {
+		return nil, err
+	}
+
+	var updateQuery bson.D
+	switch u := update.(type) {
+	case bson.M:
+		updateQuery, err = r.normalizeUpdate(withUpdatedAt(u))
+	case UpdateBuilder:
+		var built bson.D
+		if built, err = buildUpdate(u); err == nil {
+			updateQuery, err = r.normalizeUpdate(built)
+		}
+	default:
+		return nil, fmt.Errorf("%w: must be a bson.M or an UpdateBuilder, got %T", ErrInvalidUpdate, update)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	idx, err := r.match(filter, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Documents are only replaced once all the updates succeeded, so that a failing update leaves none applied.
+	now := time.Now()
+	updated := make([]bson.D, len(idx))
+	for n, i := range idx {
+		if updated[n], err = applyUpdate(cloneDocument(r.documents[i]), updateQuery, now, false); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &UpdateResult{MatchedCount: int64(len(idx))}
+	for n, i := range idx {
+		if !reflect.DeepEqual(r.documents[i], updated[n]) {
+			res.ModifiedCount++
+		}
+		r.documents[i] = updated[n]
+	}
+
+	return res, nil
+}
+
+// DeleteOne deletes the first document matching filter.
+func (r *MemoryRepository[T]) DeleteOne(
+	ctx context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) (*DeleteResult, error) {
+
+	op := &Operation{Kind: "DeleteOne", Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*DeleteResult, error) {
+		return r.deleteOne(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *MemoryRepository[T]) deleteOne(
+	_ context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) (_ *DeleteResult, err error) {
+
+	defer r.wrapErr(&err, "DeleteOne", filter)
+
+	if _, err := newQueryOpts("DeleteOne", opts, deleteSupported...); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	idx, err := r.match(filter, 1)
+	if err != nil {
+		return nil, err
+	}
+	r.remove(idx)
+
+	return &DeleteResult{DeletedCount: int64(len(idx))}, nil
+}
+
+// DeleteByID deletes the document with the given ID.
+func (r *MemoryRepository[T]) DeleteByID(ctx context.Context, id interface{}) (*DeleteResult, error) {
+
+	return r.DeleteOne(ctx, idFilter(id))
+}
+
+// FindOneAndDelete deletes the first document matching filter, in the order given by WithSort, and returns it.
+func (r *MemoryRepository[T]) FindOneAndDelete(
+	ctx context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) (T, error) {
+
+	op := &Operation{Kind: "FindOneAndDelete", Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (T, error) {
+		return r.findOneAndDelete(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *MemoryRepository[T]) findOneAndDelete(
+	_ context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) (_ T, err error) {
+
+	defer r.wrapErr(&err, "FindOneAndDelete", filter)
+
+	var document T
+
+	o, err := newQueryOpts("FindOneAndDelete", opts, findOneAndDeleteSupported...)
+	if err != nil {
+		return document, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	idx, err := r.query(filter, o)
+	if err != nil {
+		return document, err
+	}
+	if len(idx) == 0 {
+		return document, mongo.ErrNoDocuments
+	}
+
+	deleted := r.documents[idx[0]]
+	r.remove(idx[:1])
+
+	return r.decodeProjected(deleted, o)
+}
+
+// remove deletes the documents at the given indexes.
+func (r *MemoryRepository[T]) remove(idx []int) {
+
+	deleted := make(map[int]struct{}, len(idx))
+	for _, i := range idx {
+		deleted[i] = struct{}{}
+	}
+
+	kept := r.documents[:0]
+	for i, doc := range r.documents {
+		if _, ok := deleted[i]; !ok {
+			kept = append(kept, doc)
+		}
+	}
+	r.documents = kept
+}
+
+// Aggregate runs an aggregation pipeline on the repository and decodes the resulting documents into result, which
+// must be a pointer to a slice. Only the $match, $sort, $skip, $limit and $count stages are supported.
+func (r *MemoryRepository[T]) Aggregate(ctx context.Context, pipeline mongo.Pipeline, result interface{}) error {
+
+	op := &Operation{Kind: "Aggregate", Pipeline: pipeline}
+	return interceptErr(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) error {
+		p, err := pipelineOf(op.Pipeline)
+		if err != nil {
+			return err
+		}
+
+		return r.aggregateInto(ctx, p, result)
+	})
+}
+
+func (r *MemoryRepository[T]) aggregateInto(
+	_ context.Context,
+	pipeline mongo.Pipeline,
+	result interface{},
+) (err error) {
+
+	defer r.wrapErr(&err, "Aggregate", nil)
+
+	rv := reflect.ValueOf(result)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("result argument must be a pointer to a slice, but was a %T", result)
+	}
+
+	docs, err := r.aggregate(pipeline)
+	if err != nil {
+		return err
+	}
+
+	// Decode the documents through a wrapper struct so that result is filled by the registry like a cursor would.
+	wrapper := reflect.New(reflect.StructOf([]reflect.StructField{
+		{Name: "Documents", Type: rv.Elem().Type(), Tag: `bson:"documents"`},
+	}))
+
+	arr := make(bson.A, len(docs))
+	for i, doc := range docs {
+		arr[i] = doc
+	}
+
+	raw, err := bson.Marshal(bson.D{{Key: "documents", Value: arr}})
+	if err != nil {
+		return err
+	}
+	if err := bson.UnmarshalWithRegistry(r.registry, raw, wrapper.Interface()); err != nil {
+		return err
+	}
+
+	rv.Elem().Set(wrapper.Elem().Field(0))
+	return nil
+}
+
+// AggregateIter runs an aggregation pipeline on the repository and returns an iterator over the resulting documents,
+// decoded as T. Only the $match, $sort, $skip, $limit and $count stages are supported.
+func (r *MemoryRepository[T]) AggregateIter(
+	ctx context.Context,
+	pipeline mongo.Pipeline,
+	opts ...QueryOptsFunc,
+) iter.Seq2[T, error] {
+
+	op := &Operation{Kind: "AggregateIter", Pipeline: pipeline}
+	return interceptSeq(ctx, r.interceptors, op,
+		func(ctx context.Context, op *Operation) (iter.Seq2[T, error], error) {
+			p, err := pipelineOf(op.Pipeline)
+			if err != nil {
+				return nil, err
+			}
+
+			return r.aggregateIter(ctx, p, opts...), nil
+		})
+}
+
+func (r *MemoryRepository[T]) aggregateIter(
+	_ context.Context,
+	pipeline mongo.Pipeline,
+	opts ...QueryOptsFunc,
+) iter.Seq2[T, error] {
+
+	return func(yield func(T, error) bool) {
+		var zero T
+		yield = r.wrapYield(yield, "AggregateIter", nil)
+
+		if _, err := newQueryOpts("AggregateIter", opts, aggregateSupported...); err != nil {
+			yield(zero, err)
+			return
+		}
+
+		docs, err := r.aggregate(pipeline)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		for _, doc := range docs {
+			document, err := r.decode(doc)
+			if !yield(document, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// aggregate runs pipeline on a snapshot of the documents.
+func (r *MemoryRepository[T]) aggregate(pipeline mongo.Pipeline) ([]bson.D, error) {
+
+	r.mu.RLock()
+	docs := make([]bson.D, len(r.documents))
+	for i, doc := range r.documents {
+		docs[i] = cloneDocument(doc)
+	}
+	r.mu.RUnlock()
+
+	for _, stage := range pipeline {
+		// Normalize the stage so that its values have the types they would have on the server.
+		stage, err := r.normalize(stage)
+		if err != nil {
+			return nil, err
+		}
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("a pipeline stage specification object must contain exactly one field")
+		}
+
+		if docs, err = r.runStage(docs, stage[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	return docs, nil
+}
+
+func (r *MemoryRepository[T]) runStage(docs []bson.D, stage bson.E) ([]bson.D, error) {
+
+	switch stage.Key {
+	case "$match":
+		filter, err := r.normalize(stage.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		var matched []bson.D
+		for _, doc := range docs {
+			ok, err := matchDocument(doc, filter)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matched = append(matched, doc)
+			}
+		}
+		return matched, nil
+
+	case "$sort":
+		spec, err := r.normalize(stage.Value)
+		if err != nil {
+			return nil, err
+		}
+		return sortDocuments(docs, spec)
+
+	case "$skip", "$limit":
+		f, ok := toFloat(stage.Value)
+		if !ok || f < 0 {
+			return nil, fmt.Errorf("%s must be a non-negative number", stage.Key)
+		}
+
+		n := int(f)
+		if n > len(docs) {
+			n = len(docs)
+		}
+		if stage.Key == "$skip" {
+			return docs[n:], nil
+		}
+		return docs[:n], nil
+
+	case "$count":
+		field, ok := stage.Value.(string)
+		if !ok || field == "" {
+			return nil, fmt.Errorf("the count field must be a non-empty string")
+		}
+		if len(docs) == 0 {
+			return nil, nil
+		}
+		return []bson.D{{{Key: field, Value: int32(len(docs))}}}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported pipeline stage %s", stage.Key)
+	}
+}
+
+func sortDocuments(docs []bson.D, spec bson.D) ([]bson.D, error) {
+
+	idx := make([]int, len(docs))
+	for i := range idx {
+		idx[i] = i
+	}
+
+	if err := sortIndexes(idx, docs, spec); err != nil {
+		return nil, err
+	}
+
+	sorted := make([]bson.D, len(docs))
+	for i, j := range idx {
+		sorted[i] = docs[j]
+	}
+
+	return sorted, nil
+}
+
+// sortIndexes sorts idx, indexes of docs, by the sort specification spec.
+func sortIndexes(idx []int, docs []bson.D, spec bson.D) error {
+
+	if len(spec) == 0 {
+		return fmt.Errorf("$sort stage must have at least one sort key")
+	}
+
+	dirs := make([]int, len(spec))
+	for i, s := range spec {
+		f, ok := toFloat(s.Value)
+		if !ok || (f != 1 && f != -1) {
+			return fmt.Errorf("$sort key ordering must be 1 (for ascending) or -1 (for descending)")
+		}
+		dirs[i] = int(f)
+	}
+
+	sort.SliceStable(idx, func(i, j int) bool {
+		for k, s := range spec {
+			a, _ := lookupKeyPath(docs[idx[i]], s.Key)
+			b, _ := lookupKeyPath(docs[idx[j]], s.Key)
+			if c := compareValues(a, b); c != 0 {
+				return c*dirs[k] < 0
+			}
+		}
+		return false
+	})
+
+	return nil
+}
+
+// project applies the projection spec to doc, either including or excluding the listed fields.
+func project(doc bson.D, spec bson.D) bson.D {
+
+	include := false
+	for _, e := range spec {
+		if e.Key != "_id" && truthy(e.Value) {
+			include = true
+		}
+	}
+
+	if !include {
+		var v interface{} = cloneDocument(doc)
+		for _, e := range spec {
+			if !truthy(e.Value) {
+				v = unsetPath(v, strings.Split(e.Key, "."))
+			}
+		}
+		return v.(bson.D)
+	}
+
+	var v interface{} = bson.D{}
+	if id, ok := lookupKey(doc, "_id"); ok {
+		if keep, ok := lookupKey(spec, "_id"); !ok || truthy(keep) {
+			v = bson.D{{Key: "_id", Value: id}}
+		}
+	}
+	for _, e := range spec {
+		if e.Key == "_id" || !truthy(e.Value) {
+			continue
+		}
+		if value, ok := lookupKeyPath(doc, e.Key); ok {
+			v, _ = setPath(v, strings.Split(e.Key, "."), cloneValue(value))
+		}
+	}
+
+	return v.(bson.D)
+}
+
+func lookupKeyPath(doc bson.D, path string) (interface{}, bool) {
+
+	values := lookupPath(doc, strings.Split(path, "."))
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	return values[0], true
+}
+
+// insert stores doc, which must hold an _id, rejecting duplicated IDs like the server's unique _id index.
+func (r *MemoryRepository[T]) insert(doc bson.D) error {
+
+	id, _ := lookupKey(doc, "_id")
+	for _, existing := range r.documents {
+		if other, _ := lookupKey(existing, "_id"); valuesEqual(id, other) {
+			msg := fmt.Sprintf("E11000 duplicate key error collection: memory index: _id_ dup key: { _id: %v }", id)
+			raw, _ := bson.Marshal(bson.D{
+				{Key: "code", Value: 11000},
+				{Key: "errmsg", Value: msg},
+				{Key: "keyPattern", Value: bson.D{{Key: "_id", Value: 1}}},
+				{Key: "keyValue", Value: bson.D{{Key: "_id", Value: id}}},
+			})
+
+			return mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: msg, Raw: raw}}}
+		}
+	}
+
+	r.documents = append(r.documents, doc)
+	return nil
+}
+
+// query returns the indexes of the documents matching filter, sorted, skipped and limited as configured by o.
+func (r *MemoryRepository[T]) query(filter interface{}, o *queryOpts) ([]int, error) {
+
+	idx, err := r.match(filter, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.sort != nil {
+		spec, err := r.normalize(o.sort)
+		if err != nil {
+			return nil, err
+		}
+		if err := sortIndexes(idx, r.documents, spec); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.skip != nil {
+		skip := int(*o.skip)
+		if skip > len(idx) {
+			skip = len(idx)
+		}
+		idx = idx[skip:]
+	}
+
+	// As with the server, a negative limit is taken as its absolute value and 0 means no limit.
+	if o.limit != nil && *o.limit != 0 {
+		limit := int(*o.limit)
+		if limit < 0 {
+			limit = -limit
+		}
+		if limit < len(idx) {
+			idx = idx[:limit]
+		}
+	}
+
+	return idx, nil
+}
+
+// decodeProjected decodes doc after applying the projection configured by o, if any.
+func (r *MemoryRepository[T]) decodeProjected(doc bson.D, o *queryOpts) (T, error) {
+
+	if o.projection == nil {
+		return r.decode(doc)
+	}
+
+	spec, err := r.normalize(o.projection)
+	if err != nil {
+		var document T
+		return document, err
+	}
+
+	return r.decode(project(doc, spec))
+}
+
+// match returns the indexes of the documents matching filter, up to limit when it is positive.
+func (r *MemoryRepository[T]) match(filter interface{}, limit int) ([]int, error) {
+
+	f, err := r.normalize(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var idx []int
+	for i, doc := range r.documents {
+		ok, err := matchDocument(doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		idx = append(idx, i)
+		if limit > 0 && len(idx) == limit {
+			break
+		}
+	}
+
+	return idx, nil
+}
+
+// toStored encodes document as it would be stored by the server, with an _id.
+func (r *MemoryRepository[T]) toStored(document T) (bson.D, error) {
+
+	doc, err := r.encode(document)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := lookupKey(doc, "_id"); !ok {
+		doc = withID(doc, primitive.NewObjectID())
+	}
+
+	return doc, nil
+}
+
+// encode encodes document with the repository registry, stamped with the discriminator value when the repository has
+// one.
+func (r *MemoryRepository[T]) encode(document T) (bson.D, error) {
+
+	raw, err := marshalDocument(r.registry, r.discriminator, document)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// normalize encodes v with the repository registry and decodes it back as a bson.D, so that filters and updates are
+// compared with the values actually stored.
+func (r *MemoryRepository[T]) normalize(v interface{}) (bson.D, error) {
+
+	if v == nil {
+		return nil, mongo.ErrNilDocument
+	}
+
+	raw, err := bson.MarshalWithRegistry(r.registry, v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// normalizeUpdate returns update as a bson.D, with the values it writes to encrypted fields encrypted.
+func (r *MemoryRepository[T]) normalizeUpdate(update interface{}) (bson.D, error) {
+
+	sealed, err := r.encryption.sealUpdate(r.registry, update)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.normalize(sealed)
+}
+
+func (r *MemoryRepository[T]) decode(doc bson.D) (T, error) {
+
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		var document T
+		return document, err
+	}
+
+	return decodeDocument[T](rawDecoder{raw: raw, registry: r.registry}, r.discriminator)
+}
+
+// withID returns doc with its _id set to id, as the first field.
+func withID(doc bson.D, id interface{}) bson.D {
+
+	res := bson.D{{Key: "_id", Value: id}}
+	for _, e := range doc {
+		if e.Key != "_id" {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// cloneDocument returns a deep copy of doc, so that stored documents are never shared.
+func cloneDocument(doc bson.D) bson.D {
+
+	return cloneValue(doc).(bson.D)
+}
+
+func cloneValue(v interface{}) interface{} {
+
+	switch c := v.(type) {
+	case bson.D:
+		res := make(bson.D, len(c))
+		for i, e := range c {
+			res[i] = bson.E{Key: e.Key, Value: cloneValue(e.Value)}
+		}
+		return res
+	case bson.A:
+		res := make(bson.A, len(c))
+		for i, e := range c {
+			res[i] = cloneValue(e)
+		}
+		return res
+	default:
+		return v
+	}
+}
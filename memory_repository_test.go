@@ -0,0 +1,759 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/pmatteo/friendlymongo/filter"
+	"github.com/pmatteo/friendlymongo/update"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func newMemoryArtworkRepo(t *testing.T) *fm.MemoryRepository[*artwork] {
+	r := fm.NewMemoryRepository(new(artwork))
+
+	_, err := r.InsertMany(context.Background(), []*artwork{
+		{Title: "The Pillars of Society", Artist: "Grosz", Year: 1926, Price: 199.99, Tags: []string{"painting", "satire"}},
+		{Title: "Melancholy III", Artist: "Munch", Year: 1902, Price: 280.00, Tags: []string{"woodcut", "Expressionism"}},
+		{Title: "Dancer", Artist: "Miro", Year: 1925, Price: 76.04, Tags: []string{"oil", "Surrealism", "painting"}},
+		{Title: "The Great Wave off Kanagawa", Artist: "Hokusai", Price: 167.30, Tags: []string{"woodblock", "ukiyo-e"}},
+	})
+	require.NoError(t, err)
+
+	return r
+}
+
+func titlesOf(artworks []*artwork) []string {
+	var titles []string
+	for _, a := range artworks {
+		titles = append(titles, a.Title)
+	}
+	return titles
+}
+
+func TestMemoryRepository_Interface(t *testing.T) {
+	t.Parallel()
+
+	var r fm.MongoRepository[*customModel] = fm.NewMemoryRepository(new(customModel))
+
+	model := newCustomModel("in memory", "memory@test.com", true, basicAddress)
+	require.NoError(t, r.InsertOne(context.Background(), model))
+
+	assert.False(t, model.ID.IsZero())
+	assert.False(t, model.CreatedAt.IsZero())
+
+	found, err := r.FindOne(context.Background(), bson.M{"email": "memory@test.com"})
+	require.NoError(t, err)
+	assert.Equal(t, model.ID, found.ID)
+	assert.Equal(t, basicAddress, found.Address)
+	assert.NotSame(t, model, found)
+}
+
+func TestMemoryRepository_QueryOperators(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+
+	tests := []struct {
+		name   string
+		filter interface{}
+		want   []string
+	}{
+		{"empty", bson.M{}, []string{"The Pillars of Society", "Melancholy III", "Dancer", "The Great Wave off Kanagawa"}},
+		{"eq", bson.M{"artist": "Munch"}, []string{"Melancholy III"}},
+		{"eq array element", bson.M{"tags": "painting"}, []string{"The Pillars of Society", "Dancer"}},
+		{"gt", bson.M{"year": bson.M{"$gt": 1920}}, []string{"The Pillars of Society", "Dancer"}},
+		{"gte and lt", bson.M{"price": bson.M{"$gte": 76.04, "$lt": 180}}, []string{"Dancer", "The Great Wave off Kanagawa"}},
+		{"in", bson.M{"artist": bson.M{"$in": bson.A{"Miro", "Hokusai"}}}, []string{"Dancer", "The Great Wave off Kanagawa"}},
+		{"nin", bson.M{"tags": bson.M{"$nin": bson.A{"painting", "woodcut"}}}, []string{"The Great Wave off Kanagawa"}},
+		{"and", bson.M{"$and": bson.A{bson.M{"tags": "painting"}, bson.M{"year": bson.M{"$lt": 1926}}}}, []string{"Dancer"}},
+		{"or", bson.M{"$or": bson.A{bson.M{"artist": "Munch"}, bson.M{"year": 1925}}}, []string{"Melancholy III", "Dancer"}},
+		{"exists", bson.M{"year": bson.M{"$exists": false}}, []string{"The Great Wave off Kanagawa"}},
+		{"regex", bson.M{"tags": bson.M{"$regex": "^wood"}}, []string{"Melancholy III", "The Great Wave off Kanagawa"}},
+		{"regex options", bson.M{"title": primitive.Regex{Pattern: "^the", Options: "i"}}, []string{"The Pillars of Society", "The Great Wave off Kanagawa"}},
+		{"not", bson.M{"year": bson.M{"$not": bson.M{"$gt": 1920}}}, []string{"Melancholy III", "The Great Wave off Kanagawa"}},
+		{"size", bson.M{"tags": bson.M{"$size": 3}}, []string{"Dancer"}},
+		{"elemMatch", bson.M{"tags": bson.M{"$elemMatch": bson.M{"$gte": "u", "$lt": "v"}}}, []string{"The Great Wave off Kanagawa"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, err := r.Find(context.Background(), tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, titlesOf(found))
+		})
+	}
+}
+
+func TestMemoryRepository_DottedPaths(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(order))
+
+	_, err := r.InsertMany(context.Background(), []*order{
+		{Code: "a", Items: []lineItem{{SKU: "x"}, {SKU: "y"}}, Shipment: &shipment{Carrier: "ups"}},
+		{Code: "b", Items: []lineItem{{SKU: "z"}}, Shipment: &shipment{Carrier: "dhl"}},
+	})
+	require.NoError(t, err)
+
+	found, err := r.FindOne(context.Background(), bson.M{"shipment.carrier": "dhl"})
+	require.NoError(t, err)
+	assert.Equal(t, "b", found.Code)
+
+	found, err = r.FindOne(context.Background(), bson.M{"items.sku": "y"})
+	require.NoError(t, err)
+	assert.Equal(t, "a", found.Code)
+
+	found, err = r.FindOne(context.Background(), bson.M{"items.0.sku": "z"})
+	require.NoError(t, err)
+	assert.Equal(t, "b", found.Code)
+
+	found, err = r.FindOne(context.Background(), bson.M{"items": bson.M{"$elemMatch": bson.M{"sku": "x"}}})
+	require.NoError(t, err)
+	assert.Equal(t, "a", found.Code)
+
+	_, err = r.FindOne(context.Background(), bson.M{"items.sku": "w"})
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+}
+
+func TestMemoryRepository_UpdateOperators(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+	before := time.Now().Add(-time.Second)
+
+	old, err := r.UpdateOne(context.Background(), bson.M{"artist": "Munch"}, bson.M{
+		"$set":  bson.M{"title": "Melancholy"},
+		"$inc":  bson.M{"year": 1},
+		"$push": bson.M{"tags": bson.M{"$each": bson.A{"print", "norway"}}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Melancholy III", old.Title)
+
+	updated, err := r.FindOne(context.Background(), bson.M{"artist": "Munch"})
+	require.NoError(t, err)
+	assert.Equal(t, "Melancholy", updated.Title)
+	assert.Equal(t, 1903, updated.Year)
+	assert.Equal(t, []string{"woodcut", "Expressionism", "print", "norway"}, updated.Tags)
+	assert.True(t, updated.UpdatedAt.After(before))
+
+	_, err = r.UpdateOne(context.Background(), bson.M{"artist": "Munch"}, bson.M{"$unset": bson.M{"year": ""}})
+	require.NoError(t, err)
+
+	found, err := r.Find(context.Background(), bson.M{"year": bson.M{"$exists": false}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Melancholy", "The Great Wave off Kanagawa"}, titlesOf(found))
+
+	_, err = r.UpdateOne(context.Background(), bson.M{"artist": "Munch"}, bson.M{"$inc": bson.M{"title": 1}})
+	assert.Error(t, err)
+
+	_, err = r.UpdateOne(context.Background(), bson.M{"artist": "Nobody"}, bson.M{"$set": bson.M{"year": 1}})
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+}
+
+func TestMemoryRepository_UpdateWithModel(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(customModel))
+
+	model := newCustomModel("to update with model", "memory.update@test.com", false, basicAddress)
+	require.NoError(t, r.InsertOne(context.Background(), model))
+
+	old, err := r.UpdateOne(
+		context.Background(),
+		bson.M{"email": "memory.update@test.com"},
+		newCustomModel("updated with model", "memory.update@test.com", true, basicAddress),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, model.ID, old.ID)
+	assert.False(t, old.Active)
+
+	updated, err := r.FindOne(context.Background(), bson.M{"_id": model.ID})
+	require.NoError(t, err)
+	assert.True(t, updated.Active)
+	assert.Equal(t, "updated with model", updated.Name)
+}
+
+func TestMemoryRepository_ReplaceAndDelete(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+
+	original, err := r.FindOne(context.Background(), bson.M{"artist": "Miro"})
+	require.NoError(t, err)
+
+	replacement := &artwork{Title: "Dancer II", Artist: "Miro", Year: 1925}
+	before, err := r.ReplaceOne(context.Background(), bson.M{"_id": original.ID}, replacement)
+	require.NoError(t, err)
+	assert.Equal(t, "Dancer", before.Title)
+
+	replaced, err := r.FindOne(context.Background(), bson.M{"_id": original.ID})
+	require.NoError(t, err)
+	assert.Equal(t, "Dancer II", replaced.Title)
+	assert.Empty(t, replaced.Tags)
+
+	_, err = r.ReplaceOne(context.Background(), bson.M{"artist": "Nobody"}, replacement)
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+
+	deleted, err := r.Delete(context.Background(), bson.M{"tags": "painting"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	all, err := r.Find(context.Background(), bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Melancholy III", "Dancer II", "The Great Wave off Kanagawa"}, titlesOf(all))
+}
+
+func TestMemoryRepository_DuplicateID(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(customModel))
+
+	model := newCustomModel("duplicated", "dup@test.com", false, nil)
+	require.NoError(t, r.InsertOne(context.Background(), model))
+
+	err := r.InsertOne(context.Background(), model)
+	assert.True(t, mongo.IsDuplicateKeyError(err))
+}
+
+func TestMemoryRepository_Aggregate(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+
+	pipeline := fm.NewStageBuilder().
+		Match("stg1", bson.M{"tags": bson.M{"$in": bson.A{"painting", "woodcut"}}}).
+		Sort("stg2", bson.M{"price": -1}).
+		Build()
+
+	var result []*artwork
+	require.NoError(t, r.Aggregate(context.Background(), pipeline, &result))
+	assert.Equal(t, []string{"Melancholy III", "The Pillars of Society", "Dancer"}, titlesOf(result))
+
+	var count []bson.M
+	pipeline = mongo.Pipeline{{{Key: "$count", Value: "total"}}}
+	require.NoError(t, r.Aggregate(context.Background(), pipeline, &count))
+	assert.Equal(t, []bson.M{{"total": int32(4)}}, count)
+
+	pipeline = mongo.Pipeline{{{Key: "$group", Value: bson.M{"_id": "$artist"}}}}
+	assert.Error(t, r.Aggregate(context.Background(), pipeline, &count))
+}
+
+func TestMemoryRepository_InsertManyResult(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(artwork))
+	ctx := context.Background()
+
+	id := primitive.NewObjectID()
+	artworks := func() []*artwork {
+		return []*artwork{{Title: "a"}, {BaseModel: fm.BaseModel{ID: id}}, {Title: "b"}, {BaseModel: fm.BaseModel{ID: id}}}
+	}
+
+	res, err := r.InsertMany(ctx, artworks())
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+	assert.Len(t, res.InsertedIDs, 3)
+	require.Len(t, res.Errors, 1)
+	assert.Equal(t, 3, res.Errors[0].Index)
+
+	res, err = r.InsertMany(ctx, artworks())
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+	assert.Len(t, res.InsertedIDs, 1)
+	require.Len(t, res.Errors, 1)
+	assert.Equal(t, 1, res.Errors[0].Index)
+
+	res, err = r.InsertMany(ctx, artworks(), fm.WithOrdered(false), fm.WithChunkSize(1))
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+	assert.Len(t, res.InsertedIDs, 2)
+	require.Len(t, res.Errors, 2)
+	assert.Equal(t, []int{1, 3}, []int{res.Errors[0].Index, res.Errors[1].Index})
+	assert.NotNil(t, res.InsertedIDs[2])
+}
+
+func TestMemoryRepository_Bulk(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+	ctx := context.Background()
+
+	dancer, err := r.FindOne(ctx, bson.M{"title": "Dancer"})
+	require.NoError(t, err)
+
+	res, err := r.Bulk().
+		Insert(&artwork{Title: "The Scream", Artist: "Munch"}).
+		UpdateMany(bson.M{"tags": "painting"}, bson.M{"$inc": bson.M{"year": 1}}).
+		ReplaceOne(bson.M{"title": "Melancholy III"}, &artwork{Title: "Melancholy", Artist: "Munch"}).
+		Upsert(bson.M{"title": "The Kiss"}, &artwork{Title: "The Kiss", Artist: "Klimt"}).
+		Insert(&artwork{BaseModel: fm.BaseModel{ID: dancer.ID}}).
+		DeleteOne(bson.M{"artist": "Hokusai"}).
+		Execute(ctx, fm.WithOrdered(false))
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+
+	assert.Equal(t, int64(1), res.InsertedCount)
+	assert.Equal(t, int64(3), res.MatchedCount)
+	assert.Equal(t, int64(3), res.ModifiedCount)
+	assert.Equal(t, int64(1), res.UpsertedCount)
+	assert.Equal(t, int64(1), res.DeletedCount)
+	assert.Len(t, res.InsertedIDs, 1)
+	assert.Contains(t, res.UpsertedIDs, 3)
+	require.Len(t, res.Errors, 1)
+	assert.Equal(t, 4, res.Errors[0].Index)
+
+	found, err := r.Find(ctx, bson.M{}, fm.WithSort(bson.M{"title": 1}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Dancer", "Melancholy", "The Kiss", "The Pillars of Society", "The Scream"}, titlesOf(found))
+	assert.Equal(t, 1926, found[0].Year)
+
+	res, err = r.Bulk().
+		DeleteMany(bson.M{"artist": "Munch"}).
+		Insert(&artwork{BaseModel: fm.BaseModel{ID: dancer.ID}}).
+		DeleteMany(bson.M{}).
+		Execute(ctx)
+	assert.ErrorIs(t, err, fm.ErrDuplicateKey)
+	assert.Equal(t, int64(2), res.DeletedCount)
+
+	count, err := r.Count(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestMemoryRepository_AggregateTyped(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+	ctx := context.Background()
+
+	type titleOnly struct {
+		Title string `bson:"title"`
+	}
+
+	titles, err := fm.Aggregate[titleOnly](ctx, r, fm.NewStageBuilder().
+		Match("stg1", bson.M{"tags": "painting"}).
+		Sort("stg2", bson.M{"price": -1}))
+	require.NoError(t, err)
+	assert.Equal(t, []titleOnly{{"The Pillars of Society"}, {"Dancer"}}, titles)
+
+	first, err := cheapest(ctx, r)
+	require.NoError(t, err)
+	assert.Equal(t, "Dancer", first.Title)
+
+	_, err = fm.AggregateOne[*artwork](ctx, r, mongo.Pipeline{{{Key: "$match", Value: bson.M{"artist": "Klimt"}}}})
+	assert.ErrorIs(t, err, fm.ErrNotFound)
+
+	_, err = fm.Aggregate[bson.M](ctx, r, nil, fm.WithLet(bson.M{"x": 1}))
+	assert.Error(t, err)
+}
+
+func TestMemoryRepository_QueryOptions(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+	ctx := context.Background()
+
+	found, err := r.Find(ctx, bson.M{}, fm.WithSort(bson.D{{Key: "price", Value: -1}}), fm.WithSkip(1), fm.WithLimit(2))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"The Pillars of Society", "The Great Wave off Kanagawa"}, titlesOf(found))
+
+	cheapest, err := r.FindOne(ctx, bson.M{}, fm.WithSort(bson.M{"price": 1}), fm.WithProjection(bson.M{"title": 1}))
+	require.NoError(t, err)
+	assert.Equal(t, "Dancer", cheapest.Title)
+	assert.Empty(t, cheapest.Artist)
+	assert.False(t, cheapest.ID.IsZero())
+
+	old, err := r.UpdateOne(ctx, bson.M{"tags": "painting"}, bson.M{"$set": bson.M{"price": 1}},
+		fm.WithSort(bson.M{"year": -1}), fm.WithComment("oldest painting first"))
+	require.NoError(t, err)
+	assert.Equal(t, "The Pillars of Society", old.Title)
+
+	_, err = r.Delete(ctx, bson.M{}, fm.WithLimit(1))
+	assert.EqualError(t, err, "Delete with filter {}: option limit is not supported by Delete")
+}
+
+func TestMemoryRepository_Iterators(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+	ctx := context.Background()
+
+	var titles []string
+	for a, err := range r.FindIter(ctx, bson.M{"tags": "painting"}, fm.WithSort(bson.M{"year": 1})) {
+		require.NoError(t, err)
+		titles = append(titles, a.Title)
+	}
+	assert.Equal(t, []string{"Dancer", "The Pillars of Society"}, titles)
+
+	count := 0
+	for _, err := range r.FindIter(ctx, bson.M{}) {
+		require.NoError(t, err)
+		if count++; count == 2 {
+			break
+		}
+	}
+	assert.Equal(t, 2, count)
+
+	pipeline := mongo.Pipeline{{{Key: "$sort", Value: bson.M{"price": 1}}}, {{Key: "$limit", Value: 1}}}
+	for a, err := range r.AggregateIter(ctx, pipeline) {
+		require.NoError(t, err)
+		assert.Equal(t, "Dancer", a.Title)
+	}
+
+	for _, err := range r.FindIter(ctx, bson.M{"$where": "true"}) {
+		assert.Error(t, err)
+	}
+}
+
+func TestMemoryRepository_CountExistsDistinct(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+	ctx := context.Background()
+
+	count, err := r.Count(ctx, bson.M{"tags": "painting"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	count, err = r.Count(ctx, bson.M{}, fm.WithSkip(1), fm.WithLimit(2))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	exists, err := r.Exists(ctx, bson.M{"artist": "Hokusai"})
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = r.Exists(ctx, bson.M{"artist": "Nobody"})
+	require.NoError(t, err)
+	assert.False(t, exists)
+
+	estimated, err := r.EstimatedCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), estimated)
+
+	years, err := fm.Distinct[int](ctx, r, "year", bson.M{"year": bson.M{"$exists": true}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{1926, 1902, 1925}, years)
+
+	tags, err := fm.Distinct[string](ctx, r, "tags", bson.M{"year": bson.M{"$lt": 1926}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"woodcut", "Expressionism", "oil", "Surrealism", "painting"}, tags)
+
+	var src fm.DistinctSource = r
+	_, err = fm.Distinct[int](ctx, src, "title", bson.M{})
+	var fmErr *fm.Error
+	require.ErrorAs(t, err, &fmErr)
+	assert.Equal(t, "Distinct", fmErr.Op)
+}
+
+func TestMemoryRepository_Upsert(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+	ctx := context.Background()
+
+	res, err := r.UpsertOne(ctx, bson.M{"title": "Dancer"}, &artwork{Title: "Dancer", Artist: "Miró", Price: 80})
+	require.NoError(t, err)
+	assert.False(t, res.Inserted)
+	assert.Equal(t, "Miró", res.Document.Artist)
+	assert.Equal(t, 1925, res.Document.Year, "fields missing from the model are kept")
+
+	original, err := r.FindOne(ctx, bson.M{"title": "Melancholy III"})
+	require.NoError(t, err)
+
+	doc := &artwork{Title: "Melancholy III", Artist: "Munch", Price: 300}
+	res, err = r.UpsertByID(ctx, original.ID, doc)
+	require.NoError(t, err)
+	assert.False(t, res.Inserted)
+	assert.Equal(t, original.ID, res.Document.ID)
+	assert.Equal(t, original.CreatedAt, res.Document.CreatedAt)
+	assert.Equal(t, res.Document, doc, "the document holds the stored one")
+
+	res, err = r.UpsertOne(ctx, bson.M{"year": 1923}, &artwork{Title: "Composition VIII", Artist: "Kandinsky"})
+	require.NoError(t, err)
+	assert.True(t, res.Inserted)
+	assert.False(t, res.Document.ID.IsZero())
+	assert.False(t, res.Document.CreatedAt.IsZero())
+	assert.Equal(t, 1923, res.Document.Year, "equality conditions of the filter are inserted")
+	assert.Equal(t, "Kandinsky", res.Document.Artist)
+
+	id := primitive.NewObjectID()
+	res, err = r.UpsertByID(ctx, id, &artwork{Title: "Broadway Boogie Woogie", Artist: "Mondrian"})
+	require.NoError(t, err)
+	assert.True(t, res.Inserted)
+	assert.Equal(t, id, res.Document.ID)
+
+	res, err = r.UpsertByID(ctx, id.Hex(), &artwork{Title: "Broadway Boogie Woogie", Artist: "Mondrian", Year: 1943})
+	require.NoError(t, err)
+	assert.False(t, res.Inserted, "a hex string ID matches the ObjectID")
+	assert.Equal(t, id, res.Document.ID)
+	assert.Equal(t, 1943, res.Document.Year)
+
+	hexID := primitive.NewObjectID()
+	res, err = r.UpsertByID(ctx, hexID.Hex(), &artwork{Title: "Victory Boogie Woogie", Artist: "Mondrian"})
+	require.NoError(t, err)
+	assert.True(t, res.Inserted)
+	assert.Equal(t, hexID, res.Document.ID, "a hex string ID is inserted as an ObjectID")
+
+	count, err := r.Count(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), count)
+}
+
+func TestMemoryRepository_UpsertNested(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(order))
+	ctx := context.Background()
+
+	res, err := r.UpsertOne(ctx, bson.M{"code": "upsert"}, &order{Code: "upsert", Shipment: &shipment{Carrier: "ups"}})
+	require.NoError(t, err)
+	require.True(t, res.Inserted)
+
+	inserted := res.Document
+	require.False(t, inserted.Shipment.ID.IsZero())
+
+	o := &order{Code: "upsert", Shipment: &shipment{Carrier: "dhl"}}
+	res, err = r.UpsertOne(ctx, bson.M{"code": "upsert"}, o)
+	require.NoError(t, err)
+	assert.False(t, res.Inserted)
+	assert.Equal(t, inserted.ID, res.Document.ID)
+	assert.Equal(t, inserted.Shipment.ID, res.Document.Shipment.ID, "embedded models keep their ID")
+	assert.Equal(t, inserted.Shipment.CreatedAt, res.Document.Shipment.CreatedAt)
+	assert.Equal(t, "dhl", res.Document.Shipment.Carrier)
+	assert.Equal(t, inserted.Shipment.ID, o.Shipment.ID, "the document holds the stored one")
+}
+
+func TestMemoryRepository_UpdateWithBuilder(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(artwork))
+	ctx := context.Background()
+
+	a := &artwork{Title: "Dancer", Artist: "Miro", Year: 1925, Price: 76, Tags: []string{"oil", "draft"}}
+	require.NoError(t, r.InsertOne(ctx, a))
+
+	u := update.New().
+		Mul("price", 2).
+		Max("year", 1930).
+		Pull("tags", "draft").
+		Rename("artist", "painter")
+	updated, err := r.UpdateOne(ctx, filter.Eq("_id", a.ID), u, fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, float32(152), updated.Price)
+	assert.Equal(t, 1930, updated.Year)
+	assert.Equal(t, []string{"oil"}, updated.Tags)
+	assert.Empty(t, updated.Artist)
+
+	n, err := r.Count(ctx, bson.M{"painter": "Miro"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n, "the renamed field keeps its value")
+
+	u = update.New().Min("year", 1940).Min("price", 100)
+	updated, err = r.UpdateOne(ctx, filter.Eq("_id", a.ID), u, fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, 1930, updated.Year)
+	assert.Equal(t, float32(100), updated.Price)
+
+	u = update.New().
+		Push("tags", update.Each("b", "a", "c"), update.Sort(1), update.Slice(3)).
+		SetOnInsert("title", "never")
+	updated, err = r.UpdateOne(ctx, filter.Eq("_id", a.ID), u, fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, updated.Tags)
+	assert.Equal(t, "Dancer", updated.Title)
+
+	u = update.New().Push("tags", update.Each("x"), update.Position(1))
+	updated, err = r.UpdateOne(ctx, filter.Eq("_id", a.ID), u, fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "x", "b", "c"}, updated.Tags)
+
+	u = update.New().Pull("tags", bson.M{"$in": bson.A{"a", "b"}})
+	updated, err = r.UpdateOne(ctx, filter.Eq("_id", a.ID), u, fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "c"}, updated.Tags)
+
+	u = update.New().Set("price", 10).SetOnInsert("title", "Woman")
+	inserted, err := r.UpdateOne(ctx, filter.Eq("artist", "Miro"), u,
+		fm.WithUpsert(true), fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, "Woman", inserted.Title)
+	assert.NotEqual(t, a.ID, inserted.ID)
+
+	res, err := r.UpdateMany(ctx, bson.M{}, update.New().Inc("year", 1).AddToSet("tags", update.Each("x", "a")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.ModifiedCount)
+
+	all, err := r.Find(ctx, bson.M{}, fm.WithSort(bson.D{{Key: "price", Value: 1}}))
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, []string{"x", "a"}, all[0].Tags)
+	assert.Equal(t, []string{"x", "c", "a"}, all[1].Tags, "values already in the set are not added")
+
+	_, err = r.UpdateOne(ctx, bson.M{}, update.New().Set("tags", nil).Push("tags", "y"))
+	assert.ErrorIs(t, err, fm.ErrInvalidUpdate)
+	assert.ErrorIs(t, err, update.ErrConflict)
+
+	_, err = r.UpdateOne(ctx, bson.M{}, bson.M{"$mul": bson.M{"title": 2}})
+	assert.Error(t, err)
+}
+
+func TestMemoryRepository_UpdateManyAndDeleteOne(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+	ctx := context.Background()
+
+	update := bson.M{"$inc": bson.M{"price": 10}}
+	res, err := r.UpdateMany(ctx, bson.M{"tags": "painting"}, update)
+	require.NoError(t, err)
+	assert.Equal(t, &fm.UpdateResult{MatchedCount: 2, ModifiedCount: 2}, res)
+	assert.NotContains(t, update, "$currentDate")
+
+	updated, err := r.Find(ctx, bson.M{"tags": "painting"})
+	require.NoError(t, err)
+	assert.InDelta(t, 209.99, updated[0].Price, 0.01)
+	assert.InDelta(t, 86.04, updated[1].Price, 0.01)
+
+	// A failing update leaves every document untouched.
+	_, err = r.UpdateMany(ctx, bson.M{}, bson.M{"$inc": bson.M{"title": 1}})
+	assert.Error(t, err)
+
+	unchanged, err := r.FindOne(ctx, bson.M{"artist": "Grosz"})
+	require.NoError(t, err)
+	assert.Equal(t, "The Pillars of Society", unchanged.Title)
+
+	deleted, err := r.DeleteOne(ctx, bson.M{"tags": "painting"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted.DeletedCount)
+
+	first, err := r.FindOneAndDelete(ctx, bson.M{}, fm.WithSort(bson.D{{Key: "price", Value: -1}}))
+	require.NoError(t, err)
+	assert.Equal(t, "Melancholy III", first.Title)
+
+	rest, err := r.Find(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Dancer", "The Great Wave off Kanagawa"}, titlesOf(rest))
+
+	_, err = r.FindOneAndDelete(ctx, bson.M{"artist": "Nobody"})
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+}
+
+func TestMemoryRepository_ByID(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(customModel))
+	ctx := context.Background()
+
+	a := newCustomModel("by id", "id@test.com", true, basicAddress)
+	b := newCustomModel("other", "other@test.com", false, basicAddress)
+	_, err := r.InsertMany(ctx, []*customModel{a, b})
+	require.NoError(t, err)
+
+	found, err := r.FindByID(ctx, a.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, a.ID, found.ID)
+
+	missing := primitive.NewObjectID()
+	all, notFound, err := r.FindByIDs(ctx, []interface{}{b.ID, missing, a.ID.Hex()})
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, b.ID, all[0].ID)
+	assert.Equal(t, a.ID, all[1].ID)
+	assert.Equal(t, []interface{}{missing}, notFound)
+
+	updated, err := r.UpdateByID(ctx, a.ID, bson.M{"$set": bson.M{"name": "renamed"}})
+	require.NoError(t, err)
+	assert.Equal(t, "by id", updated.Name)
+
+	_, err = r.ReplaceByID(ctx, b.ID.Hex(), newCustomModel("replaced", "other@test.com", false, nil))
+	require.NoError(t, err)
+
+	res, err := r.DeleteByID(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), res.DeletedCount)
+
+	all, notFound, err = r.FindByIDs(ctx, []interface{}{a.ID, b.ID})
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	assert.Equal(t, "replaced", all[0].Name)
+	assert.Equal(t, []interface{}{a.ID}, notFound)
+}
+
+// slugModel is a model with string IDs.
+type slugModel struct {
+	Slug string `bson:"_id"`
+	Name string `bson:"name"`
+}
+
+func (*slugModel) OnCreate()  {}
+func (*slugModel) OnUpdate()  {}
+func (*slugModel) OnReplace() {}
+
+func TestMemoryRepository_StringIDs(t *testing.T) {
+	t.Parallel()
+
+	r := fm.NewMemoryRepository(new(slugModel))
+	ctx := context.Background()
+
+	hex := primitive.NewObjectID().Hex()
+	_, err := r.InsertMany(ctx, []*slugModel{{Slug: "first", Name: "First"}, {Slug: hex, Name: "Hex"}})
+	require.NoError(t, err)
+
+	found, err := r.FindByID(ctx, "first")
+	require.NoError(t, err)
+	assert.Equal(t, "First", found.Name)
+
+	// A string ID that happens to be a valid ObjectID hex still matches.
+	found, err = r.FindByID(ctx, hex)
+	require.NoError(t, err)
+	assert.Equal(t, "Hex", found.Name)
+
+	all, missing, err := r.FindByIDs(ctx, []interface{}{hex, "second", "first"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Hex", "First"}, []string{all[0].Name, all[1].Name})
+	assert.Equal(t, []interface{}{"second"}, missing)
+}
+
+func TestMemoryRepository_ReturnAfterAndUpsert(t *testing.T) {
+	t.Parallel()
+
+	r := newMemoryArtworkRepo(t)
+	ctx := context.Background()
+
+	after, err := r.UpdateOne(ctx, bson.M{"artist": "Munch"}, bson.M{"$set": bson.M{"price": 300}},
+		fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, float32(300), after.Price)
+
+	inserted, err := r.UpdateOne(ctx, bson.M{"title": "Small Worlds"}, bson.M{"$set": bson.M{"year": 1922}},
+		fm.WithUpsert(true), fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, "Small Worlds", inserted.Title)
+	assert.Equal(t, 1922, inserted.Year)
+	assert.False(t, inserted.ID.IsZero())
+	assert.False(t, inserted.UpdatedAt.IsZero())
+
+	upserted, err := r.UpdateOne(ctx, bson.M{"title": "Blue Rider"}, bson.M{"$set": bson.M{"year": 1903}},
+		fm.WithUpsert(true))
+	require.NoError(t, err, "an upsert returns the document after the change by default")
+	assert.Equal(t, "Blue Rider", upserted.Title)
+	assert.Equal(t, 1903, upserted.Year)
+
+	_, err = r.UpdateOne(ctx, bson.M{"title": "Red Rider"}, bson.M{"$set": bson.M{"year": 1904}},
+		fm.WithUpsert(true), fm.WithReturnDocument(options.Before))
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments, "there is no document before an insert")
+
+	id := primitive.NewObjectID()
+	replaced, err := r.ReplaceOne(ctx, bson.M{"_id": id}, &artwork{Title: "Improvisation 28"}, fm.WithUpsert(true))
+	require.NoError(t, err)
+	assert.Equal(t, id, replaced.ID)
+
+	count, err := r.Count(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), count)
+}
@@ -0,0 +1,601 @@
+package friendlymongo
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// applyUpdate applies the update operators of update to doc and returns the updated document. insert is true when
+// doc is being inserted by an upsert, so that $setOnInsert applies.
+func applyUpdate(doc bson.D, update bson.D, now time.Time, insert bool) (bson.D, error) {
+
+	if len(update) == 0 || !isOperatorDocument(update) {
+		return nil, fmt.Errorf("update document must contain only atomic modifiers")
+	}
+
+	if err := checkUpdateConflicts(update, insert); err != nil {
+		return nil, err
+	}
+
+	id, hasID := lookupKey(doc, "_id")
+
+	var v interface{} = doc
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("modifiers operate on fields but %s got %T", op.Key, op.Value)
+		}
+
+		for _, f := range fields {
+			segs := strings.Split(f.Key, ".")
+
+			var err error
+			switch op.Key {
+			case "$set":
+				v, err = setPath(v, segs, f.Value)
+			case "$unset":
+				v = unsetPath(v, segs)
+			case "$inc":
+				v, err = incPath(v, segs, f.Key, f.Value)
+			case "$mul":
+				v, err = mulPath(v, segs, f.Key, f.Value)
+			case "$min", "$max":
+				v, err = boundPath(v, segs, op.Key, f.Value)
+			case "$push":
+				v, err = pushPath(v, segs, f.Key, f.Value)
+			case "$addToSet":
+				v, err = addToSetPath(v, segs, f.Key, f.Value)
+			case "$pull":
+				v, err = pullPath(v, segs, f.Key, f.Value)
+			case "$rename":
+				v, err = renamePath(v, segs, f.Key, f.Value)
+			case "$currentDate":
+				var value interface{}
+				if value, err = currentDate(f.Value, now); err == nil {
+					v, err = setPath(v, segs, value)
+				}
+			case "$setOnInsert":
+				if insert {
+					v, err = setPath(v, segs, f.Value)
+				}
+			default:
+				return nil, fmt.Errorf("unsupported update operator %s", op.Key)
+			}
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	updated := v.(bson.D)
+	if newID, ok := lookupKey(updated, "_id"); hasID && (!ok || !valuesEqual(id, newID)) {
+		return nil, fmt.Errorf("performing an update on the path '_id' would modify the immutable field '_id'")
+	}
+
+	return updated, nil
+}
+
+// checkUpdateConflicts rejects updates modifying the same path, or a path and one of its prefixes, more than once.
+func checkUpdateConflicts(update bson.D, insert bool) error {
+
+	var paths []string
+	for _, op := range update {
+		if op.Key == "$setOnInsert" && !insert {
+			continue
+		}
+
+		if fields, ok := op.Value.(bson.D); ok {
+			for _, f := range fields {
+				paths = append(paths, f.Key)
+				if to, ok := f.Value.(string); ok && op.Key == "$rename" {
+					paths = append(paths, to)
+				}
+			}
+		}
+	}
+
+	sort.Strings(paths)
+	for i := 1; i < len(paths); i++ {
+		if paths[i] == paths[i-1] || strings.HasPrefix(paths[i], paths[i-1]+".") {
+			return fmt.Errorf("updating the path '%s' would create a conflict at '%s'", paths[i], paths[i-1])
+		}
+	}
+
+	return nil
+}
+
+func lookupKey(doc bson.D, key string) (interface{}, bool) {
+
+	for _, e := range doc {
+		if e.Key == key {
+			return e.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// setPath sets the value at the dotted path segs of v, creating the missing intermediate documents, and returns the
+// updated v.
+func setPath(v interface{}, segs []string, value interface{}) (interface{}, error) {
+
+	if len(segs) == 0 {
+		return value, nil
+	}
+
+	switch c := v.(type) {
+	case nil:
+		return setPath(bson.D{}, segs, value)
+	case bson.D:
+		for i := range c {
+			if c[i].Key == segs[0] {
+				nv, err := setPath(c[i].Value, segs[1:], value)
+				c[i].Value = nv
+				return c, err
+			}
+		}
+
+		nv, err := setPath(nil, segs[1:], value)
+		return append(c, bson.E{Key: segs[0], Value: nv}), err
+	case bson.A:
+		idx, err := strconv.Atoi(segs[0])
+		if err != nil || idx < 0 {
+			return c, fmt.Errorf("cannot create field '%s' in array", segs[0])
+		}
+
+		for len(c) <= idx {
+			c = append(c, nil)
+		}
+		nv, err := setPath(c[idx], segs[1:], value)
+		c[idx] = nv
+		return c, err
+	default:
+		return v, fmt.Errorf("cannot create field '%s' in element of type %T", segs[0], v)
+	}
+}
+
+// unsetPath removes the value at the dotted path segs of v and returns the updated v. Array elements are set to null
+// rather than removed.
+func unsetPath(v interface{}, segs []string) interface{} {
+
+	switch c := v.(type) {
+	case bson.D:
+		for i := range c {
+			if c[i].Key != segs[0] {
+				continue
+			}
+
+			if len(segs) == 1 {
+				return append(c[:i:i], c[i+1:]...)
+			}
+			c[i].Value = unsetPath(c[i].Value, segs[1:])
+			return c
+		}
+	case bson.A:
+		idx, err := strconv.Atoi(segs[0])
+		if err != nil || idx < 0 || idx >= len(c) {
+			return c
+		}
+
+		if len(segs) == 1 {
+			c[idx] = nil
+		} else {
+			c[idx] = unsetPath(c[idx], segs[1:])
+		}
+		return c
+	}
+
+	return v
+}
+
+func incPath(v interface{}, segs []string, path string, by interface{}) (interface{}, error) {
+
+	if _, ok := toFloat(by); !ok {
+		return v, fmt.Errorf("cannot increment with non-numeric argument: {%s: %v}", path, by)
+	}
+
+	current := lookupPath(v, segs)
+	if len(current) == 0 {
+		return setPath(v, segs, by)
+	}
+
+	sum, ok := addNumbers(current[0], by)
+	if !ok {
+		return v, fmt.Errorf("cannot apply $inc to a value of non-numeric type %T at %s", current[0], path)
+	}
+
+	return setPath(v, segs, sum)
+}
+
+// addNumbers adds two BSON numbers, widening the result the way the server does.
+func addNumbers(a, b interface{}) (interface{}, bool) {
+
+	if _, ok := toFloat(a); !ok {
+		return nil, false
+	}
+
+	ia, aInt := toInt64(a)
+	ib, bInt := toInt64(b)
+	if aInt && bInt {
+		sum := ia + ib
+		if (sum > ia) != (ib > 0) {
+			fa, _ := toFloat(a)
+			fb, _ := toFloat(b)
+			return fa + fb, true
+		}
+
+		_, a32 := a.(int32)
+		_, b32 := b.(int32)
+		if a32 && b32 && sum >= math.MinInt32 && sum <= math.MaxInt32 {
+			return int32(sum), true
+		}
+		return sum, true
+	}
+
+	fa, _ := toFloat(a)
+	fb, _ := toFloat(b)
+	return fa + fb, true
+}
+
+// mulPath multiplies the number at the dotted path segs of v by by. A missing field is set to a zero of the type of
+// by.
+func mulPath(v interface{}, segs []string, path string, by interface{}) (interface{}, error) {
+
+	if _, ok := toFloat(by); !ok {
+		return v, fmt.Errorf("cannot multiply with non-numeric argument: {%s: %v}", path, by)
+	}
+
+	current := lookupPath(v, segs)
+	if len(current) == 0 {
+		product, _ := mulNumbers(int32(0), by)
+		return setPath(v, segs, product)
+	}
+
+	product, ok := mulNumbers(current[0], by)
+	if !ok {
+		return v, fmt.Errorf("cannot apply $mul to a value of non-numeric type %T at %s", current[0], path)
+	}
+
+	return setPath(v, segs, product)
+}
+
+// mulNumbers multiplies two BSON numbers, widening the result the way the server does.
+func mulNumbers(a, b interface{}) (interface{}, bool) {
+
+	if _, ok := toFloat(a); !ok {
+		return nil, false
+	}
+
+	ia, aInt := toInt64(a)
+	ib, bInt := toInt64(b)
+	if aInt && bInt {
+		product := ia * ib
+		if ia != 0 && (product/ia != ib || (ia == -1 && ib == math.MinInt64)) {
+			fa, _ := toFloat(a)
+			fb, _ := toFloat(b)
+			return fa * fb, true
+		}
+
+		_, a32 := a.(int32)
+		_, b32 := b.(int32)
+		if a32 && b32 && product >= math.MinInt32 && product <= math.MaxInt32 {
+			return int32(product), true
+		}
+		return product, true
+	}
+
+	fa, _ := toFloat(a)
+	fb, _ := toFloat(b)
+	return fa * fb, true
+}
+
+// boundPath sets the value at the dotted path segs of v to value when it is missing, or when value is lower, for $min,
+// or greater, for $max, in the BSON comparison order.
+func boundPath(v interface{}, segs []string, op string, value interface{}) (interface{}, error) {
+
+	current := lookupPath(v, segs)
+	if len(current) > 0 {
+		c := compareValues(value, current[0])
+		if (op == "$min" && c >= 0) || (op == "$max" && c <= 0) {
+			return v, nil
+		}
+	}
+
+	return setPath(v, segs, value)
+}
+
+func pushPath(v interface{}, segs []string, path string, value interface{}) (interface{}, error) {
+
+	items := bson.A{value}
+	slice, hasSlice := 0, false
+	position, hasPosition := 0, false
+	var sortSpec interface{}
+
+	if mods, ok := value.(bson.D); ok && isOperatorDocument(mods) {
+		items = nil
+		for _, m := range mods {
+			switch m.Key {
+			case "$each":
+				each, ok := m.Value.(bson.A)
+				if !ok {
+					return v, fmt.Errorf("the argument to $each in $push must be an array")
+				}
+				items = each
+			case "$slice":
+				f, ok := toFloat(m.Value)
+				if !ok {
+					return v, fmt.Errorf("the value for $slice must be a number")
+				}
+				slice, hasSlice = int(f), true
+			case "$position":
+				f, ok := toFloat(m.Value)
+				if !ok {
+					return v, fmt.Errorf("the value for $position must be a number")
+				}
+				position, hasPosition = int(f), true
+			case "$sort":
+				sortSpec = m.Value
+			default:
+				return v, fmt.Errorf("unsupported $push modifier %s", m.Key)
+			}
+		}
+	}
+
+	arr, err := arrayAt(v, segs, path)
+	if err != nil {
+		return v, err
+	}
+
+	at := len(arr)
+	if hasPosition {
+		switch {
+		case position >= 0:
+			at = min(position, len(arr))
+		default:
+			at = max(len(arr)+position, 0)
+		}
+	}
+	arr = append(arr[:at:at], append(append(bson.A{}, items...), arr[at:]...)...)
+
+	if sortSpec != nil {
+		if arr, err = sortArray(arr, sortSpec); err != nil {
+			return v, err
+		}
+	}
+
+	if hasSlice {
+		switch {
+		case slice >= 0 && slice < len(arr):
+			arr = arr[:slice]
+		case slice < 0 && -slice < len(arr):
+			arr = arr[len(arr)+slice:]
+		}
+	}
+
+	return setPath(v, segs, arr)
+}
+
+// sortArray sorts arr by spec, the argument of the $sort modifier of $push: 1 or -1 to sort the elements by value, or a
+// document sorting them by some of their fields.
+func sortArray(arr bson.A, spec interface{}) (bson.A, error) {
+
+	if dir, ok := toFloat(spec); ok {
+		if dir != 1 && dir != -1 {
+			return nil, fmt.Errorf("the $sort element value must be either 1 or -1")
+		}
+
+		sort.SliceStable(arr, func(i, j int) bool {
+			return compareValues(arr[i], arr[j])*int(dir) < 0
+		})
+		return arr, nil
+	}
+
+	fields, ok := spec.(bson.D)
+	if !ok || len(fields) == 0 {
+		return nil, fmt.Errorf("the $sort modifier must be 1, -1 or a document of fields to sort by")
+	}
+
+	dirs := make([]int, len(fields))
+	for i, f := range fields {
+		dir, ok := toFloat(f.Value)
+		if !ok || (dir != 1 && dir != -1) {
+			return nil, fmt.Errorf("the $sort element value must be either 1 or -1")
+		}
+		dirs[i] = int(dir)
+	}
+
+	sort.SliceStable(arr, func(i, j int) bool {
+		for k, f := range fields {
+			a, _ := arr[i].(bson.D)
+			b, _ := arr[j].(bson.D)
+			av, _ := lookupKeyPath(a, f.Key)
+			bv, _ := lookupKeyPath(b, f.Key)
+			if c := compareValues(av, bv); c != 0 {
+				return c*dirs[k] < 0
+			}
+		}
+		return false
+	})
+
+	return arr, nil
+}
+
+// addToSetPath appends value, or the values of its $each modifier, to the array at the dotted path segs of v unless
+// they are already in it.
+func addToSetPath(v interface{}, segs []string, path string, value interface{}) (interface{}, error) {
+
+	items := bson.A{value}
+	if mods, ok := value.(bson.D); ok && isOperatorDocument(mods) {
+		each, _ := lookupKey(mods, "$each")
+		var ok bool
+		if items, ok = each.(bson.A); !ok || len(mods) != 1 {
+			return v, fmt.Errorf("the argument to $addToSet must be a value or a document with a single $each array")
+		}
+	}
+
+	arr, err := arrayAt(v, segs, path)
+	if err != nil {
+		return v, err
+	}
+
+	for _, item := range items {
+		if !slices.ContainsFunc(arr, func(e interface{}) bool { return valuesEqual(e, item) }) {
+			arr = append(arr, item)
+		}
+	}
+
+	return setPath(v, segs, arr)
+}
+
+// pullPath removes from the array at the dotted path segs of v the elements matching cond: a query operator document,
+// a query on the fields of document elements, or a value compared by equality.
+func pullPath(v interface{}, segs []string, path string, cond interface{}) (interface{}, error) {
+
+	current := lookupPath(v, segs)
+	if len(current) == 0 {
+		return v, nil
+	}
+
+	arr, ok := current[0].(bson.A)
+	if !ok {
+		return v, fmt.Errorf("cannot apply $pull to a non-array value at %s", path)
+	}
+
+	kept := bson.A{}
+	for _, e := range arr {
+		matched, err := matchPull(e, cond)
+		if err != nil {
+			return v, err
+		}
+		if !matched {
+			kept = append(kept, e)
+		}
+	}
+
+	return setPath(v, segs, kept)
+}
+
+func matchPull(e interface{}, cond interface{}) (bool, error) {
+
+	d, ok := cond.(bson.D)
+	switch {
+	case ok && isOperatorDocument(d):
+		return matchValues([]interface{}{e}, d)
+	case ok:
+		if doc, isDoc := e.(bson.D); isDoc {
+			return matchDocument(doc, d)
+		}
+	}
+
+	return valuesEqual(e, cond), nil
+}
+
+// renamePath moves the value at the dotted path segs of v to the path to.
+func renamePath(v interface{}, segs []string, path string, to interface{}) (interface{}, error) {
+
+	target, ok := to.(string)
+	if !ok || target == "" || target == path {
+		return v, fmt.Errorf("the $rename target of %s must be a string different from the source", path)
+	}
+
+	current := lookupPath(v, segs)
+	if len(current) == 0 {
+		return v, nil
+	}
+
+	return setPath(unsetPath(v, segs), strings.Split(target, "."), current[0])
+}
+
+// arrayAt returns a copy of the array at the dotted path segs of v, empty when the field is missing.
+func arrayAt(v interface{}, segs []string, path string) (bson.A, error) {
+
+	current := lookupPath(v, segs)
+	if len(current) == 0 {
+		return bson.A{}, nil
+	}
+
+	existing, ok := current[0].(bson.A)
+	if !ok {
+		return nil, fmt.Errorf("the field '%s' must be an array but is of type %T", path, current[0])
+	}
+
+	return append(bson.A{}, existing...), nil
+}
+
+func currentDate(spec interface{}, now time.Time) (interface{}, error) {
+
+	switch s := spec.(type) {
+	case bool:
+		return primitive.NewDateTimeFromTime(now), nil
+	case bson.D:
+		t, _ := lookupKey(s, "$type")
+		switch t {
+		case "date":
+			return primitive.NewDateTimeFromTime(now), nil
+		case "timestamp":
+			return primitive.Timestamp{T: uint32(now.Unix())}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("$currentDate expects true or a $type of date or timestamp, got %v", spec)
+}
+
+// upsertDocument returns the document an upsert inserts when nothing matches filter: the fields of its equality
+// conditions.
+func upsertDocument(filter bson.D) (bson.D, error) {
+
+	var v interface{} = bson.D{}
+	for _, e := range filter {
+		if e.Key == "$and" {
+			clauses, _ := e.Value.(bson.A)
+			for _, c := range clauses {
+				sub, ok := c.(bson.D)
+				if !ok {
+					continue
+				}
+
+				fields, err := upsertDocument(sub)
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range fields {
+					if v, err = setPath(v, strings.Split(f.Key, "."), f.Value); err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(e.Key, "$") {
+			continue
+		}
+
+		value := e.Value
+		if ops, ok := value.(bson.D); ok && isOperatorDocument(ops) {
+			eq, ok := lookupKey(ops, "$eq")
+			if !ok {
+				continue
+			}
+			value = eq
+		}
+		if _, ok := value.(primitive.Regex); ok {
+			continue
+		}
+
+		var err error
+		if v, err = setPath(v, strings.Split(e.Key, "."), cloneValue(value)); err != nil {
+			return nil, err
+		}
+	}
+
+	return v.(bson.D), nil
+}
@@ -0,0 +1,478 @@
+package friendlymongo
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultPageLimit is the number of documents of a page when PageRequest.Limit is not set.
+const DefaultPageLimit = 20
+
+// ErrInvalidPageToken is returned by FindPage when a continuation token is malformed, has been tampered with or was
+// issued for a different filter or sort.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// defaultPageSecret signs the continuation tokens of the repositories without WithPageSecret. Tokens signed with it
+// are only valid within the current process.
+var defaultPageSecret = func() []byte {
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Errorf("could not generate the page token secret: %w", err))
+	}
+
+	return secret
+}()
+
+// PageRequest describes a page of documents to retrieve with FindPage.
+type PageRequest struct {
+	// Limit is the maximum number of documents of the page. It defaults to DefaultPageLimit.
+	Limit int64
+	// Offset is the number of documents to skip, in offset pagination.
+	Offset int64
+	// Sort is the sort specification of the documents, e.g. bson.D{{Key: "createdAt", Value: -1}}. The _id field is
+	// appended as a tiebreaker when missing, so that the order is stable. In keyset pagination, each sort field must
+	// hold values of a single BSON type, or be null or missing.
+	Sort bson.D
+	// Keyset selects keyset pagination: pages are delimited by the sort key values of their first and last documents
+	// instead of an offset, so they stay consistent while documents are inserted and scale to any depth.
+	Keyset bool
+	// Token is the continuation token of a previous page, taken from Page.Next or Page.Prev. When it is set, Offset
+	// and Keyset are ignored in favour of the position encoded in the token. A token is only valid with the filter and
+	// Sort of the page it was taken from.
+	Token string
+}
+
+// Page is a page of documents returned by FindPage.
+type Page[T Model] struct {
+	Items []T
+	// Total is the number of documents matching the filter. It is only computed in offset pagination.
+	Total int64
+	// Next is the token of the following page, empty on the last page.
+	Next string
+	// Prev is the token of the preceding page, empty on the first page.
+	Prev string
+}
+
+// pageToken is the signed content of a continuation token.
+type pageToken struct {
+	// Query is the digest of the filter and sort the token was issued for.
+	Query  []byte          `bson:"q"`
+	Offset int64           `bson:"o,omitempty"`
+	Keyset bool            `bson:"ks,omitempty"`
+	Keys   []bson.RawValue `bson:"k,omitempty"`
+	Before bool            `bson:"b,omitempty"`
+}
+
+// sortKey is a field of a sort specification with its direction, 1 or -1.
+type sortKey struct {
+	field string
+	dir   int
+}
+
+// FindPage returns a page of the documents matching filter.
+//
+// In offset pagination the page starts at req.Offset and reports the total number of matching documents. In keyset
+// pagination, selected with req.Keyset, the page starts after (or before) the sort key values encoded in req.Token.
+// Either way, Page.Next and Page.Prev hold opaque tokens, signed so that they cannot be tampered with, to pass in
+// req.Token to retrieve the adjacent pages.
+func (r *BaseRepository[T]) FindPage(ctx context.Context, filter interface{}, req PageRequest) (*Page[T], error) {
+
+	op := &Operation{Kind: "FindPage", Collection: r.collection.Name(), Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*Page[T], error) {
+		return r.findPage(ctx, op.Filter, req)
+	})
+}
+
+func (r *BaseRepository[T]) findPage(ctx context.Context, filter interface{}, req PageRequest) (_ *Page[T], err error) {
+
+	defer r.wrapErr(&err, "FindPage", filter)
+
+	keys, err := sortKeys(req.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	query, err := r.queryDigest(filter, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultPageLimit
+	}
+
+	tok := &pageToken{Query: query, Offset: req.Offset, Keyset: req.Keyset}
+	if req.Token != "" {
+		if tok, err = r.parsePageToken(req.Token); err != nil {
+			return nil, err
+		}
+		if !hmac.Equal(tok.Query, query) {
+			return nil, fmt.Errorf("%w: issued for a different filter or sort", ErrInvalidPageToken)
+		}
+		if tok.Keyset && len(tok.Keys) != len(keys) {
+			return nil, fmt.Errorf("%w: wrong number of sort keys", ErrInvalidPageToken)
+		}
+	}
+
+	if tok.Keyset {
+		return r.findKeysetPage(ctx, filter, keys, limit, tok)
+	}
+
+	return r.findOffsetPage(ctx, filter, keys, limit, tok)
+}
+
+func (r *BaseRepository[T]) findOffsetPage(
+	ctx context.Context,
+	filter interface{},
+	keys []sortKey,
+	limit int64,
+	tok *pageToken,
+) (*Page[T], error) {
+
+	offset := tok.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().SetSort(sortDocument(keys, false)).SetSkip(offset).SetLimit(limit)
+	raws, err := r.findRaw(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page[T]{Total: total}
+	if page.Items, err = r.decodeAll(raws); err != nil {
+		return nil, err
+	}
+
+	if offset+int64(len(raws)) < total {
+		if page.Next, err = r.signPageToken(&pageToken{Query: tok.Query, Offset: offset + limit}); err != nil {
+			return nil, err
+		}
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		if page.Prev, err = r.signPageToken(&pageToken{Query: tok.Query, Offset: prev}); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+func (r *BaseRepository[T]) findKeysetPage(
+	ctx context.Context,
+	filter interface{},
+	keys []sortKey,
+	limit int64,
+	tok *pageToken,
+) (*Page[T], error) {
+
+	if len(tok.Keys) > 0 {
+		filter = andFilter(filter, keysetFilter(keys, tok.Keys, tok.Before))
+	}
+
+	// One more document than needed tells whether there is a page further in the same direction.
+	opts := options.Find().SetSort(sortDocument(keys, tok.Before)).SetLimit(limit + 1)
+	raws, err := r.findRaw(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	more := int64(len(raws)) > limit
+	if more {
+		raws = raws[:limit]
+	}
+	if tok.Before {
+		for i, j := 0, len(raws)-1; i < j; i, j = i+1, j-1 {
+			raws[i], raws[j] = raws[j], raws[i]
+		}
+	}
+
+	page := &Page[T]{}
+	if page.Items, err = r.decodeAll(raws); err != nil {
+		return nil, err
+	}
+	if len(raws) == 0 {
+		return page, nil
+	}
+
+	first, last := len(tok.Keys) > 0, len(tok.Keys) > 0
+	if tok.Before {
+		first = more
+	} else {
+		last = more
+	}
+
+	if last {
+		next := &pageToken{Query: tok.Query, Keyset: true, Keys: sortValues(raws[len(raws)-1], keys)}
+		if page.Next, err = r.signPageToken(next); err != nil {
+			return nil, err
+		}
+	}
+	if first {
+		prev := &pageToken{Query: tok.Query, Keyset: true, Keys: sortValues(raws[0], keys), Before: true}
+		if page.Prev, err = r.signPageToken(prev); err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+func (r *BaseRepository[T]) findRaw(ctx context.Context, filter interface{}, opts *options.FindOptions) ([]bson.Raw, error) {
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var raws []bson.Raw
+	for cursor.Next(ctx) {
+		raws = append(raws, append(bson.Raw(nil), cursor.Current...))
+	}
+
+	return raws, cursor.Err()
+}
+
+func (r *BaseRepository[T]) decodeAll(raws []bson.Raw) ([]T, error) {
+
+	documents := make([]T, 0, len(raws))
+	for _, raw := range raws {
+		document, err := decodeDocument[T](rawDecoder{raw: raw, registry: r.registry}, r.discriminator)
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+
+	return documents, nil
+}
+
+// keysetFilter selects the documents sorted after, or before, the documents whose sort keys hold values. Comparison
+// operators only match values of the same BSON type, so null and missing values, which sort before any other, are
+// matched explicitly.
+func keysetFilter(keys []sortKey, values []bson.RawValue, before bool) bson.M {
+
+	or := make(bson.A, 0, len(keys))
+	for i, k := range keys {
+		cond := bson.D{}
+		for j := 0; j < i; j++ {
+			cond = append(cond, bson.E{Key: keys[j].field, Value: values[j]})
+		}
+
+		null := values[i].Type == bsontype.Null
+		switch after := (k.dir < 0) == before; {
+		case after && null:
+			cond = append(cond, bson.E{Key: k.field, Value: bson.M{"$ne": nil}})
+		case after:
+			cond = append(cond, bson.E{Key: k.field, Value: bson.M{"$gt": values[i]}})
+		case null:
+			// No value sorts before null.
+			continue
+		default:
+			cond = append(cond, bson.E{Key: "$or", Value: bson.A{
+				bson.M{k.field: bson.M{"$lt": values[i]}},
+				bson.M{k.field: nil},
+			}})
+		}
+
+		or = append(or, cond)
+	}
+
+	if len(or) == 0 {
+		return bson.M{"$nor": bson.A{bson.M{}}}
+	}
+
+	return bson.M{"$or": or}
+}
+
+// sortKeys parses a sort specification, appending _id as a tiebreaker when missing.
+func sortKeys(sort bson.D) ([]sortKey, error) {
+
+	keys := make([]sortKey, 0, len(sort)+1)
+	hasID := false
+	for _, e := range sort {
+		dir, ok := sortDirection(e.Value)
+		if !ok {
+			return nil, fmt.Errorf("sort direction of %s must be 1 or -1, got %v", e.Key, e.Value)
+		}
+
+		keys = append(keys, sortKey{field: e.Key, dir: dir})
+		hasID = hasID || e.Key == "_id"
+	}
+
+	if !hasID {
+		dir := 1
+		if len(keys) > 0 {
+			dir = keys[len(keys)-1].dir
+		}
+		keys = append(keys, sortKey{field: "_id", dir: dir})
+	}
+
+	return keys, nil
+}
+
+func sortDirection(v interface{}) (int, bool) {
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if d := rv.Int(); d == 1 || d == -1 {
+			return int(d), true
+		}
+	case reflect.Float32, reflect.Float64:
+		if d := rv.Float(); d == 1 || d == -1 {
+			return int(d), true
+		}
+	}
+
+	return 0, false
+}
+
+// sortDocument returns the sort specification of keys, reversed when reverse is true.
+func sortDocument(keys []sortKey, reverse bool) bson.D {
+
+	sort := make(bson.D, len(keys))
+	for i, k := range keys {
+		dir := k.dir
+		if reverse {
+			dir = -dir
+		}
+		sort[i] = bson.E{Key: k.field, Value: dir}
+	}
+
+	return sort
+}
+
+// queryDigest identifies filter and the sort keys within a token, so that it is only accepted for the query it was
+// issued for.
+func (r *BaseRepository[T]) queryDigest(filter interface{}, keys []sortKey) ([]byte, error) {
+
+	raw, err := bson.MarshalWithRegistry(r.registry, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+
+	b, err := bson.Marshal(bson.D{{Key: "f", Value: canonical(doc)}, {Key: "s", Value: sortSignature(keys)}})
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+// canonical returns v with the fields of its documents sorted, as their order is random in a bson.M.
+func canonical(v interface{}) interface{} {
+
+	switch c := v.(type) {
+	case bson.D:
+		out := make(bson.D, len(c))
+		for i, e := range c {
+			out[i] = bson.E{Key: e.Key, Value: canonical(e.Value)}
+		}
+		slices.SortStableFunc(out, func(a, b bson.E) int { return strings.Compare(a.Key, b.Key) })
+		return out
+	case bson.A:
+		out := make(bson.A, len(c))
+		for i, e := range c {
+			out[i] = canonical(e)
+		}
+		return out
+	}
+
+	return v
+}
+
+// sortSignature identifies a sort specification within a token.
+func sortSignature(keys []sortKey) string {
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k.field + ":" + strconv.Itoa(k.dir)
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// sortValues returns the values of the sort keys of raw, null when missing.
+func sortValues(raw bson.Raw, keys []sortKey) []bson.RawValue {
+
+	values := make([]bson.RawValue, len(keys))
+	for i, k := range keys {
+		v, err := raw.LookupErr(strings.Split(k.field, ".")...)
+		if err != nil {
+			v = bson.RawValue{Type: bsontype.Null}
+		}
+		values[i] = v
+	}
+
+	return values
+}
+
+// signPageToken encodes tok followed by its HMAC-SHA256, in URL-safe base64.
+func (r *BaseRepository[T]) signPageToken(tok *pageToken) (string, error) {
+
+	payload, err := bson.Marshal(tok)
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, r.pageSecret)
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(payload)), nil
+}
+
+func (r *BaseRepository[T]) parsePageToken(token string) (*pageToken, error) {
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) <= sha256.Size {
+		return nil, ErrInvalidPageToken
+	}
+
+	payload, sum := b[:len(b)-sha256.Size], b[len(b)-sha256.Size:]
+
+	mac := hmac.New(sha256.New, r.pageSecret)
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, ErrInvalidPageToken
+	}
+
+	tok := &pageToken{}
+	if err := bson.Unmarshal(payload, tok); err != nil {
+		return nil, ErrInvalidPageToken
+	}
+
+	return tok, nil
+}
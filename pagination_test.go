@@ -0,0 +1,180 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func newPagedArtworkRepo(t *testing.T, collection string) *fm.BaseRepository[*artwork] {
+	r := fm.NewBaseRepository(fm.GetInstance().Database(testDB), collection, new(artwork))
+
+	_, err := r.Delete(context.Background(), bson.M{})
+	require.NoError(t, err)
+
+	artworks := make([]*artwork, 7)
+	for i := range artworks {
+		// Years repeat so that the _id tiebreaker is exercised.
+		artworks[i] = &artwork{Title: fmt.Sprintf("artwork %d", i), Artist: "paged", Year: 1900 + i/2}
+	}
+	_, err = r.InsertMany(context.Background(), artworks)
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestFindPage_Offset(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newPagedArtworkRepo(t, "pagesOffset")
+	req := fm.PageRequest{Limit: 3, Sort: bson.D{{Key: "year", Value: 1}}}
+
+	page, err := r.FindPage(context.Background(), bson.M{"artist": "paged"}, req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), page.Total)
+	assert.Equal(t, []string{"artwork 0", "artwork 1", "artwork 2"}, titlesOf(page.Items))
+	assert.Empty(t, page.Prev)
+	require.NotEmpty(t, page.Next)
+
+	req.Token = page.Next
+	page, err = r.FindPage(context.Background(), bson.M{"artist": "paged"}, req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"artwork 3", "artwork 4", "artwork 5"}, titlesOf(page.Items))
+	require.NotEmpty(t, page.Next)
+	require.NotEmpty(t, page.Prev)
+
+	req.Token = page.Next
+	page, err = r.FindPage(context.Background(), bson.M{"artist": "paged"}, req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"artwork 6"}, titlesOf(page.Items))
+	assert.Empty(t, page.Next)
+
+	req.Token = ""
+	req.Offset = 5
+	page, err = r.FindPage(context.Background(), bson.M{"artist": "paged"}, req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"artwork 5", "artwork 6"}, titlesOf(page.Items))
+}
+
+func TestFindPage_Keyset(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newPagedArtworkRepo(t, "pagesKeyset")
+	req := fm.PageRequest{Limit: 3, Sort: bson.D{{Key: "year", Value: -1}}, Keyset: true}
+
+	var titles []string
+	var tokens []string
+	for {
+		page, err := r.FindPage(context.Background(), bson.M{"artist": "paged"}, req)
+		require.NoError(t, err)
+		assert.Zero(t, page.Total)
+
+		titles = append(titles, titlesOf(page.Items)...)
+		if page.Next == "" {
+			break
+		}
+		tokens = append(tokens, page.Next)
+		req.Token = page.Next
+	}
+
+	assert.Equal(t, []string{
+		"artwork 6", "artwork 5", "artwork 4", "artwork 3", "artwork 2", "artwork 1", "artwork 0",
+	}, titles)
+	require.Len(t, tokens, 2)
+
+	// Going back from the last page returns the middle one.
+	page, err := r.FindPage(context.Background(), bson.M{"artist": "paged"}, req)
+	require.NoError(t, err)
+	require.NotEmpty(t, page.Prev)
+
+	req.Token = page.Prev
+	page, err = r.FindPage(context.Background(), bson.M{"artist": "paged"}, req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"artwork 3", "artwork 2", "artwork 1"}, titlesOf(page.Items))
+	assert.NotEmpty(t, page.Prev)
+	assert.NotEmpty(t, page.Next)
+}
+
+func TestFindPage_InvalidToken(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newPagedArtworkRepo(t, "pagesInvalid")
+	req := fm.PageRequest{Limit: 2, Sort: bson.D{{Key: "year", Value: 1}}, Keyset: true}
+
+	page, err := r.FindPage(context.Background(), bson.M{}, req)
+	require.NoError(t, err)
+	require.NotEmpty(t, page.Next)
+
+	tampered := []byte(page.Next)
+	tampered[10] ^= 1
+	req.Token = string(tampered)
+	_, err = r.FindPage(context.Background(), bson.M{}, req)
+	assert.ErrorIs(t, err, fm.ErrInvalidPageToken)
+
+	req.Token = page.Next
+	req.Sort = bson.D{{Key: "title", Value: 1}}
+	_, err = r.FindPage(context.Background(), bson.M{}, req)
+	assert.ErrorIs(t, err, fm.ErrInvalidPageToken)
+
+	req.Sort = bson.D{{Key: "year", Value: 1}}
+	_, err = r.FindPage(context.Background(), bson.M{"artist": "paged"}, req)
+	assert.ErrorIs(t, err, fm.ErrInvalidPageToken, "a token is bound to the filter of its page")
+
+	other := fm.NewBaseRepository(fm.GetInstance().Database(testDB), "pagesInvalid", new(artwork),
+		fm.WithPageSecret([]byte("another secret")))
+	req.Sort = bson.D{{Key: "year", Value: 1}}
+	_, err = other.FindPage(context.Background(), bson.M{}, req)
+	assert.ErrorIs(t, err, fm.ErrInvalidPageToken)
+}
+
+func TestFindPage_KeysetMissingSortKeys(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newPagedArtworkRepo(t, "pagesMissingKeys")
+
+	// Missing and null sort keys sort before the others.
+	_, err := r.UpdateMany(context.Background(), bson.M{"title": "artwork 3"}, bson.M{"$unset": bson.M{"year": ""}})
+	require.NoError(t, err)
+	_, err = r.UpdateMany(context.Background(), bson.M{"title": "artwork 5"}, bson.M{"$set": bson.M{"year": nil}})
+	require.NoError(t, err)
+
+	for _, dir := range []int{1, -1} {
+		req := fm.PageRequest{Limit: 1, Sort: bson.D{{Key: "year", Value: dir}}, Keyset: true}
+
+		var titles []string
+		for {
+			page, err := r.FindPage(context.Background(), bson.M{"artist": "paged"}, req)
+			require.NoError(t, err)
+
+			titles = append(titles, titlesOf(page.Items)...)
+			if page.Next == "" {
+				break
+			}
+			req.Token = page.Next
+		}
+		require.Len(t, titles, 7, "direction %d", dir)
+
+		// Walking back from the last page returns every document again.
+		var back []string
+		for {
+			page, err := r.FindPage(context.Background(), bson.M{"artist": "paged"}, req)
+			require.NoError(t, err)
+
+			back = append(titlesOf(page.Items), back...)
+			if page.Prev == "" {
+				break
+			}
+			req.Token = page.Prev
+		}
+		assert.Equal(t, titles, back, "direction %d", dir)
+	}
+}
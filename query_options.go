@@ -0,0 +1,537 @@
+package friendlymongo
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Names of the query options, used to report the ones an operation does not support.
+const (
+	optSort         = "sort"
+	optProjection   = "projection"
+	optLimit        = "limit"
+	optSkip         = "skip"
+	optCollation    = "collation"
+	optHint         = "hint"
+	optMaxTime      = "maxTime"
+	optMaxAwaitTime = "maxAwaitTime"
+	optBatchSize    = "batchSize"
+	optAllowDiskUse = "allowDiskUse"
+	optComment      = "comment"
+	optReturnDoc    = "returnDocument"
+	optUpsert       = "upsert"
+	optFullDocument = "fullDocument"
+	optPreImage     = "preImage"
+	optResumeAfter  = "resumeAfter"
+	optStartAt      = "startAtOperationTime"
+	optCheckpoint   = "checkpoint"
+	optLet          = "let"
+	optOrdered      = "ordered"
+	optChunkSize    = "chunkSize"
+	optChunkBytes   = "chunkBytes"
+	optParallelism  = "parallelism"
+)
+
+type queryOpts struct {
+	sort         interface{}
+	projection   interface{}
+	limit        *int64
+	skip         *int64
+	collation    *options.Collation
+	hint         interface{}
+	maxTime      *time.Duration
+	batchSize    *int32
+	allowDiskUse *bool
+	comment      *string
+	returnDoc    *options.ReturnDocument
+	upsert       *bool
+	let          interface{}
+
+	// Change stream options, see Watch.
+	maxAwaitTime   *time.Duration
+	fullDocument   *options.FullDocument
+	preImage       *options.FullDocument
+	resumeAfter    bson.Raw
+	startAt        *primitive.Timestamp
+	checkpoints    CheckpointStore
+	checkpointName string
+
+	// InsertMany options, see InsertMany.
+	ordered     *bool
+	chunkSize   int
+	chunkBytes  int
+	parallelism int
+
+	// set lists the names of the configured options, in order.
+	set []string
+}
+
+// QueryOptsFunc configures a single repository operation, such as Find or UpdateOne.
+//
+// Options not supported by an operation make it fail: for instance Delete, which removes every matching document,
+// rejects WithLimit.
+type QueryOptsFunc func(*queryOpts)
+
+// WithSort sets the order of the documents, e.g. bson.D{{Key: "createdAt", Value: -1}}. With UpdateOne, it selects
+// the document updated among the matching ones.
+func WithSort(sort interface{}) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.sort = sort
+		opts.mark(optSort)
+	}
+}
+
+// WithProjection limits the fields of the returned documents, e.g. bson.M{"name": 1}.
+func WithProjection(projection interface{}) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.projection = projection
+		opts.mark(optProjection)
+	}
+}
+
+// WithLimit sets the maximum number of documents returned.
+func WithLimit(n int64) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.limit = &n
+		opts.mark(optLimit)
+	}
+}
+
+// WithSkip sets the number of documents to skip before returning results.
+func WithSkip(n int64) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.skip = &n
+		opts.mark(optSkip)
+	}
+}
+
+// WithCollation sets the language-specific rules used to compare strings.
+func WithCollation(c *options.Collation) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.collation = c
+		opts.mark(optCollation)
+	}
+}
+
+// WithHint sets the index used by the operation, either by name or by its specification document.
+func WithHint(hint interface{}) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.hint = hint
+		opts.mark(optHint)
+	}
+}
+
+// WithMaxTime sets the maximum amount of time the operation can run on the server.
+func WithMaxTime(d time.Duration) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.maxTime = &d
+		opts.mark(optMaxTime)
+	}
+}
+
+// WithBatchSize sets the maximum number of documents included in each batch returned by the server.
+func WithBatchSize(n int32) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.batchSize = &n
+		opts.mark(optBatchSize)
+	}
+}
+
+// WithAllowDiskUse allows the server to write temporary data to disk, e.g. to sort large result sets.
+func WithAllowDiskUse(allow bool) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.allowDiskUse = &allow
+		opts.mark(optAllowDiskUse)
+	}
+}
+
+// WithComment attaches a comment to the operation, visible in the server logs and profiler.
+func WithComment(comment string) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.comment = &comment
+		opts.mark(optComment)
+	}
+}
+
+// WithReturnDocument selects whether UpdateOne and ReplaceOne return the document as it was before the change,
+// options.Before, or as it is after it, options.After. It defaults to options.Before, or to options.After with
+// WithUpsert(true).
+func WithReturnDocument(rd options.ReturnDocument) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.returnDoc = &rd
+		opts.mark(optReturnDoc)
+	}
+}
+
+// WithUpsert makes UpdateOne and ReplaceOne insert a new document when none matches the filter. They then return the
+// document after the change by default, since there is no document before an insert: with an explicit
+// WithReturnDocument(options.Before), an insert returns mongo.ErrNoDocuments.
+func WithUpsert(upsert bool) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.upsert = &upsert
+		opts.mark(optUpsert)
+	}
+}
+
+// WithLet defines variables accessible in the aggregation pipeline as $$name, e.g. bson.M{"minYear": 1900}.
+func WithLet(vars interface{}) QueryOptsFunc {
+
+	return func(opts *queryOpts) {
+		opts.let = vars
+		opts.mark(optLet)
+	}
+}
+
+func (o *queryOpts) mark(name string) {
+
+	for _, n := range o.set {
+		if n == name {
+			return
+		}
+	}
+
+	o.set = append(o.set, name)
+}
+
+// newQueryOpts applies opts, returning an error if one of them is not among the supported options of op.
+func newQueryOpts(op string, opts []QueryOptsFunc, supported ...string) (*queryOpts, error) {
+
+	o := &queryOpts{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	for _, name := range o.set {
+		ok := false
+		for _, s := range supported {
+			ok = ok || s == name
+		}
+		if !ok {
+			return nil, fmt.Errorf("option %s is not supported by %s", name, op)
+		}
+	}
+
+	return o, nil
+}
+
+func (o *queryOpts) findOptions() *options.FindOptions {
+
+	opts := options.Find()
+	if o.sort != nil {
+		opts.SetSort(o.sort)
+	}
+	if o.projection != nil {
+		opts.SetProjection(o.projection)
+	}
+	if o.limit != nil {
+		opts.SetLimit(*o.limit)
+	}
+	if o.skip != nil {
+		opts.SetSkip(*o.skip)
+	}
+	if o.collation != nil {
+		opts.SetCollation(o.collation)
+	}
+	if o.hint != nil {
+		opts.SetHint(o.hint)
+	}
+	if o.maxTime != nil {
+		opts.SetMaxTime(*o.maxTime)
+	}
+	if o.batchSize != nil {
+		opts.SetBatchSize(*o.batchSize)
+	}
+	if o.allowDiskUse != nil {
+		opts.SetAllowDiskUse(*o.allowDiskUse)
+	}
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+
+	return opts
+}
+
+func (o *queryOpts) findOneOptions() *options.FindOneOptions {
+
+	opts := options.FindOne()
+	if o.sort != nil {
+		opts.SetSort(o.sort)
+	}
+	if o.projection != nil {
+		opts.SetProjection(o.projection)
+	}
+	if o.skip != nil {
+		opts.SetSkip(*o.skip)
+	}
+	if o.collation != nil {
+		opts.SetCollation(o.collation)
+	}
+	if o.hint != nil {
+		opts.SetHint(o.hint)
+	}
+	if o.maxTime != nil {
+		opts.SetMaxTime(*o.maxTime)
+	}
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+
+	return opts
+}
+
+// returnDocument is the document find-and-modify operations return: the one set by WithReturnDocument or, when it is
+// not set, the document after the change for an upsert, which has no document before an insert, and the one before it
+// otherwise.
+func (o *queryOpts) returnDocument() options.ReturnDocument {
+
+	if o.returnDoc != nil {
+		return *o.returnDoc
+	}
+	if o.upsert != nil && *o.upsert {
+		return options.After
+	}
+
+	return options.Before
+}
+
+func (o *queryOpts) findOneAndUpdateOptions() *options.FindOneAndUpdateOptions {
+
+	opts := options.FindOneAndUpdate()
+	if o.sort != nil {
+		opts.SetSort(o.sort)
+	}
+	if o.projection != nil {
+		opts.SetProjection(o.projection)
+	}
+	if o.collation != nil {
+		opts.SetCollation(o.collation)
+	}
+	if o.hint != nil {
+		opts.SetHint(o.hint)
+	}
+	if o.maxTime != nil {
+		opts.SetMaxTime(*o.maxTime)
+	}
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+	opts.SetReturnDocument(o.returnDocument())
+	if o.upsert != nil {
+		opts.SetUpsert(*o.upsert)
+	}
+
+	return opts
+}
+
+func (o *queryOpts) findOneAndReplaceOptions() *options.FindOneAndReplaceOptions {
+
+	opts := options.FindOneAndReplace()
+	if o.sort != nil {
+		opts.SetSort(o.sort)
+	}
+	if o.projection != nil {
+		opts.SetProjection(o.projection)
+	}
+	if o.collation != nil {
+		opts.SetCollation(o.collation)
+	}
+	if o.hint != nil {
+		opts.SetHint(o.hint)
+	}
+	if o.maxTime != nil {
+		opts.SetMaxTime(*o.maxTime)
+	}
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+	opts.SetReturnDocument(o.returnDocument())
+	if o.upsert != nil {
+		opts.SetUpsert(*o.upsert)
+	}
+
+	return opts
+}
+
+func (o *queryOpts) findOneAndDeleteOptions() *options.FindOneAndDeleteOptions {
+
+	opts := options.FindOneAndDelete()
+	if o.sort != nil {
+		opts.SetSort(o.sort)
+	}
+	if o.projection != nil {
+		opts.SetProjection(o.projection)
+	}
+	if o.collation != nil {
+		opts.SetCollation(o.collation)
+	}
+	if o.hint != nil {
+		opts.SetHint(o.hint)
+	}
+	if o.maxTime != nil {
+		opts.SetMaxTime(*o.maxTime)
+	}
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+
+	return opts
+}
+
+func (o *queryOpts) updateOptions() *options.UpdateOptions {
+
+	opts := options.Update()
+	if o.collation != nil {
+		opts.SetCollation(o.collation)
+	}
+	if o.hint != nil {
+		opts.SetHint(o.hint)
+	}
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+
+	return opts
+}
+
+func (o *queryOpts) deleteOptions() *options.DeleteOptions {
+
+	opts := options.Delete()
+	if o.collation != nil {
+		opts.SetCollation(o.collation)
+	}
+	if o.hint != nil {
+		opts.SetHint(o.hint)
+	}
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+
+	return opts
+}
+
+func (o *queryOpts) aggregateOptions() *options.AggregateOptions {
+
+	opts := options.Aggregate()
+	if o.collation != nil {
+		opts.SetCollation(o.collation)
+	}
+	if o.hint != nil {
+		opts.SetHint(o.hint)
+	}
+	if o.maxTime != nil {
+		opts.SetMaxTime(*o.maxTime)
+	}
+	if o.batchSize != nil {
+		opts.SetBatchSize(*o.batchSize)
+	}
+	if o.allowDiskUse != nil {
+		opts.SetAllowDiskUse(*o.allowDiskUse)
+	}
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+	if o.let != nil {
+		opts.SetLet(o.let)
+	}
+
+	return opts
+}
+
+func (o *queryOpts) countOptions() *options.CountOptions {
+
+	opts := options.Count()
+	if o.limit != nil {
+		opts.SetLimit(*o.limit)
+	}
+	if o.skip != nil {
+		opts.SetSkip(*o.skip)
+	}
+	if o.collation != nil {
+		opts.SetCollation(o.collation)
+	}
+	if o.hint != nil {
+		opts.SetHint(o.hint)
+	}
+	if o.maxTime != nil {
+		opts.SetMaxTime(*o.maxTime)
+	}
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+
+	return opts
+}
+
+// changeStreamOptions returns the options of a change stream resuming after token, when not nil, which takes
+// precedence over WithStartAtOperationTime.
+func (o *queryOpts) changeStreamOptions(token bson.Raw) *options.ChangeStreamOptions {
+
+	opts := options.ChangeStream()
+	if o.maxAwaitTime != nil {
+		opts.SetMaxAwaitTime(*o.maxAwaitTime)
+	}
+	if o.batchSize != nil {
+		opts.SetBatchSize(*o.batchSize)
+	}
+	if o.collation != nil {
+		opts.SetCollation(*o.collation)
+	}
+	if o.comment != nil {
+		opts.SetComment(*o.comment)
+	}
+	if o.fullDocument != nil {
+		opts.SetFullDocument(*o.fullDocument)
+	}
+	if o.preImage != nil {
+		opts.SetFullDocumentBeforeChange(*o.preImage)
+	}
+	if token != nil {
+		opts.SetStartAfter(token)
+	} else if o.startAt != nil {
+		opts.SetStartAtOperationTime(o.startAt)
+	}
+
+	return opts
+}
+
+// The options supported by each operation.
+var (
+	findSupported = []string{
+		optSort, optProjection, optLimit, optSkip, optCollation, optHint, optMaxTime, optBatchSize, optAllowDiskUse,
+		optComment,
+	}
+	findOneSupported   = []string{optSort, optProjection, optSkip, optCollation, optHint, optMaxTime, optComment}
+	updateOneSupported = []string{
+		optSort, optProjection, optCollation, optHint, optMaxTime, optComment, optReturnDoc, optUpsert,
+	}
+	replaceOneSupported       = updateOneSupported
+	updateManySupported       = []string{optCollation, optHint, optComment}
+	deleteSupported           = []string{optCollation, optHint, optComment}
+	findOneAndDeleteSupported = []string{optSort, optProjection, optCollation, optHint, optMaxTime, optComment}
+	countSupported            = []string{optLimit, optSkip, optCollation, optHint, optMaxTime, optComment}
+	aggregateSupported        = []string{
+		optCollation, optHint, optMaxTime, optBatchSize, optAllowDiskUse, optComment, optLet,
+	}
+	insertManySupported = []string{optOrdered, optChunkSize, optChunkBytes, optParallelism}
+	bulkSupported       = []string{optOrdered, optComment}
+	watchSupported      = []string{
+		optMaxAwaitTime, optBatchSize, optCollation, optComment, optFullDocument, optPreImage, optResumeAfter, optStartAt,
+		optCheckpoint,
+	}
+)
@@ -0,0 +1,131 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func newQueryOptionsRepo(t *testing.T, collection string) *fm.BaseRepository[*artwork] {
+	r := fm.NewBaseRepository(fm.GetInstance().Database(testDB), collection, new(artwork))
+
+	_, err := r.Delete(context.Background(), bson.M{})
+	require.NoError(t, err)
+
+	_, err = r.InsertMany(context.Background(), []*artwork{
+		{Title: "Composition VII", Artist: "Kandinsky", Year: 1913, Price: 385.00},
+		{Title: "composition VIII", Artist: "Kandinsky", Year: 1923, Price: 120.00},
+		{Title: "Yellow-Red-Blue", Artist: "Kandinsky", Year: 1925, Price: 240.00},
+	})
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestFind_WithOptions(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newQueryOptionsRepo(t, "findOptions")
+
+	found, err := r.Find(context.Background(), bson.M{"artist": "Kandinsky"},
+		fm.WithSort(bson.D{{Key: "title", Value: 1}}),
+		fm.WithCollation(&options.Collation{Locale: "en", Strength: 2}),
+		fm.WithProjection(bson.M{"title": 1, "year": 1}),
+		fm.WithSkip(1),
+		fm.WithLimit(1),
+		fm.WithHint(bson.D{{Key: "_id", Value: 1}}),
+		fm.WithMaxTime(5*time.Second),
+		fm.WithBatchSize(10),
+		fm.WithAllowDiskUse(true),
+		fm.WithComment("find with options"),
+	)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, "composition VIII", found[0].Title)
+	assert.Equal(t, 1923, found[0].Year)
+	assert.Zero(t, found[0].Price)
+}
+
+func TestFindOne_WithOptions(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newQueryOptionsRepo(t, "findOneOptions")
+
+	found, err := r.FindOne(context.Background(), bson.M{"artist": "Kandinsky"}, fm.WithSort(bson.M{"price": -1}))
+	require.NoError(t, err)
+	assert.Equal(t, "Composition VII", found.Title)
+
+	_, err = r.FindOne(context.Background(), bson.M{}, fm.WithLimit(1))
+	assert.EqualError(t, err, "FindOne on findOneOptions with filter {}: option limit is not supported by FindOne")
+}
+
+func TestUpdateOneAndDelete_WithOptions(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newQueryOptionsRepo(t, "updateDeleteOptions")
+
+	old, err := r.UpdateOne(context.Background(), bson.M{"artist": "Kandinsky"}, bson.M{"$set": bson.M{"price": 99}},
+		fm.WithSort(bson.M{"year": -1}))
+	require.NoError(t, err)
+	assert.Equal(t, "Yellow-Red-Blue", old.Title)
+
+	deleted, err := r.Delete(context.Background(), bson.M{"title": "COMPOSITION VII"},
+		fm.WithCollation(&options.Collation{Locale: "en", Strength: 2}), fm.WithComment("case insensitive"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = r.Delete(context.Background(), bson.M{}, fm.WithSort(bson.M{"year": 1}))
+	assert.EqualError(t, err, "Delete on updateDeleteOptions with filter {}: option sort is not supported by Delete")
+}
+
+func TestUpdateOneAndReplaceOne_ReturnAfterAndUpsert(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newQueryOptionsRepo(t, "returnAfterOptions")
+	ctx := context.Background()
+
+	after, err := r.UpdateOne(ctx, bson.M{"year": 1913}, bson.M{"$set": bson.M{"price": 400}},
+		fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, float32(400), after.Price)
+	assert.False(t, after.UpdatedAt.IsZero())
+
+	inserted, err := r.UpdateOne(ctx, bson.M{"title": "Small Worlds"}, bson.M{"$set": bson.M{"year": 1922}},
+		fm.WithUpsert(true), fm.WithReturnDocument(options.After), fm.WithProjection(bson.M{"title": 1}))
+	require.NoError(t, err)
+	assert.Equal(t, "Small Worlds", inserted.Title)
+	assert.Zero(t, inserted.Year, "projected out")
+
+	upserted, err := r.UpdateOne(ctx, bson.M{"title": "Blue Rider"}, bson.M{"$set": bson.M{"year": 1903}},
+		fm.WithUpsert(true))
+	require.NoError(t, err, "an upsert returns the document after the change by default")
+	assert.Equal(t, "Blue Rider", upserted.Title)
+	assert.Equal(t, 1903, upserted.Year)
+
+	_, err = r.UpdateOne(ctx, bson.M{"title": "Red Rider"}, bson.M{"$set": bson.M{"year": 1904}},
+		fm.WithUpsert(true), fm.WithReturnDocument(options.Before))
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments, "there is no document before an insert")
+
+	replaced, err := r.ReplaceOne(ctx, bson.M{"year": 1925}, &artwork{Title: "Yellow-Red-Blue", Year: 1925},
+		fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Empty(t, replaced.Artist)
+
+	count, err := r.Count(ctx, bson.M{"artist": "Kandinsky"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	_, err = r.ReplaceOne(ctx, bson.M{}, &artwork{}, fm.WithLimit(1))
+	assert.Error(t, err)
+}
@@ -3,101 +3,263 @@ package friendlymongo
 import (
 	"context"
 	"fmt"
+	"iter"
+	"reflect"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-var _ MongoRepository[Model] = &BaseRepository[Model]{}
-
-// MongoRepository is an interface that defines the methods for interacting with a MongoDB collection.
-type MongoRepository[T Model] interface {
-	// InsertOne inserts a single document into the collection.
-	InsertOne(ctx context.Context, document Model) error
-
-	// InsertMany inserts multiple documents into the collection.
-	InsertMany(ctx context.Context, documents []Model) error
+// Compile-time checks that BaseRepository satisfies the repository interfaces for concrete model types.
+var (
+	_ MongoRepository[*BaseModel] = (*BaseRepository[*BaseModel])(nil)
+	_ Reader[*BaseModel]          = (*BaseRepository[*BaseModel])(nil)
+	_ Writer[*BaseModel]          = (*BaseRepository[*BaseModel])(nil)
+	_ Aggregator                  = (*BaseRepository[*BaseModel])(nil)
+)
 
+// Reader defines the read operations on a MongoDB collection of T documents.
+type Reader[T Model] interface {
 	// FindOne finds a single document in the collection.
-	FindOne(ctx context.Context, filter interface{}) (Model, error)
+	FindOne(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (T, error)
 
 	// Find finds multiple documents in the collection.
-	Find(ctx context.Context, filter interface{}) ([]Model, error)
+	Find(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) ([]T, error)
+
+	// FindByID finds the document with the given ID.
+	FindByID(ctx context.Context, id interface{}, opts ...QueryOptsFunc) (T, error)
+
+	// FindByIDs finds the documents with the given IDs, in the same order, and reports the missing ones.
+	FindByIDs(ctx context.Context, ids []interface{}) ([]T, []interface{}, error)
+
+	// FindIter returns an iterator over the documents matching filter, decoded one at a time.
+	FindIter(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) iter.Seq2[T, error]
+
+	// Count counts the documents matching filter.
+	Count(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (int64, error)
+
+	// Exists reports whether at least one document matches filter.
+	Exists(ctx context.Context, filter interface{}) (bool, error)
+
+	// EstimatedCount returns an estimate of the number of documents in the collection.
+	EstimatedCount(ctx context.Context) (int64, error)
+}
+
+// Writer defines the write operations on a MongoDB collection of T documents.
+type Writer[T Model] interface {
+	// InsertOne inserts a single document into the collection.
+	InsertOne(ctx context.Context, document T) error
+
+	// InsertMany inserts multiple documents into the collection and reports which were inserted.
+	InsertMany(ctx context.Context, documents []T, opts ...QueryOptsFunc) (*InsertManyResult, error)
 
 	// UpdateOne finds a single document and updates it.
-	UpdateOne(ctx context.Context, filters interface{}, update interface{}) (Model, error)
+	UpdateOne(ctx context.Context, filters interface{}, update interface{}, opts ...QueryOptsFunc) (T, error)
+
+	// UpdateByID updates the document with the given ID.
+	UpdateByID(ctx context.Context, id interface{}, update interface{}, opts ...QueryOptsFunc) (T, error)
+
+	// ReplaceOne replaces a single document in the collection and returns it.
+	ReplaceOne(ctx context.Context, filter interface{}, replacement T, opts ...QueryOptsFunc) (T, error)
+
+	// ReplaceByID replaces the document with the given ID and returns it.
+	ReplaceByID(ctx context.Context, id interface{}, replacement T, opts ...QueryOptsFunc) (T, error)
+
+	// UpsertOne updates the first document matching filter, or inserts document when none matches.
+	UpsertOne(ctx context.Context, filter interface{}, document T) (*UpsertResult[T], error)
+
+	// UpsertByID updates the document with the given ID, or inserts document with that ID when it does not exist.
+	UpsertByID(ctx context.Context, id interface{}, document T) (*UpsertResult[T], error)
+
+	// UpdateMany updates all the documents matching filter.
+	UpdateMany(ctx context.Context, filter interface{}, update interface{}, opts ...QueryOptsFunc) (*UpdateResult, error)
 
 	// Delete deletes multiple documents from the collection.
-	Delete(ctx context.Context, filter interface{}) (int64, error)
+	Delete(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (int64, error)
+
+	// DeleteOne deletes the first document matching filter.
+	DeleteOne(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (*DeleteResult, error)
+
+	// DeleteByID deletes the document with the given ID.
+	DeleteByID(ctx context.Context, id interface{}) (*DeleteResult, error)
+
+	// FindOneAndDelete deletes the first document matching filter and returns it.
+	FindOneAndDelete(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (T, error)
+
+	// Bulk creates a batch of write operations sent in a single BulkWrite.
+	Bulk() *Bulk[T]
+}
+
+// UpdateResult is the outcome of UpdateMany.
+type UpdateResult struct {
+	// MatchedCount is the number of documents matching the filter.
+	MatchedCount int64
+	// ModifiedCount is the number of documents actually changed by the update.
+	ModifiedCount int64
+}
 
-	// ReplaceOne replaces a single document in the collection.
-	ReplaceOne(ctx context.Context, filter interface{}, replacement T) error
+// DeleteResult is the outcome of DeleteOne.
+type DeleteResult struct {
+	// DeletedCount is the number of documents deleted, 0 or 1.
+	DeletedCount int64
+}
 
+// Aggregator defines the aggregation operations on a MongoDB collection.
+type Aggregator interface {
 	// Aggregate runs an aggregation framework pipeline on the collection.
 	Aggregate(ctx context.Context, pipeline mongo.Pipeline, result interface{}) error
 }
 
+// MongoRepository is an interface that defines the methods for interacting with a MongoDB collection of T
+// documents. It is satisfied by BaseRepository[T] and can be mocked in tests.
+type MongoRepository[T Model] interface {
+	Reader[T]
+	Writer[T]
+	Aggregator
+}
+
 // BaseRepository is a base implementation of the MongoRepository interface.
 type BaseRepository[T Model] struct {
-	collection *mongo.Collection
+	collection    *mongo.Collection
+	registry      *bsoncodec.Registry
+	discriminator *Discriminator
+	encryption    *fieldEncryption
+	pageSecret    []byte
+	interceptors  []Interceptor
+	cache         *repositoryCache
 }
 
 // NewBaseRepository creates a new instance of BaseRepository.
-func NewBaseRepository[T Model](db *mongo.Database, collectionName string, t T) *BaseRepository[T] {
+func NewBaseRepository[T Model](
+	db *mongo.Database,
+	collectionName string,
+	t T,
+	opts ...RepositoryOptsFunc,
+) *BaseRepository[T] {
+
+	repoOpts := repositoryOpts{}
+	for _, opt := range opts {
+		opt(&repoOpts)
+	}
 
-	return &BaseRepository[T]{
-		collection: db.Collection(collectionName),
+	r := &BaseRepository[T]{
+		discriminator: repoOpts.discriminator,
+		pageSecret:    repoOpts.pageSecret,
+		interceptors:  repoOpts.interceptors,
+		cache:         newRepositoryCache(repoOpts.cache, repoOpts.cacheTTL),
 	}
+	if r.pageSecret == nil {
+		r.pageSecret = defaultPageSecret
+	}
+
+	base := bson.DefaultRegistry
+	if instance != nil && instance.client == db.Client() {
+		base = instance.Registry()
+	}
+
+	reg, custom, enc := repoOpts.buildRegistry(base, r.modelTypes())
+	r.registry, r.encryption = reg, enc
+
+	collOpts := options.Collection()
+	if custom {
+		collOpts.SetRegistry(reg)
+	}
+
+	r.collection = db.Collection(collectionName, collOpts)
+	return r
 }
 
 // InsertOne inserts a single document into the collection.
 //
-// The document parameter must be a pointer to a struct that implements the Model interface.
+// The document parameter must be a pointer to a struct that implements the Model interface. OnCreate is invoked on
+// the document and on every Model embedded in it.
 func (r *BaseRepository[T]) InsertOne(ctx context.Context, document T) error {
-	document.OnCreate()
 
-	_, err := r.collection.InsertOne(ctx, document)
-	return err
+	op := &Operation{Kind: "InsertOne", Collection: r.collection.Name(), Documents: []interface{}{document}}
+	return interceptErr(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) error {
+		document, err := modelOf[T](op)
+		if err != nil {
+			return err
+		}
+
+		return r.insertOne(ctx, document)
+	})
 }
 
-// InsertMany inserts multiple documents into the collection.
-func (r *BaseRepository[T]) InsertMany(ctx context.Context, documents []T) error {
+func (r *BaseRepository[T]) insertOne(ctx context.Context, document T) (err error) {
+
+	defer r.wrapErr(&err, "InsertOne", nil)
+	defer r.invalidate(ctx)
 
-	var interfaceSlice = make([]interface{}, len(documents))
-	for i, d := range documents {
-		d.OnCreate()
+	runHooks(document, onCreate)
 
-		interfaceSlice[i] = d
+	doc, err := r.encode(document)
+	if err != nil {
+		return err
 	}
 
-	_, err := r.collection.InsertMany(ctx, interfaceSlice)
+	_, err = r.collection.InsertOne(ctx, doc)
 	return err
 }
 
 // FindOne finds a single document in the collection.
-func (r *BaseRepository[T]) FindOne(ctx context.Context, filter interface{}) (T, error) {
+func (r *BaseRepository[T]) FindOne(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (T, error) {
 
-	var document T
+	op := &Operation{Kind: "FindOne", Collection: r.collection.Name(), Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (T, error) {
+		return r.findOne(ctx, op.Filter, opts...)
+	})
+}
 
-	err := r.collection.FindOne(ctx, filter).Decode(&document)
+func (r *BaseRepository[T]) findOne(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (_ T, err error) {
 
-	return document, err
+	defer r.wrapErr(&err, "FindOne", filter)
+
+	o, err := newQueryOpts("FindOne", opts, findOneSupported...)
+	if err != nil {
+		var document T
+		return document, err
+	}
+
+	if r.cache != nil && o.cacheable() && !InTransaction(ctx) {
+		return r.findOneCached(ctx, filter, o)
+	}
+
+	return r.decode(r.collection.FindOne(ctx, filter, o.findOneOptions()))
 }
 
 // Find finds multiple documents in the collection.
-func (r *BaseRepository[T]) Find(ctx context.Context, filter interface{}) ([]T, error) {
+func (r *BaseRepository[T]) Find(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) ([]T, error) {
+
+	op := &Operation{Kind: "Find", Collection: r.collection.Name(), Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) ([]T, error) {
+		return r.find(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *BaseRepository[T]) find(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (_ []T, err error) {
+
+	defer r.wrapErr(&err, "Find", filter)
 
 	var documents []T
 
-	cursor, err := r.collection.Find(ctx, filter)
+	o, err := newQueryOpts("Find", opts, findSupported...)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, o.findOptions())
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
 	for cursor.Next(ctx) {
-		var document T
-		if err := cursor.Decode(&document); err != nil {
+		document, err := r.decode(cursor)
+		if err != nil {
 			return nil, err
 		}
 		documents = append(documents, document)
@@ -106,37 +268,142 @@ func (r *BaseRepository[T]) Find(ctx context.Context, filter interface{}) ([]T,
 	return documents, nil
 }
 
-// UpdateOne finds a single document and updates it.
-// The update parameter must be a bson.M or a struct that implements the Model interface.
-func (r *BaseRepository[T]) UpdateOne(ctx context.Context, filters interface{}, update interface{}) (T, error) {
+// FindIter returns an iterator over the documents matching filter, decoded one at a time as the loop advances, so
+// that large results are streamed rather than loaded in memory. WithBatchSize tunes the number of documents fetched
+// from the server at once.
+//
+// An error stops the iteration after being yielded. Breaking out of the loop closes the underlying cursor.
+//
+//	for user, err := range repo.FindIter(ctx, bson.M{}) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (r *BaseRepository[T]) FindIter(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) iter.Seq2[T, error] {
+
+	op := &Operation{Kind: "FindIter", Collection: r.collection.Name(), Filter: filter}
+	return interceptSeq(ctx, r.interceptors, op,
+		func(ctx context.Context, op *Operation) (iter.Seq2[T, error], error) {
+			return r.findIter(ctx, op.Filter, opts...), nil
+		})
+}
+
+func (r *BaseRepository[T]) findIter(
+	ctx context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) iter.Seq2[T, error] {
+
+	return func(yield func(T, error) bool) {
+		var zero T
+		yield = r.wrapYield(yield, "FindIter", filter)
+
+		o, err := newQueryOpts("FindIter", opts, findSupported...)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		cursor, err := r.collection.Find(ctx, filter, o.findOptions())
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		r.yieldAll(ctx, cursor, yield)
+	}
+}
+
+// UpdateOne finds a single document and updates it. It returns the document as it was before the update unless
+// WithReturnDocument(options.After) or WithUpsert(true) is set.
+// The update parameter must be a bson.M of update operators, an UpdateBuilder or a struct that implements the Model
+// interface.
+func (r *BaseRepository[T]) UpdateOne(
+	ctx context.Context,
+	filters interface{},
+	update interface{},
+	opts ...QueryOptsFunc,
+) (T, error) {
+
+	op := &Operation{Kind: "UpdateOne", Collection: r.collection.Name(), Filter: filters, Update: update}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (T, error) {
+		return r.updateOne(ctx, op.Filter, op.Update, opts...)
+	})
+}
+
+func (r *BaseRepository[T]) updateOne(
+	ctx context.Context,
+	filters interface{},
+	update interface{},
+	opts ...QueryOptsFunc,
+) (_ T, err error) {
+
+	defer r.wrapErr(&err, "UpdateOne", filters)
+	defer r.invalidate(ctx)
+
 	var document T
-	var updateQuery bson.M
+	var updateQuery interface{}
+
+	o, err := newQueryOpts("UpdateOne", opts, updateOneSupported...)
+	if err != nil {
+		return document, err
+	}
 
 	switch u := update.(type) {
 	case T:
-		u.OnUpdate()
-		updateQuery = bson.M{"$set": u}
+		runHooks(u, onUpdate)
+
+		doc, err := r.encode(u)
+		if err != nil {
+			return document, err
+		}
+		updateQuery = bson.M{"$set": doc}
 	case bson.M:
-		u["$currentDate"] = bson.M{"updatedAt": true}
-		updateQuery = u
+		updateQuery = withUpdatedAt(u)
+	case UpdateBuilder:
+		if updateQuery, err = buildUpdate(u); err != nil {
+			return document, err
+		}
 	default:
-		return document, fmt.Errorf("update parameter must be a bson.M or a Model")
+		return document, fmt.Errorf("%w: must be a bson.M, an UpdateBuilder or a Model, got %T", ErrInvalidUpdate, update)
 	}
 
-	singleRes := r.collection.FindOneAndUpdate(ctx, filters, updateQuery)
-	if singleRes.Err() != nil {
-		return document, singleRes.Err()
+	if updateQuery, err = r.encryption.sealUpdate(r.registry, updateQuery); err != nil {
+		return document, err
 	}
 
-	err := singleRes.Decode(&document)
-
-	return document, err
+	return r.decode(r.collection.FindOneAndUpdate(ctx, filters, updateQuery, o.findOneAndUpdateOptions()))
 }
 
 // Delete deletes multiple documents from the collection.
-func (r *BaseRepository[T]) Delete(ctx context.Context, filter interface{}) (int64, error) {
+func (r *BaseRepository[T]) Delete(
+	ctx context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) (int64, error) {
+
+	op := &Operation{Kind: "Delete", Collection: r.collection.Name(), Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (int64, error) {
+		return r.deleteMany(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *BaseRepository[T]) deleteMany(
+	ctx context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) (_ int64, err error) {
+
+	defer r.wrapErr(&err, "Delete", filter)
+	defer r.invalidate(ctx)
 
-	deleteRes, err := r.collection.DeleteMany(ctx, filter)
+	o, err := newQueryOpts("Delete", opts, deleteSupported...)
+	if err != nil {
+		return 0, err
+	}
+
+	deleteRes, err := r.collection.DeleteMany(ctx, filter, o.deleteOptions())
 	if err != nil {
 		return 0, err
 	}
@@ -144,9 +411,199 @@ func (r *BaseRepository[T]) Delete(ctx context.Context, filter interface{}) (int
 	return deleteRes.DeletedCount, err
 }
 
+// UpdateMany updates all the documents matching filter.
+// The update parameter must be a bson.M of update operators or an UpdateBuilder. As with UpdateOne, the updatedAt field
+// is set to the current date.
+func (r *BaseRepository[T]) UpdateMany(
+	ctx context.Context,
+	filter interface{},
+	update interface{},
+	opts ...QueryOptsFunc,
+) (*UpdateResult, error) {
+
+	op := &Operation{Kind: "UpdateMany", Collection: r.collection.Name(), Filter: filter, Update: update}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*UpdateResult, error) {
+		return r.updateMany(ctx, op.Filter, op.Update, opts...)
+	})
+}
+
+func (r *BaseRepository[T]) updateMany(
+	ctx context.Context,
+	filter interface{},
+	update interface{},
+	opts ...QueryOptsFunc,
+) (_ *UpdateResult, err error) {
+
+	defer r.wrapErr(&err, "UpdateMany", filter)
+	defer r.invalidate(ctx)
+
+	o, err := newQueryOpts("UpdateMany", opts, updateManySupported...)
+	if err != nil {
+		return nil, err
+	}
+
+	var updateQuery interface{}
+	switch u := update.(type) {
+	case bson.M:
+		updateQuery = withUpdatedAt(u)
+	case UpdateBuilder:
+		if updateQuery, err = buildUpdate(u); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: must be a bson.M or an UpdateBuilder, got %T", ErrInvalidUpdate, update)
+	}
+
+	if updateQuery, err = r.encryption.sealUpdate(r.registry, updateQuery); err != nil {
+		return nil, err
+	}
+
+	res, err := r.collection.UpdateMany(ctx, filter, updateQuery, o.updateOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return &UpdateResult{MatchedCount: res.MatchedCount, ModifiedCount: res.ModifiedCount}, nil
+}
+
+// DeleteOne deletes the first document matching filter.
+func (r *BaseRepository[T]) DeleteOne(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (*DeleteResult, error) {
+
+	op := &Operation{Kind: "DeleteOne", Collection: r.collection.Name(), Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*DeleteResult, error) {
+		return r.deleteOne(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *BaseRepository[T]) deleteOne(
+	ctx context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) (_ *DeleteResult, err error) {
+
+	defer r.wrapErr(&err, "DeleteOne", filter)
+	defer r.invalidate(ctx)
+
+	o, err := newQueryOpts("DeleteOne", opts, deleteSupported...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.collection.DeleteOne(ctx, filter, o.deleteOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeleteResult{DeletedCount: res.DeletedCount}, nil
+}
+
+// FindOneAndDelete atomically deletes the first document matching filter, in the order given by WithSort, and
+// returns it. It returns mongo.ErrNoDocuments when no document matches.
+func (r *BaseRepository[T]) FindOneAndDelete(ctx context.Context, filter interface{}, opts ...QueryOptsFunc) (T, error) {
+
+	op := &Operation{Kind: "FindOneAndDelete", Collection: r.collection.Name(), Filter: filter}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (T, error) {
+		return r.findOneAndDelete(ctx, op.Filter, opts...)
+	})
+}
+
+func (r *BaseRepository[T]) findOneAndDelete(
+	ctx context.Context,
+	filter interface{},
+	opts ...QueryOptsFunc,
+) (_ T, err error) {
+
+	defer r.wrapErr(&err, "FindOneAndDelete", filter)
+	defer r.invalidate(ctx)
+
+	o, err := newQueryOpts("FindOneAndDelete", opts, findOneAndDeleteSupported...)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	return r.decode(r.collection.FindOneAndDelete(ctx, filter, o.findOneAndDeleteOptions()))
+}
+
+// withUpdatedAt returns a copy of the update operators of update that also sets updatedAt to the current date.
+func withUpdatedAt(update bson.M) bson.M {
+
+	u := make(bson.M, len(update)+1)
+	for k, v := range update {
+		u[k] = v
+	}
+	u["$currentDate"] = bson.M{"updatedAt": true}
+
+	return u
+}
+
+// UpdateBuilder builds the update operators of an update, such as the Builder of the update package.
+type UpdateBuilder interface {
+	Build() (bson.D, error)
+}
+
+// buildUpdate returns the update operators built by b, which also set updatedAt to the current date unless they
+// already modify it.
+func buildUpdate(b UpdateBuilder) (bson.D, error) {
+
+	update, err := b.Build()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidUpdate, err)
+	}
+
+	currentDate := -1
+	for i, op := range update {
+		fields, _ := op.Value.(bson.D)
+		for _, f := range fields {
+			to, _ := f.Value.(string)
+			if isUpdatedAtPath(f.Key) || (op.Key == "$rename" && isUpdatedAtPath(to)) {
+				return update, nil
+			}
+		}
+
+		if op.Key == "$currentDate" {
+			currentDate = i
+		}
+	}
+
+	u := append(bson.D{}, update...)
+	updatedAt := bson.E{Key: "updatedAt", Value: true}
+	if currentDate < 0 {
+		return append(u, bson.E{Key: "$currentDate", Value: bson.D{updatedAt}}), nil
+	}
+
+	u[currentDate].Value = append(append(bson.D{}, u[currentDate].Value.(bson.D)...), updatedAt)
+
+	return u, nil
+}
+
+func isUpdatedAtPath(path string) bool {
+
+	return path == "updatedAt" || strings.HasPrefix(path, "updatedAt.")
+}
+
 // Aggregate runs an aggregation framework pipeline on the collection.
 func (r *BaseRepository[T]) Aggregate(ctx context.Context, pipeline mongo.Pipeline, result interface{}) error {
 
+	op := &Operation{Kind: "Aggregate", Collection: r.collection.Name(), Pipeline: pipeline}
+	return interceptErr(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) error {
+		p, err := pipelineOf(op.Pipeline)
+		if err != nil {
+			return err
+		}
+
+		return r.aggregateInto(ctx, p, result)
+	})
+}
+
+func (r *BaseRepository[T]) aggregateInto(
+	ctx context.Context,
+	pipeline mongo.Pipeline,
+	result interface{},
+) (err error) {
+
+	defer r.wrapErr(&err, "Aggregate", nil)
+
 	cursor, err := r.collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return err
@@ -156,17 +613,239 @@ func (r *BaseRepository[T]) Aggregate(ctx context.Context, pipeline mongo.Pipeli
 	return cursor.All(ctx, result)
 }
 
-// ReplaceOne replaces a single document in the collection.
+// AggregateIter runs an aggregation pipeline on the collection and returns an iterator over the resulting documents,
+// decoded as T one at a time. It accepts the WithBatchSize, WithAllowDiskUse, WithMaxTime, WithHint, WithCollation
+// and WithComment options.
+//
+// An error stops the iteration after being yielded. Breaking out of the loop closes the underlying cursor.
+func (r *BaseRepository[T]) AggregateIter(
+	ctx context.Context,
+	pipeline mongo.Pipeline,
+	opts ...QueryOptsFunc,
+) iter.Seq2[T, error] {
+
+	op := &Operation{Kind: "AggregateIter", Collection: r.collection.Name(), Pipeline: pipeline}
+	return interceptSeq(ctx, r.interceptors, op,
+		func(ctx context.Context, op *Operation) (iter.Seq2[T, error], error) {
+			p, err := pipelineOf(op.Pipeline)
+			if err != nil {
+				return nil, err
+			}
+
+			return r.aggregateIter(ctx, p, opts...), nil
+		})
+}
+
+func (r *BaseRepository[T]) aggregateIter(
+	ctx context.Context,
+	pipeline mongo.Pipeline,
+	opts ...QueryOptsFunc,
+) iter.Seq2[T, error] {
+
+	return func(yield func(T, error) bool) {
+		var zero T
+		yield = r.wrapYield(yield, "AggregateIter", nil)
+
+		o, err := newQueryOpts("AggregateIter", opts, aggregateSupported...)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		cursor, err := r.collection.Aggregate(ctx, pipeline, o.aggregateOptions())
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+
+		r.yieldAll(ctx, cursor, yield)
+	}
+}
+
+// wrapErr wraps *err, if any, in an *Error for the operation op on filter.
+func (r *BaseRepository[T]) wrapErr(err *error, op string, filter interface{}) {
+
+	*err = wrapError(op, r.collection.Name(), r.registry, filter, *err)
+}
+
+// wrapYield wraps the errors passed to yield like wrapErr.
+func (r *BaseRepository[T]) wrapYield(yield func(T, error) bool, op string, filter interface{}) func(T, error) bool {
+
+	return func(document T, err error) bool {
+		return yield(document, wrapError(op, r.collection.Name(), r.registry, filter, err))
+	}
+}
+
+// yieldAll yields the documents of cursor until it is exhausted, an error occurs or yield returns false, and closes
+// it.
+func (r *BaseRepository[T]) yieldAll(ctx context.Context, cursor *mongo.Cursor, yield func(T, error) bool) {
+
+	defer cursor.Close(context.WithoutCancel(ctx))
+
+	for cursor.Next(ctx) {
+		document, err := r.decode(cursor)
+		if !yield(document, err) || err != nil {
+			return
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		var zero T
+		yield(zero, err)
+	}
+}
+
+// ReplaceOne replaces a single document in the collection and returns it, as it was before the replacement unless
+// WithReturnDocument(options.After) or WithUpsert(true) is set.
 // Replaced document must have the same ID as the one being replaced or not have it serializible at all. It is
 // strongly suggested to have the ID field with the `omitempty` bson tag in case of structs.
-func (r *BaseRepository[Model]) ReplaceOne(ctx context.Context, filter interface{}, replacement Model) error {
+func (r *BaseRepository[T]) ReplaceOne(
+	ctx context.Context,
+	filter interface{},
+	replacement T,
+	opts ...QueryOptsFunc,
+) (T, error) {
+
+	op := &Operation{
+		Kind:       "ReplaceOne",
+		Collection: r.collection.Name(),
+		Filter:     filter,
+		Documents:  []interface{}{replacement},
+	}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (T, error) {
+		replacement, err := modelOf[T](op)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+
+		return r.replaceOne(ctx, op.Filter, replacement, opts...)
+	})
+}
 
-	replacement.OnReplace()
+func (r *BaseRepository[T]) replaceOne(
+	ctx context.Context,
+	filter interface{},
+	replacement T,
+	opts ...QueryOptsFunc,
+) (_ T, err error) {
 
-	singleRes := r.collection.FindOneAndReplace(ctx, filter, replacement)
-	if singleRes.Err() != nil {
-		return singleRes.Err()
+	defer r.wrapErr(&err, "ReplaceOne", filter)
+	defer r.invalidate(ctx)
+
+	var document T
+
+	o, err := newQueryOpts("ReplaceOne", opts, replaceOneSupported...)
+	if err != nil {
+		return document, err
+	}
+
+	runHooks(replacement, onReplace)
+
+	doc, err := r.encode(replacement)
+	if err != nil {
+		return document, err
+	}
+
+	return r.decode(r.collection.FindOneAndReplace(ctx, filter, doc, o.findOneAndReplaceOptions()))
+}
+
+// decoder is implemented by both mongo.Cursor and mongo.SingleResult.
+type decoder interface {
+	Decode(val interface{}) error
+}
+
+// rawDecoder decodes a BSON document with a registry, like mongo.SingleResult does.
+type rawDecoder struct {
+	raw      bson.Raw
+	registry *bsoncodec.Registry
+}
+
+func (d rawDecoder) Decode(val interface{}) error {
+
+	return bson.UnmarshalWithRegistry(d.registry, d.raw, val)
+}
+
+// decode decodes the current document of d into a new T. When the repository has a discriminator, the document is
+// decoded into the concrete type registered for its discriminator value.
+func (r *BaseRepository[T]) decode(d decoder) (T, error) {
+
+	return decodeDocument[T](d, r.discriminator)
+}
+
+// encode returns the representation of document sent to the database, stamped with the discriminator value when the
+// repository has one.
+func (r *BaseRepository[T]) encode(document interface{}) (interface{}, error) {
+
+	if r.discriminator == nil {
+		return document, nil
+	}
+
+	return marshalDocument(r.registry, r.discriminator, document)
+}
+
+// decodeDocument decodes the current document of d into a new T, using the concrete type registered in disc for its
+// discriminator value when disc is not nil.
+func decodeDocument[T Model](d decoder, disc *Discriminator) (T, error) {
+
+	var document T
+
+	if disc == nil {
+		err := d.Decode(&document)
+		return document, err
+	}
+
+	var raw bson.Raw
+	if err := d.Decode(&raw); err != nil {
+		return document, err
+	}
+
+	v, err := disc.newInstance(raw)
+	if err != nil {
+		return document, err
+	}
+	if err := d.Decode(v); err != nil {
+		return document, err
+	}
+
+	document, ok := v.(T)
+	if !ok {
+		return document, fmt.Errorf("discriminated type %T is not a %T", v, document)
+	}
+
+	return document, nil
+}
+
+// marshalDocument marshals document with reg, stamping it with its discriminator value when disc is not nil.
+func marshalDocument(reg *bsoncodec.Registry, disc *Discriminator, document interface{}) (bson.Raw, error) {
+
+	raw, err := bson.MarshalWithRegistry(reg, document)
+	if err != nil {
+		return nil, err
+	}
+
+	if disc == nil {
+		return raw, nil
+	}
+
+	return disc.stamp(raw, document)
+}
+
+// modelTypes returns the concrete types the repository stores: T itself or, for polymorphic repositories, the types
+// registered in the discriminator.
+func (r *BaseRepository[T]) modelTypes() []reflect.Type {
+
+	return modelTypesOf[T](r.discriminator)
+}
+
+func modelTypesOf[T Model](disc *Discriminator) []reflect.Type {
+
+	types := []reflect.Type{reflect.TypeOf((*T)(nil)).Elem()}
+	if disc != nil {
+		for _, t := range disc.types {
+			types = append(types, t)
+		}
 	}
 
-	return nil
+	return types
 }
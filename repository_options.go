@@ -0,0 +1,152 @@
+package friendlymongo
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+)
+
+type repositoryOpts struct {
+	discriminator *Discriminator
+	keyProvider   KeyProvider
+	registry      *bsoncodec.Registry
+	builders      []func(*bsoncodec.Registry)
+	pageSecret    []byte
+	interceptors  []Interceptor
+	cache         Cache
+	cacheTTL      time.Duration
+}
+
+// RepositoryOptsFunc configures a repository created with NewBaseRepository or NewMemoryRepository.
+type RepositoryOptsFunc func(*repositoryOpts)
+
+// WithDiscriminator makes the repository polymorphic: documents are decoded into the type registered for the value
+// of their discriminator field, and inserted or replaced documents are stamped with it.
+func WithDiscriminator(d *Discriminator) RepositoryOptsFunc {
+
+	return func(opts *repositoryOpts) {
+		opts.discriminator = d
+	}
+}
+
+// WithEncryption enables the application-level encryption of the model fields tagged with `fm:"encrypt"`, using
+// the keys supplied by p. Tagged fields are stored as AES-GCM encrypted BSON binaries and transparently decrypted on
+// decode.
+//
+// Fields tagged with `fm:"encrypt,deterministic"` always encrypt the same value to the same binary, so that they can
+// be queried by equality with values produced by EncryptValue.
+//
+// The values written to tagged fields by $set, $setOnInsert, $push and $addToSet updates are encrypted as well; other
+// update operators on a tagged field are rejected with ErrInvalidUpdate. An invalid configuration, such as an unknown
+// fm tag option or a KeyProvider without a current key, is returned by every operation of the repository.
+func WithEncryption(p KeyProvider) RepositoryOptsFunc {
+
+	return func(opts *repositoryOpts) {
+		opts.keyProvider = p
+	}
+}
+
+// WithRegistry sets the codec registry of the repository collection. The codecs configured with WithCodecs,
+// WithRegistryBuilder and WithEncryption are registered on a registry derived from reg, which is not modified.
+//
+// Without this option the repository starts from the registry of the client.
+func WithRegistry(reg *bsoncodec.Registry) RepositoryOptsFunc {
+
+	return func(opts *repositoryOpts) {
+		opts.registry = reg
+	}
+}
+
+// WithRegistryBuilder configures the codec registry of the repository collection with fn.
+func WithRegistryBuilder(fn func(*bsoncodec.Registry)) RepositoryOptsFunc {
+
+	return func(opts *repositoryOpts) {
+		opts.builders = append(opts.builders, fn)
+	}
+}
+
+// WithCodecs registers the given codecs on the codec registry of the repository collection.
+func WithCodecs(codecs ...Codec) RepositoryOptsFunc {
+
+	return WithRegistryBuilder(func(reg *bsoncodec.Registry) {
+		for _, codec := range codecs {
+			codec.Register(reg)
+		}
+	})
+}
+
+// WithPageSecret sets the secret signing the continuation tokens returned by FindPage. Repositories sharing the same
+// secret accept each other's tokens, also across processes.
+//
+// Without this option tokens are signed with a random secret and are only valid within the current process.
+func WithPageSecret(secret []byte) RepositoryOptsFunc {
+
+	return func(opts *repositoryOpts) {
+		opts.pageSecret = secret
+	}
+}
+
+// WithInterceptors registers interceptors applying to the operations of the repository. They run after the global
+// interceptors registered with UseInterceptors, in the given order.
+func WithInterceptors(interceptors ...Interceptor) RepositoryOptsFunc {
+
+	return func(opts *repositoryOpts) {
+		opts.interceptors = append(opts.interceptors, interceptors...)
+	}
+}
+
+// WithCache caches the documents found by FindOne, and by FindByID, in c for ttl. Lookups with WithSort,
+// WithProjection, WithSkip or WithCollation, and the ones within a transaction, are not cached.
+//
+// Every write of the repository invalidates all its cached documents, once its transaction, if any, is committed.
+// Writes through other repositories or processes are only seen once the entries expire. The option only applies to
+// BaseRepository.
+func WithCache(c Cache, ttl time.Duration) RepositoryOptsFunc {
+
+	return func(opts *repositoryOpts) {
+		opts.cache = c
+		opts.cacheTTL = ttl
+	}
+}
+
+// buildRegistry returns the registry of a repository storing values of types. It is base unless the options require a
+// dedicated registry, in which case custom is true and the registry is derived from base, or from the registry set
+// with WithRegistry, with the configured codecs and the encryption codecs, whose update encryption is returned as enc.
+// Neither base nor the registry set with WithRegistry are modified.
+//
+// An invalid encryption configuration is not reported here: the returned registry fails to encode and decode the
+// models with it, and enc fails every update, so that it is returned by the first operation of the repository.
+func (o repositoryOpts) buildRegistry(
+	base *bsoncodec.Registry,
+	types []reflect.Type,
+) (reg *bsoncodec.Registry, custom bool, enc *fieldEncryption) {
+
+	if o.registry == nil && len(o.builders) == 0 && o.keyProvider == nil {
+		return base, false, nil
+	}
+
+	if o.registry != nil {
+		base = o.registry
+	}
+	if len(o.builders) == 0 && o.keyProvider == nil {
+		return base, true, nil
+	}
+
+	reg = deriveRegistry(base)
+	for _, build := range o.builders {
+		build(reg)
+	}
+
+	if o.keyProvider != nil {
+		var err error
+		if enc, err = registerEncryptionCodecs(reg, o.keyProvider, types...); err != nil {
+			err = fmt.Errorf("could not register encryption codecs: %w", err)
+			registerFailingCodec(reg, err, types...)
+			enc = &fieldEncryption{err: err}
+		}
+	}
+
+	return reg, true, enc
+}
@@ -5,11 +5,22 @@ import (
 	"testing"
 
 	fm "github.com/pmatteo/friendlymongo"
+	"github.com/pmatteo/friendlymongo/filter"
+	"github.com/pmatteo/friendlymongo/update"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Compile-time checks that repositories of concrete and interface model types satisfy the repository interfaces.
+var (
+	_ fm.MongoRepository[*customModel] = (*fm.BaseRepository[*customModel])(nil)
+	_ fm.Reader[*artwork]              = (*fm.BaseRepository[*artwork])(nil)
+	_ fm.Writer[event]                 = (*fm.BaseRepository[event])(nil)
+	_ fm.Aggregator                    = (*fm.BaseRepository[*otherModel])(nil)
 )
 
 var basicAddress = &address{
@@ -19,6 +30,7 @@ var basicAddress = &address{
 }
 
 func TestInserts(t *testing.T) {
+	requireMongo(t)
 	t.Run("DefaultInsert", testDefaultInsert)
 	t.Run("TestInsertMany", testInsertMany)
 }
@@ -46,11 +58,12 @@ func testInsertMany(t *testing.T) {
 		newCustomModel("Insert Many 2", "many2@test.com", true, basicAddress),
 	}
 
-	err := repo.InsertMany(context.Background(), models)
+	_, err := repo.InsertMany(context.Background(), models)
 	require.NoError(t, err)
 }
 
 func TestFinds(t *testing.T) {
+	requireMongo(t)
 	t.Run("TestFindOne", testFindOne)
 	t.Run("TestFindMany", testFindMany)
 }
@@ -107,7 +120,22 @@ func testFindMany(t *testing.T) {
 	assert.Equal(t, "Insert Many 2", models[1].Name)
 }
 
+func TestMongoRepository_Interface(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	var r fm.MongoRepository[*customModel] = newCustomModelRepo()
+
+	model := newCustomModel("through interface", "interface@test.com", true, basicAddress)
+	require.NoError(t, r.InsertOne(context.Background(), model))
+
+	found, err := r.FindOne(context.Background(), bson.M{"email": "interface@test.com"})
+	require.NoError(t, err)
+	assert.Equal(t, model.ID, found.ID)
+}
+
 func TestUpdateOne_WithBsonM(t *testing.T) {
+	requireMongo(t)
 
 	model := newCustomModel("to update with bsonM", "toupdate.withbsonM@test.com", false, basicAddress)
 
@@ -116,14 +144,63 @@ func TestUpdateOne_WithBsonM(t *testing.T) {
 
 	filter := bson.M{"email": "toupdate.withbsonM@test.com"}
 
-	updated, err := repo.UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"active": false}})
+	update := bson.M{"$set": bson.M{"active": false}}
+	updated, err := repo.UpdateOne(context.Background(), filter, update)
 	require.NoError(t, err)
 
 	assert.Equal(t, model.ID, updated.ID)
 	assert.False(t, updated.Active)
+	assert.NotContains(t, update, "$currentDate", "the update of the caller is left untouched")
+}
+
+func TestUpdateOne_WithBuilder(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "updateBuilder")
+	ctx := context.Background()
+
+	a := &artwork{Title: "Dancer", Artist: "Miro", Year: 1925, Price: 76, Tags: []string{"oil", "draft"}}
+	require.NoError(t, r.InsertOne(ctx, a))
+
+	u := update.New().
+		Mul("price", 2).
+		Max("year", 1930).
+		Pull("tags", "draft").
+		Rename("artist", "painter")
+	updated, err := r.UpdateOne(ctx, filter.Eq("_id", a.ID), u, fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, float32(152), updated.Price)
+	assert.Equal(t, 1930, updated.Year)
+	assert.Equal(t, []string{"oil"}, updated.Tags)
+	assert.Empty(t, updated.Artist)
+
+	u = update.New().
+		Push("tags", update.Each("b", "a", "c"), update.Sort(1), update.Slice(3)).
+		SetOnInsert("title", "never")
+	updated, err = r.UpdateOne(ctx, filter.Eq("_id", a.ID), u, fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, updated.Tags)
+	assert.Equal(t, "Dancer", updated.Title)
+
+	u = update.New().Set("price", 10).SetOnInsert("title", "Woman")
+	inserted, err := r.UpdateOne(ctx, filter.Eq("artist", "Miro"), u,
+		fm.WithUpsert(true), fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, "Woman", inserted.Title)
+	assert.NotEqual(t, a.ID, inserted.ID)
+
+	res, err := r.UpdateMany(ctx, bson.M{}, update.New().Inc("year", 1).AddToSet("tags", update.Each("x", "a")))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.ModifiedCount)
+
+	_, err = r.UpdateOne(ctx, bson.M{}, update.New().Set("tags", nil).Push("tags", "y"))
+	assert.ErrorIs(t, err, fm.ErrInvalidUpdate)
+	assert.ErrorIs(t, err, update.ErrConflict)
 }
 
 func TestUpdateOne_WithModel(t *testing.T) {
+	requireMongo(t)
 	model := newCustomModel("to update with model", "toupdate.withmodel@test.com", false, basicAddress)
 
 	err := repo.InsertOne(context.Background(), model)
@@ -142,13 +219,14 @@ func TestUpdateOne_WithModel(t *testing.T) {
 
 func TestDelete_Many(t *testing.T) {
 	t.Parallel()
+	requireMongo(t)
 
 	models := []*customModel{
 		newCustomModel("to delete many 1", "todelete1@test.com", false, basicAddress),
 		newCustomModel("to delete many 2", "todelete2@test.com", false, basicAddress),
 	}
 
-	err := repo.InsertMany(context.Background(), models)
+	_, err := repo.InsertMany(context.Background(), models)
 	require.NoError(t, err)
 
 	filter := bson.M{
@@ -167,6 +245,7 @@ func TestDelete_Many(t *testing.T) {
 
 func TestDelete_One(t *testing.T) {
 	t.Parallel()
+	requireMongo(t)
 
 	model := newCustomModel("to delete", "todelete@test.com", false, basicAddress)
 
@@ -182,6 +261,7 @@ func TestDelete_One(t *testing.T) {
 
 func TestReplaceOne(t *testing.T) {
 	t.Parallel()
+	requireMongo(t)
 
 	model := newCustomModel("to replace", "toreplace@test.com", false, basicAddress)
 
@@ -196,8 +276,9 @@ func TestReplaceOne(t *testing.T) {
 	filter := bson.M{
 		"email": "toreplace@test.com",
 	}
-	err = repo.ReplaceOne(context.Background(), filter, replace)
+	before, err := repo.ReplaceOne(context.Background(), filter, replace)
 	require.NoError(t, err)
+	assert.Equal(t, "to replace", before.Name)
 
 	found, err := repo.FindOne(context.Background(), bson.M{
 		"email": "replaced@test.com",
@@ -212,6 +293,7 @@ func TestReplaceOne(t *testing.T) {
 
 func TestAggregate_Simple(t *testing.T) {
 	t.Parallel()
+	requireMongo(t)
 
 	models := []*customModel{
 		newCustomModel("to aggregate 1", "aggregate2@test.com", false, basicAddress),
@@ -219,7 +301,7 @@ func TestAggregate_Simple(t *testing.T) {
 		newCustomModel("to aggregate 3", "aggregate3@test.com", true, basicAddress),
 	}
 
-	err := repo.InsertMany(context.Background(), models)
+	_, err := repo.InsertMany(context.Background(), models)
 	require.NoError(t, err)
 
 	pipeline := mongo.Pipeline{
@@ -238,6 +320,7 @@ func TestAggregate_Simple(t *testing.T) {
 }
 
 func TestAggregate_WithBuilder(t *testing.T) {
+	requireMongo(t)
 
 	t.Parallel()
 
@@ -247,7 +330,7 @@ func TestAggregate_WithBuilder(t *testing.T) {
 		newCustomModel("aggregate builder 3", "aggregate_builder3@test.com", true, basicAddress),
 	}
 
-	err := repo.InsertMany(context.Background(), models)
+	_, err := repo.InsertMany(context.Background(), models)
 	require.NoError(t, err)
 
 	pipeline := fm.
@@ -272,6 +355,7 @@ func TestAggregate_WithBuilder(t *testing.T) {
 
 func TestAggregate_Count(t *testing.T) {
 	t.Parallel()
+	requireMongo(t)
 
 	models := []*customModel{
 		newCustomModel("aggregate count 1", "aggregate_count1@test.com", false, basicAddress),
@@ -279,7 +363,7 @@ func TestAggregate_Count(t *testing.T) {
 		newCustomModel("aggregate count 3", "aggregate_count3@test.com", true, basicAddress),
 	}
 
-	err := repo.InsertMany(context.Background(), models)
+	_, err := repo.InsertMany(context.Background(), models)
 	require.NoError(t, err)
 
 	pipeline := fm.
@@ -301,13 +385,14 @@ func TestAggregate_Count(t *testing.T) {
 
 func TestAggregate_LookupOtherCollection(t *testing.T) {
 	t.Parallel()
+	requireMongo(t)
 
 	models := []*customModel{
 		newCustomModel("aggregate lookup 1", "aggregate_lookup@test.com", false, basicAddress),
 		newCustomModel("aggregate lookup 2", "aggregate_lookup@test.com", false, basicAddress),
 		newCustomModel("aggregate lookup 3", "aggregate_lookup@test.com", true, basicAddress),
 	}
-	err := repo.InsertMany(context.Background(), models)
+	_, err := repo.InsertMany(context.Background(), models)
 	require.NoError(t, err)
 
 	var otherRepo = newOtherModelRepo()
@@ -328,7 +413,7 @@ func TestAggregate_LookupOtherCollection(t *testing.T) {
 			Permissions: []string{"write"},
 		},
 	}
-	err = otherRepo.InsertMany(context.Background(), otherModels)
+	_, err = otherRepo.InsertMany(context.Background(), otherModels)
 	require.NoError(t, err)
 
 	pipeline := fm.
@@ -353,6 +438,7 @@ func TestAggregate_LookupOtherCollection(t *testing.T) {
 }
 
 func TestAggregate_Unwind(t *testing.T) {
+	requireMongo(t)
 
 	t.Parallel()
 
@@ -365,7 +451,7 @@ func TestAggregate_Unwind(t *testing.T) {
 		},
 	}
 
-	err := otherRepo.InsertMany(context.Background(), otherModels)
+	_, err := otherRepo.InsertMany(context.Background(), otherModels)
 	require.NoError(t, err)
 
 	pipeline := fm.
@@ -387,6 +473,7 @@ func TestAggregate_Unwind(t *testing.T) {
 }
 
 func TestAggregate_SortByCount(t *testing.T) {
+	requireMongo(t)
 
 	t.Parallel()
 
@@ -395,7 +482,7 @@ func TestAggregate_SortByCount(t *testing.T) {
 		newCustomModel("aggregate sortByCount 2", "sortByCount2@test.com", false, basicAddress),
 		newCustomModel("aggregate sortByCount 3", "sortByCount3@test.com", true, basicAddress),
 	}
-	err := repo.InsertMany(context.Background(), models)
+	_, err := repo.InsertMany(context.Background(), models)
 	require.NoError(t, err)
 
 	pipeline := fm.NewStageBuilder().
@@ -414,6 +501,7 @@ func TestAggregate_SortByCount(t *testing.T) {
 }
 
 func TestAggregate_Bucket(t *testing.T) {
+	requireMongo(t)
 
 	t.Parallel()
 
@@ -447,6 +535,7 @@ func TestAggregate_Bucket(t *testing.T) {
 }
 
 func TestAggregate_Facet(t *testing.T) {
+	requireMongo(t)
 
 	t.Parallel()
 
@@ -480,3 +569,77 @@ func TestAggregate_Facet(t *testing.T) {
 	assert.Len(t, result[0].CategorizedByTags, 10)
 	assert.Len(t, result[0].CategorizedByPrice, 5)
 }
+
+func newEmptyArtworkRepo(t *testing.T, collection string) *fm.BaseRepository[*artwork] {
+	r := fm.NewBaseRepository(fm.GetInstance().Database(testDB), collection, new(artwork))
+
+	_, err := r.Delete(context.Background(), bson.M{})
+	require.NoError(t, err)
+
+	return r
+}
+
+func TestUpdateMany(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "updateMany")
+	ctx := context.Background()
+
+	_, err := r.InsertMany(ctx, []*artwork{
+		{Title: "Dancer", Artist: "Miro", Price: 76.04},
+		{Title: "Woman", Artist: "Miro", Price: 90},
+		{Title: "Melancholy III", Artist: "Munch", Price: 280},
+	})
+	require.NoError(t, err)
+
+	update := bson.M{"$set": bson.M{"price": 100}}
+	res, err := r.UpdateMany(ctx, bson.M{"artist": "Miro"}, update)
+	require.NoError(t, err)
+	assert.Equal(t, &fm.UpdateResult{MatchedCount: 2, ModifiedCount: 2}, res)
+	assert.NotContains(t, update, "$currentDate", "the update of the caller is left untouched")
+
+	updated, err := r.Find(ctx, bson.M{"price": 100})
+	require.NoError(t, err)
+	assert.Len(t, updated, 2)
+
+	_, err = r.UpdateMany(ctx, bson.M{}, &artwork{})
+	assert.Error(t, err)
+}
+
+func TestDeleteOne_FindOneAndDelete(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "deleteOne")
+	ctx := context.Background()
+
+	_, err := r.InsertMany(ctx, []*artwork{
+		{Title: "job 1", Artist: "queue", Year: 3},
+		{Title: "job 2", Artist: "queue", Year: 1},
+		{Title: "job 3", Artist: "queue", Year: 2},
+	})
+	require.NoError(t, err)
+
+	res, err := r.DeleteOne(ctx, bson.M{"title": "job 1"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), res.DeletedCount)
+
+	res, err = r.DeleteOne(ctx, bson.M{"title": "job 1"})
+	require.NoError(t, err)
+	assert.Zero(t, res.DeletedCount)
+
+	next, err := r.FindOneAndDelete(ctx, bson.M{"artist": "queue"}, fm.WithSort(bson.D{{Key: "year", Value: 1}}))
+	require.NoError(t, err)
+	assert.Equal(t, "job 2", next.Title)
+
+	next, err = r.FindOneAndDelete(ctx, bson.M{"artist": "queue"})
+	require.NoError(t, err)
+	assert.Equal(t, "job 3", next.Title)
+
+	_, err = r.FindOneAndDelete(ctx, bson.M{"artist": "queue"})
+	assert.ErrorIs(t, err, mongo.ErrNoDocuments)
+
+	_, err = r.FindOneAndDelete(ctx, bson.M{}, fm.WithLimit(1))
+	assert.Error(t, err)
+}
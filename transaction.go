@@ -0,0 +1,161 @@
+package friendlymongo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+type transactionOpts struct {
+	readConcern    *readconcern.ReadConcern
+	writeConcern   *writeconcern.WriteConcern
+	readPreference *readpref.ReadPref
+	maxCommitTime  *time.Duration
+}
+
+// TransactionOptsFunc configures a transaction started with WithTransaction.
+type TransactionOptsFunc func(*transactionOpts)
+
+// WithReadConcern sets the read concern of the transaction, e.g. readconcern.Snapshot().
+func WithReadConcern(rc *readconcern.ReadConcern) TransactionOptsFunc {
+
+	return func(opts *transactionOpts) {
+		opts.readConcern = rc
+	}
+}
+
+// WithWriteConcern sets the write concern of the transaction, e.g. writeconcern.Majority().
+func WithWriteConcern(wc *writeconcern.WriteConcern) TransactionOptsFunc {
+
+	return func(opts *transactionOpts) {
+		opts.writeConcern = wc
+	}
+}
+
+// WithReadPreference sets the read preference of the transaction. Transactions must read from the primary.
+func WithReadPreference(rp *readpref.ReadPref) TransactionOptsFunc {
+
+	return func(opts *transactionOpts) {
+		opts.readPreference = rp
+	}
+}
+
+// WithMaxCommitTime sets the maximum amount of time the commit of the transaction can run on the server.
+func WithMaxCommitTime(d time.Duration) TransactionOptsFunc {
+
+	return func(opts *transactionOpts) {
+		opts.maxCommitTime = &d
+	}
+}
+
+func (o transactionOpts) transactionOptions() *options.TransactionOptions {
+
+	opts := options.Transaction()
+	if o.readConcern != nil {
+		opts.SetReadConcern(o.readConcern)
+	}
+	if o.writeConcern != nil {
+		opts.SetWriteConcern(o.writeConcern)
+	}
+	if o.readPreference != nil {
+		opts.SetReadPreference(o.readPreference)
+	}
+	if o.maxCommitTime != nil {
+		opts.SetMaxCommitTime(o.maxCommitTime)
+	}
+
+	return opts
+}
+
+// transactionKey marks the contexts of the callbacks run by WithTransaction, with their *transactionState.
+type transactionKey struct{}
+
+// transactionState holds the functions to run once a transaction is committed.
+type transactionState struct {
+	mu          sync.Mutex
+	afterCommit []func()
+}
+
+// WithTransaction runs fn in a multi-document transaction on client, committing it when fn returns nil and aborting
+// it otherwise.
+//
+// The context passed to fn carries the session of the transaction: every repository operation given this context, or
+// one derived from it, runs within the transaction, whatever the repository. As with the driver, fn is retried as a
+// whole on transient transaction errors, so it must be idempotent, and the commit is retried when its result is
+// unknown.
+//
+// Calling WithTransaction with a context already within a transaction joins it: fn runs in the outer transaction and
+// the options are ignored.
+//
+//	err := friendlymongo.WithTransaction(ctx, client, func(ctx context.Context) error {
+//		if err := orders.InsertOne(ctx, order); err != nil {
+//			return err
+//		}
+//		_, err := stock.UpdateOne(ctx, bson.M{"sku": order.SKU}, bson.M{"$inc": bson.M{"count": -1}})
+//		return err
+//	})
+func WithTransaction(
+	ctx context.Context,
+	client *mongo.Client,
+	fn func(ctx context.Context) error,
+	opts ...TransactionOptsFunc,
+) error {
+
+	if InTransaction(ctx) {
+		return fn(ctx)
+	}
+
+	txOpts := transactionOpts{}
+	for _, opt := range opts {
+		opt(&txOpts)
+	}
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(context.WithoutCancel(ctx))
+
+	var state *transactionState
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// Every attempt starts afresh: the callbacks registered by an aborted one must not run.
+		state = &transactionState{}
+		return nil, fn(context.WithValue(sc, transactionKey{}, state))
+	}, txOpts.transactionOptions())
+
+	if err == nil {
+		for _, f := range state.afterCommit {
+			f()
+		}
+	}
+
+	return err
+}
+
+// InTransaction reports whether ctx is the context of a transaction started with WithTransaction.
+func InTransaction(ctx context.Context) bool {
+
+	_, inTx := ctx.Value(transactionKey{}).(*transactionState)
+	return inTx && mongo.SessionFromContext(ctx) != nil
+}
+
+// afterCommit runs f once the transaction of ctx is committed, or right away when ctx is not within a transaction.
+func afterCommit(ctx context.Context, f func()) {
+
+	state, ok := ctx.Value(transactionKey{}).(*transactionState)
+	if !ok {
+		f()
+		return
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.afterCommit = append(state.afterCommit, f)
+}
@@ -0,0 +1,91 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// requireReplicaSet skips the test when the server does not support transactions.
+func requireReplicaSet(t *testing.T) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := fm.GetInstance().Database("admin").RunCommand(context.Background(), bson.D{{Key: "hello", Value: 1}}).
+		Decode(&hello)
+	require.NoError(t, err)
+
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		t.Skip("transactions require a replica set or a sharded cluster")
+	}
+}
+
+func TestWithTransaction(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+	requireReplicaSet(t)
+
+	ctx := context.Background()
+	client := fm.GetInstance().Client()
+	artworks := newEmptyArtworkRepo(t, "txArtworks")
+	users := fm.NewBaseRepository(fm.GetInstance().Database(testDB), "txUsers", new(customModel))
+	_, err := users.Delete(ctx, bson.M{})
+	require.NoError(t, err)
+
+	err = fm.WithTransaction(ctx, client, func(ctx context.Context) error {
+		assert.True(t, fm.InTransaction(ctx))
+
+		if err := artworks.InsertOne(ctx, &artwork{Title: "committed"}); err != nil {
+			return err
+		}
+
+		// A nested transaction joins the outer one.
+		return fm.WithTransaction(ctx, client, func(ctx context.Context) error {
+			return users.InsertOne(ctx, newCustomModel("committed", "tx@test.com", false, nil))
+		})
+	}, fm.WithWriteConcern(writeconcern.Majority()))
+	require.NoError(t, err)
+
+	count, err := artworks.Count(ctx, bson.M{"title": "committed"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	count, err = users.Count(ctx, bson.M{"email": "tx@test.com"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	errRollback := errors.New("rollback")
+	err = fm.WithTransaction(ctx, client, func(ctx context.Context) error {
+		if err := artworks.InsertOne(ctx, &artwork{Title: "aborted"}); err != nil {
+			return err
+		}
+
+		// Documents written within the transaction are visible to it only.
+		inTx, err := artworks.Exists(ctx, bson.M{"title": "aborted"})
+		require.NoError(t, err)
+		assert.True(t, inTx)
+
+		outside, err := artworks.Exists(context.Background(), bson.M{"title": "aborted"})
+		require.NoError(t, err)
+		assert.False(t, outside)
+
+		return errRollback
+	})
+	assert.ErrorIs(t, err, errRollback)
+
+	exists, err := artworks.Exists(ctx, bson.M{"title": "aborted"})
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestInTransaction(t *testing.T) {
+	t.Parallel()
+
+	assert.False(t, fm.InTransaction(context.Background()))
+}
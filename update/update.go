@@ -0,0 +1,307 @@
+// Package update builds MongoDB update documents, checking that no path is modified twice before they reach the
+// server.
+//
+// A Builder can be passed as the update of friendlymongo's UpdateOne and UpdateMany, including upserts, which also set
+// updatedAt:
+//
+//	u := update.New().
+//		Set("status", "shipped").
+//		Inc("attempts", 1).
+//		Push("events", update.Each(events...), update.Slice(-10))
+//	order, err := repo.UpdateOne(ctx, filter.Eq("_id", id), u)
+package update
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	// ErrConflict is matched by the error of Build when two operators modify the same path, or a path and one of its
+	// prefixes.
+	ErrConflict = errors.New("conflicting update paths")
+
+	// ErrEmpty is matched by the error of Build when the update has no operator.
+	ErrEmpty = errors.New("empty update")
+)
+
+// Builder builds an update document. The values passed to it are never modified, and Build can be called several
+// times.
+type Builder struct {
+	entries []entry
+	err     error
+}
+
+type entry struct {
+	op    string
+	field string
+	value interface{}
+	// paths are the paths modified by the entry: its field, and the destination of a $rename.
+	paths []string
+}
+
+// Values are the values pushed or added to a set at once, built with Each.
+type Values struct {
+	values bson.A
+}
+
+// Each returns values, so that Push and AddToSet add all of them instead of the array itself. Pass a slice as
+// Each(tags...).
+func Each[V any](values ...V) Values {
+
+	a := make(bson.A, len(values))
+	for i, v := range values {
+		a[i] = v
+	}
+
+	return Values{values: a}
+}
+
+type pushOpts struct {
+	slice    *int
+	sort     interface{}
+	position *int
+}
+
+// PushOptsFunc is a modifier of Push.
+type PushOptsFunc func(*pushOpts)
+
+// Slice keeps the first n elements of the array after the push, or the last -n ones when n is negative.
+func Slice(n int) PushOptsFunc {
+
+	return func(opts *pushOpts) {
+		opts.slice = &n
+	}
+}
+
+// Sort sorts the array after the push, either by value with 1 or -1, or by the fields of its documents with a
+// document such as bson.D{{Key: "score", Value: -1}}.
+func Sort(order interface{}) PushOptsFunc {
+
+	return func(opts *pushOpts) {
+		opts.sort = order
+	}
+}
+
+// Position inserts the values at index n of the array instead of appending them, counting from its end when n is
+// negative.
+func Position(n int) PushOptsFunc {
+
+	return func(opts *pushOpts) {
+		opts.position = &n
+	}
+}
+
+// New creates an empty Builder.
+func New() *Builder {
+
+	return &Builder{}
+}
+
+// Set sets field to v.
+func (b *Builder) Set(field string, v interface{}) *Builder {
+
+	return b.add("$set", field, v)
+}
+
+// Unset removes fields.
+func (b *Builder) Unset(fields ...string) *Builder {
+
+	for _, f := range fields {
+		b.add("$unset", f, "")
+	}
+
+	return b
+}
+
+// SetOnInsert sets field to v when an upsert inserts the document.
+func (b *Builder) SetOnInsert(field string, v interface{}) *Builder {
+
+	return b.add("$setOnInsert", field, v)
+}
+
+// Inc increments field by the number by, which can be negative.
+func (b *Builder) Inc(field string, by interface{}) *Builder {
+
+	return b.add("$inc", field, by)
+}
+
+// Mul multiplies field by the number by.
+func (b *Builder) Mul(field string, by interface{}) *Builder {
+
+	return b.add("$mul", field, by)
+}
+
+// Min sets field to v when v is less than its current value.
+func (b *Builder) Min(field string, v interface{}) *Builder {
+
+	return b.add("$min", field, v)
+}
+
+// Max sets field to v when v is greater than its current value.
+func (b *Builder) Max(field string, v interface{}) *Builder {
+
+	return b.add("$max", field, v)
+}
+
+// Push appends v to the array field, or each of its values when v is built with Each. The modifiers Slice, Sort and
+// Position apply to the resulting array.
+func (b *Builder) Push(field string, v interface{}, opts ...PushOptsFunc) *Builder {
+
+	o := &pushOpts{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	values, each := v.(Values)
+	if !each && len(opts) == 0 {
+		return b.add("$push", field, v)
+	}
+
+	if !each {
+		values = Values{values: bson.A{v}}
+	}
+
+	push := bson.D{{Key: "$each", Value: values.values}}
+	if o.position != nil {
+		push = append(push, bson.E{Key: "$position", Value: *o.position})
+	}
+	if o.slice != nil {
+		push = append(push, bson.E{Key: "$slice", Value: *o.slice})
+	}
+	if o.sort != nil {
+		push = append(push, bson.E{Key: "$sort", Value: o.sort})
+	}
+
+	return b.add("$push", field, push)
+}
+
+// AddToSet adds v to the array field unless it already contains it, or each of its values when v is built with Each.
+func (b *Builder) AddToSet(field string, v interface{}) *Builder {
+
+	if values, ok := v.(Values); ok {
+		return b.add("$addToSet", field, bson.D{{Key: "$each", Value: values.values}})
+	}
+
+	return b.add("$addToSet", field, v)
+}
+
+// Pull removes the elements of the array field equal to cond, or matching it when cond is a condition such as
+// bson.D{{Key: "$lt", Value: 5}}, or a filter on the fields of its documents.
+func (b *Builder) Pull(field string, cond interface{}) *Builder {
+
+	return b.add("$pull", field, cond)
+}
+
+// Rename renames the field from to to.
+func (b *Builder) Rename(from, to string) *Builder {
+
+	return b.add("$rename", from, to, to)
+}
+
+// Build returns the update document, with the operators in the order they were first used. It returns an error
+// matching ErrConflict when two operators modify the same path, or a path and one of its prefixes.
+func (b *Builder) Build() (bson.D, error) {
+
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	if len(b.entries) == 0 {
+		return nil, ErrEmpty
+	}
+
+	if err := b.checkConflicts(); err != nil {
+		return nil, err
+	}
+
+	var update bson.D
+	index := make(map[string]int)
+	for _, e := range b.entries {
+		i, ok := index[e.op]
+		if !ok {
+			i = len(update)
+			index[e.op] = i
+			update = append(update, bson.E{Key: e.op, Value: bson.D{}})
+		}
+
+		update[i].Value = append(update[i].Value.(bson.D), bson.E{Key: e.field, Value: e.value})
+	}
+
+	return update, nil
+}
+
+// JSON renders the update as indented relaxed extended JSON, for debugging, or the error of Build.
+func (b *Builder) JSON() string {
+
+	update, err := b.Build()
+	if err != nil {
+		return err.Error()
+	}
+
+	j, err := bson.MarshalExtJSONIndent(update, false, false, "", "  ")
+	if err != nil {
+		return fmt.Sprint(update)
+	}
+
+	return string(j)
+}
+
+// add records the update of field by op, which also modifies the other paths.
+func (b *Builder) add(op, field string, v interface{}, other ...string) *Builder {
+
+	paths := append([]string{field}, other...)
+	for _, p := range paths {
+		if err := checkField(p); err != nil && b.err == nil {
+			b.err = fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	b.entries = append(b.entries, entry{op: op, field: field, value: v, paths: paths})
+
+	return b
+}
+
+func (b *Builder) checkConflicts() error {
+
+	type modified struct {
+		op   string
+		path string
+	}
+
+	var seen []modified
+	for _, e := range b.entries {
+		for _, p := range e.paths {
+			for _, s := range seen {
+				if overlaps(p, s.path) {
+					return fmt.Errorf("%w: %s %s and %s %s", ErrConflict, s.op, s.path, e.op, p)
+				}
+			}
+			seen = append(seen, modified{op: e.op, path: p})
+		}
+	}
+
+	return nil
+}
+
+// overlaps reports whether a and b are the same path, or one is a prefix of the other.
+func overlaps(a, b string) bool {
+
+	return a == b || strings.HasPrefix(a, b+".") || strings.HasPrefix(b, a+".")
+}
+
+func checkField(field string) error {
+
+	if field == "" {
+		return errors.New("empty field name")
+	}
+
+	if strings.HasPrefix(field, "$") {
+		return fmt.Errorf("invalid field name %s", field)
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package update_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	fm "github.com/pmatteo/friendlymongo"
+	"github.com/pmatteo/friendlymongo/filter"
+	"github.com/pmatteo/friendlymongo/update"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type account struct {
+	fm.BaseModel `bson:",inline"`
+
+	Owner   string   `bson:"owner"`
+	Balance int      `bson:"balance"`
+	Plan    string   `bson:"plan,omitempty"`
+	Tags    []string `bson:"tags,omitempty"`
+}
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		builder *update.Builder
+		want    bson.D
+	}{
+		{
+			"operators in order of first use",
+			update.New().Set("a", 1).Inc("n", 2).Set("b", 3).Unset("c", "d"),
+			bson.D{
+				{Key: "$set", Value: bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 3}}},
+				{Key: "$inc", Value: bson.D{{Key: "n", Value: 2}}},
+				{Key: "$unset", Value: bson.D{{Key: "c", Value: ""}, {Key: "d", Value: ""}}},
+			},
+		},
+		{
+			"arithmetic",
+			update.New().Mul("price", 1.1).Min("low", 3).Max("high", 9),
+			bson.D{
+				{Key: "$mul", Value: bson.D{{Key: "price", Value: 1.1}}},
+				{Key: "$min", Value: bson.D{{Key: "low", Value: 3}}},
+				{Key: "$max", Value: bson.D{{Key: "high", Value: 9}}},
+			},
+		},
+		{
+			"push one value",
+			update.New().Push("tags", "a"),
+			bson.D{{Key: "$push", Value: bson.D{{Key: "tags", Value: "a"}}}},
+		},
+		{
+			"push with modifiers",
+			update.New().Push("scores", update.Each(3, 1), update.Sort(-1), update.Slice(-5), update.Position(0)),
+			bson.D{{Key: "$push", Value: bson.D{{Key: "scores", Value: bson.D{
+				{Key: "$each", Value: bson.A{3, 1}},
+				{Key: "$position", Value: 0},
+				{Key: "$slice", Value: -5},
+				{Key: "$sort", Value: -1},
+			}}}}},
+		},
+		{
+			"push one value with modifiers",
+			update.New().Push("scores", 3, update.Slice(2)),
+			bson.D{{Key: "$push", Value: bson.D{{Key: "scores", Value: bson.D{
+				{Key: "$each", Value: bson.A{3}},
+				{Key: "$slice", Value: 2},
+			}}}}},
+		},
+		{
+			"add to set",
+			update.New().AddToSet("a", "x").AddToSet("b", update.Each([]string{"y", "z"}...)),
+			bson.D{{Key: "$addToSet", Value: bson.D{
+				{Key: "a", Value: "x"},
+				{Key: "b", Value: bson.D{{Key: "$each", Value: bson.A{"y", "z"}}}},
+			}}},
+		},
+		{
+			"pull, rename and set on insert",
+			update.New().Pull("tags", filter.Eq("x", 1)).Rename("old", "new").SetOnInsert("createdBy", "me"),
+			bson.D{
+				{Key: "$pull", Value: bson.D{{Key: "tags", Value: filter.Eq("x", 1)}}},
+				{Key: "$rename", Value: bson.D{{Key: "old", Value: "new"}}},
+				{Key: "$setOnInsert", Value: bson.D{{Key: "createdBy", Value: "me"}}},
+			},
+		},
+		{
+			"sibling paths",
+			update.New().Set("a.b", 1).Set("a.bc", 2).Inc("ab", 1),
+			bson.D{
+				{Key: "$set", Value: bson.D{{Key: "a.b", Value: 1}, {Key: "a.bc", Value: 2}}},
+				{Key: "$inc", Value: bson.D{{Key: "ab", Value: 1}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.builder.Build()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBuild_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		builder *update.Builder
+		want    error
+	}{
+		{"same path", update.New().Set("a", 1).Inc("a", 1), update.ErrConflict},
+		{"same operator", update.New().Push("tags", 1).Push("tags", 2), update.ErrConflict},
+		{"prefix", update.New().Set("a", bson.D{}).Unset("a.b"), update.ErrConflict},
+		{"set on insert", update.New().SetOnInsert("a.b", 1).Set("a", 1), update.ErrConflict},
+		{"rename destination", update.New().Rename("a", "b").Set("b.c", 1), update.ErrConflict},
+		{"empty", update.New(), update.ErrEmpty},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.builder.Build()
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+
+	_, err := update.New().Set("", 1).Build()
+	assert.EqualError(t, err, "$set: empty field name")
+
+	_, err = update.New().Rename("a", "$b").Build()
+	assert.EqualError(t, err, "$rename: invalid field name $b")
+}
+
+func TestBuild_LeavesValuesUntouched(t *testing.T) {
+	t.Parallel()
+
+	tags := []string{"a", "b"}
+	b := update.New().Set("owner", "ada").AddToSet("tags", update.Each(tags...))
+
+	first, err := b.Build()
+	require.NoError(t, err)
+
+	first[0].Value = "changed"
+
+	second, err := b.Build()
+	require.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "owner", Value: "ada"}}, second[0].Value)
+	assert.Equal(t, []string{"a", "b"}, tags)
+}
+
+func TestJSON(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `{
+  "$set": {
+    "owner": "ada"
+  }
+}`, update.New().Set("owner", "ada").JSON())
+
+	assert.Contains(t, update.New().Set("a", 1).Set("a", 2).JSON(), "conflicting update paths")
+}
+
+func TestRepository(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	r := fm.NewMemoryRepository(new(account))
+
+	a := &account{Owner: "ada", Balance: 10, Tags: []string{"new"}}
+	require.NoError(t, r.InsertOne(ctx, a))
+
+	before := time.Now().Add(-time.Second)
+	u := update.New().Inc("balance", 5).Set("plan", "pro").Push("tags", update.Each("vip", "beta"))
+	old, err := r.UpdateOne(ctx, filter.Eq("owner", "ada"), u)
+	require.NoError(t, err)
+	assert.Equal(t, 10, old.Balance)
+
+	found, err := r.FindByID(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 15, found.Balance)
+	assert.Equal(t, "pro", found.Plan)
+	assert.Equal(t, []string{"new", "vip", "beta"}, found.Tags)
+	assert.True(t, found.UpdatedAt.After(before))
+
+	at := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	_, err = r.UpdateOne(ctx, filter.Eq("owner", "ada"), update.New().Set("updatedAt", at))
+	require.NoError(t, err)
+
+	found, err = r.FindByID(ctx, a.ID)
+	require.NoError(t, err)
+	assert.True(t, found.UpdatedAt.Equal(at), "an explicit updatedAt is kept")
+
+	u = update.New().Set("balance", 1).SetOnInsert("plan", "free")
+	inserted, err := r.UpdateOne(ctx, filter.Eq("owner", "alan"), u,
+		fm.WithUpsert(true), fm.WithReturnDocument(options.After))
+	require.NoError(t, err)
+	assert.Equal(t, "alan", inserted.Owner)
+	assert.Equal(t, "free", inserted.Plan)
+
+	res, err := r.UpdateMany(ctx, filter.Exists("plan", true), update.New().Unset("plan"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.ModifiedCount)
+
+	_, err = r.UpdateMany(ctx, bson.M{}, update.New().Inc("balance", 1).Set("balance", 0))
+	assert.ErrorIs(t, err, fm.ErrInvalidUpdate)
+	assert.ErrorIs(t, err, update.ErrConflict)
+
+	bulk, err := r.Bulk().
+		UpdateOne(filter.Eq("owner", "ada"), update.New().Inc("balance", 5)).
+		UpdateMany(bson.M{}, update.New().Set("plan", "team")).
+		Execute(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), bulk.ModifiedCount)
+
+	found, err = r.FindByID(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 20, found.Balance)
+	assert.Equal(t, "team", found.Plan)
+}
@@ -0,0 +1,314 @@
+package friendlymongo
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UpsertResult is the outcome of an upsert.
+type UpsertResult[T Model] struct {
+	// Document is the document as stored after the upsert.
+	Document T
+	// Inserted is true when no document matched the filter and Document was inserted, false when an existing document
+	// was updated.
+	Inserted bool
+}
+
+// UpsertOne updates the first document matching filter with the fields of document, or inserts document when none
+// matches.
+//
+// OnCreate, then OnUpdate, are invoked on document beforehand. The _id and createdAt fields, of the document and of
+// its embedded Models, are only written when the document is inserted, through $setOnInsert, while the other fields,
+// updatedAt included, are written in both cases: an existing document keeps its ID and creation time. Once the upsert
+// is done, document holds the stored document.
+func (r *BaseRepository[T]) UpsertOne(
+	ctx context.Context,
+	filter interface{},
+	document T,
+) (*UpsertResult[T], error) {
+
+	op := &Operation{
+		Kind:       "UpsertOne",
+		Collection: r.collection.Name(),
+		Filter:     filter,
+		Documents:  []interface{}{document},
+	}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*UpsertResult[T], error) {
+		document, err := modelOf[T](op)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.upsertOne(ctx, op.Filter, document)
+	})
+}
+
+func (r *BaseRepository[T]) upsertOne(
+	ctx context.Context,
+	filter interface{},
+	document T,
+) (_ *UpsertResult[T], err error) {
+
+	defer r.wrapErr(&err, "UpsertOne", filter)
+	defer r.invalidate(ctx)
+
+	update, err := upsertUpdate(r.registry, r.discriminator, document, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.upsert(ctx, filter, update, document)
+}
+
+// UpsertByID updates the document with the given ID with the fields of document, or inserts document with that ID
+// when it does not exist. The _id of document is ignored and, once the upsert is done, set to id. As with FindByID, a
+// hex string id matches the ObjectID it encodes as well, which an inserted document then takes. See UpsertOne.
+func (r *BaseRepository[T]) UpsertByID(
+	ctx context.Context,
+	id interface{},
+	document T,
+) (*UpsertResult[T], error) {
+
+	op := &Operation{
+		Kind:       "UpsertByID",
+		Collection: r.collection.Name(),
+		Filter:     idFilter(id),
+		Documents:  []interface{}{document},
+	}
+	return intercept(ctx, r.interceptors, op, func(ctx context.Context, op *Operation) (*UpsertResult[T], error) {
+		document, err := modelOf[T](op)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.upsertByID(ctx, op.Filter, id, document)
+	})
+}
+
+func (r *BaseRepository[T]) upsertByID(
+	ctx context.Context,
+	filter interface{},
+	id interface{},
+	document T,
+) (_ *UpsertResult[T], err error) {
+
+	defer r.wrapErr(&err, "UpsertByID", filter)
+	defer r.invalidate(ctx)
+
+	update, err := upsertUpdate(r.registry, r.discriminator, document, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.upsert(ctx, filter, insertWithID(update, id), document)
+}
+
+func (r *BaseRepository[T]) upsert(ctx context.Context, filter interface{}, update bson.D, document T) (*UpsertResult[T], error) {
+
+	// The document as it was before the update tells atomically whether it existed, and the update only writes
+	// literal values: applying it to that image gives the stored document without reading it back.
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	var before bson.D
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	inserted := errors.Is(err, mongo.ErrNoDocuments)
+	if err != nil && !inserted {
+		return nil, err
+	}
+
+	if inserted {
+		raw, err := bson.MarshalWithRegistry(r.registry, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		var f bson.D
+		if err := bson.Unmarshal(raw, &f); err != nil {
+			return nil, err
+		}
+		if before, err = upsertDocument(f); err != nil {
+			return nil, err
+		}
+	}
+
+	stored, err := applyUpdate(before, update, time.Now(), inserted)
+	if err != nil {
+		return nil, err
+	}
+
+	return upsertResult(r.registry, r.discriminator, stored, inserted, document)
+}
+
+// upsertUpdate invokes OnCreate, then OnUpdate, on document and returns the update upserting it. The _id and
+// createdAt fields of the document and of its subdocuments are written through $setOnInsert, as OnCreate set them,
+// and the other fields through $set, as OnUpdate left them. Subdocuments holding such fields are written field by
+// field, so that an embedded Model keeps its ID and creation time as well; arrays are written whole. The top-level _id
+// is only written when withID is true, and generated when the document has none.
+func upsertUpdate(
+	registry *bsoncodec.Registry,
+	discriminator *Discriminator,
+	document Model,
+	withID bool,
+) (bson.D, error) {
+
+	runHooks(document, onCreate)
+	created, err := marshalUpsert(registry, discriminator, document)
+	if err != nil {
+		return nil, err
+	}
+
+	runHooks(document, onUpdate)
+	updated, err := marshalUpsert(registry, discriminator, document)
+	if err != nil {
+		return nil, err
+	}
+
+	id, hasID := lookupKey(created, "_id")
+	created, updated = withoutKey(created, "_id"), withoutKey(updated, "_id")
+
+	set, setOnInsert := bson.D{}, bson.D{}
+	if withID {
+		if !hasID {
+			id = primitive.NewObjectID()
+		}
+		setOnInsert = append(setOnInsert, bson.E{Key: "_id", Value: id})
+	}
+	splitUpsert(created, updated, "", &set, &setOnInsert)
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(setOnInsert) > 0 {
+		update = append(update, bson.E{Key: "$setOnInsert", Value: setOnInsert})
+	}
+
+	return update, nil
+}
+
+// insertWithID makes update give id as _id to the document it inserts, which the filter of UpsertByID does not hold
+// when it matches both forms of a hex string id. A hex string id is inserted as the ObjectID it encodes.
+func insertWithID(update bson.D, id interface{}) bson.D {
+
+	e := bson.E{Key: "_id", Value: idValues(id)[0]}
+	for i, op := range update {
+		if op.Key == "$setOnInsert" {
+			fields, _ := op.Value.(bson.D)
+			update[i].Value = append(bson.D{e}, fields...)
+			return update
+		}
+	}
+
+	return append(update, bson.E{Key: "$setOnInsert", Value: bson.D{e}})
+}
+
+func marshalUpsert(registry *bsoncodec.Registry, discriminator *Discriminator, document interface{}) (bson.D, error) {
+
+	raw, err := marshalDocument(registry, discriminator, document)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.D
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// splitUpsert adds the fields of updated to set and the insert-only fields of created to setOnInsert, with their paths
+// prefixed by prefix.
+func splitUpsert(created, updated bson.D, prefix string, set, setOnInsert *bson.D) {
+
+	for _, e := range updated {
+		if insertOnly(e.Key) {
+			continue
+		}
+
+		if sub, ok := e.Value.(bson.D); ok && holdsInsertOnly(sub) {
+			createdSub, _ := lookupKey(created, e.Key)
+			d, _ := createdSub.(bson.D)
+			splitUpsert(d, sub, prefix+e.Key+".", set, setOnInsert)
+			continue
+		}
+
+		*set = append(*set, bson.E{Key: prefix + e.Key, Value: e.Value})
+	}
+
+	for _, e := range created {
+		if insertOnly(e.Key) {
+			*setOnInsert = append(*setOnInsert, bson.E{Key: prefix + e.Key, Value: e.Value})
+		}
+	}
+}
+
+func insertOnly(key string) bool {
+
+	return key == "_id" || key == "createdAt"
+}
+
+// holdsInsertOnly reports whether doc, or one of its subdocuments, has an insert-only field. A document whose keys
+// cannot be used in a dotted path is always written whole.
+func holdsInsertOnly(doc bson.D) bool {
+
+	for _, e := range doc {
+		if e.Key == "" || strings.Contains(e.Key, ".") || strings.HasPrefix(e.Key, "$") {
+			return false
+		}
+	}
+
+	for _, e := range doc {
+		if insertOnly(e.Key) {
+			return true
+		}
+		if sub, ok := e.Value.(bson.D); ok && holdsInsertOnly(sub) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func withoutKey(doc bson.D, key string) bson.D {
+
+	res := make(bson.D, 0, len(doc))
+	for _, e := range doc {
+		if e.Key != key {
+			res = append(res, e)
+		}
+	}
+
+	return res
+}
+
+// upsertResult decodes stored, the document as stored by an upsert, into the result and into document, so that the
+// latter holds the ID and creation time of the document it updated.
+func upsertResult[T Model](
+	registry *bsoncodec.Registry,
+	discriminator *Discriminator,
+	stored bson.D,
+	inserted bool,
+	document T,
+) (*UpsertResult[T], error) {
+
+	raw, err := bson.Marshal(stored)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &UpsertResult[T]{Inserted: inserted}
+	if res.Document, err = decodeDocument[T](rawDecoder{raw: raw, registry: registry}, discriminator); err != nil {
+		return nil, err
+	}
+	if err := bson.UnmarshalWithRegistry(registry, raw, document); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
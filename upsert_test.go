@@ -0,0 +1,110 @@
+package friendlymongo_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestBaseRepository_UpsertOne(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "upsertOne")
+	ctx := context.Background()
+
+	res, err := r.UpsertOne(ctx, bson.M{"title": "Dancer"}, &artwork{Title: "Dancer", Artist: "Miro", Year: 1925})
+	require.NoError(t, err)
+	assert.True(t, res.Inserted)
+	assert.False(t, res.Document.ID.IsZero())
+	assert.False(t, res.Document.CreatedAt.IsZero())
+	assert.Equal(t, "Miro", res.Document.Artist)
+
+	inserted := res.Document
+
+	res, err = r.UpsertOne(ctx, bson.M{"title": "Dancer"}, &artwork{Title: "Dancer", Artist: "Miró", Price: 80})
+	require.NoError(t, err)
+	assert.False(t, res.Inserted)
+	assert.Equal(t, inserted.ID, res.Document.ID)
+	assert.Equal(t, inserted.CreatedAt, res.Document.CreatedAt)
+	assert.Equal(t, "Miró", res.Document.Artist)
+	assert.Equal(t, 1925, res.Document.Year)
+
+	count, err := r.Count(ctx, bson.M{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestBaseRepository_UpsertByID(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newEmptyArtworkRepo(t, "upsertByID")
+	ctx := context.Background()
+
+	id := primitive.NewObjectID()
+	doc := &artwork{Title: "Melancholy III", Artist: "Munch"}
+	res, err := r.UpsertByID(ctx, id, doc)
+	require.NoError(t, err)
+	assert.True(t, res.Inserted)
+	assert.Equal(t, id, res.Document.ID)
+	assert.Equal(t, id, doc.ID)
+
+	inserted := res.Document
+
+	res, err = r.UpsertByID(ctx, id, &artwork{Title: "Melancholy III", Artist: "Munch", Price: 280})
+	require.NoError(t, err)
+	assert.False(t, res.Inserted)
+	assert.Equal(t, id, res.Document.ID)
+	assert.Equal(t, inserted.CreatedAt, res.Document.CreatedAt)
+	assert.Equal(t, float32(280), res.Document.Price)
+
+	res, err = r.UpsertByID(ctx, id.Hex(), &artwork{Title: "Melancholy III", Artist: "Munch", Year: 1902})
+	require.NoError(t, err)
+	assert.False(t, res.Inserted, "a hex string ID matches the ObjectID")
+	assert.Equal(t, id, res.Document.ID)
+	assert.Equal(t, 1902, res.Document.Year)
+
+	hexID := primitive.NewObjectID()
+	res, err = r.UpsertByID(ctx, hexID.Hex(), &artwork{Title: "The Scream", Artist: "Munch"})
+	require.NoError(t, err)
+	assert.True(t, res.Inserted)
+	assert.Equal(t, hexID, res.Document.ID, "a hex string ID is inserted as an ObjectID")
+
+	found, err := r.FindByID(ctx, hexID)
+	require.NoError(t, err)
+	assert.Equal(t, "The Scream", found.Title)
+}
+
+func TestBaseRepository_UpsertNested(t *testing.T) {
+	t.Parallel()
+	requireMongo(t)
+
+	r := newOrderRepo()
+	ctx := context.Background()
+
+	code := primitive.NewObjectID().Hex()
+	res, err := r.UpsertOne(ctx, bson.M{"code": code}, &order{Code: code, Shipment: &shipment{Carrier: "ups"}})
+	require.NoError(t, err)
+	require.True(t, res.Inserted)
+
+	inserted := res.Document
+
+	o := &order{Code: code, Shipment: &shipment{Carrier: "dhl"}}
+	res, err = r.UpsertOne(ctx, bson.M{"code": code}, o)
+	require.NoError(t, err)
+	assert.False(t, res.Inserted)
+	assert.Equal(t, inserted.ID, res.Document.ID)
+	assert.Equal(t, inserted.Shipment.ID, res.Document.Shipment.ID)
+	assert.Equal(t, inserted.Shipment.CreatedAt, res.Document.Shipment.CreatedAt)
+	assert.Equal(t, "dhl", res.Document.Shipment.Carrier)
+	assert.Equal(t, inserted.ID, o.ID)
+
+	found, err := r.FindByID(ctx, inserted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, res.Document, found)
+}